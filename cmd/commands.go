@@ -0,0 +1,176 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"text/tabwriter"
+
+	"servit-go/internal/config"
+	"servit-go/internal/db"
+	"servit-go/internal/logging"
+	"servit-go/internal/protocol"
+	"servit-go/internal/routes"
+)
+
+const usage = `usage: %s [command] [-config file] [-setting value ...]
+
+commands:
+  schema                              print the JSON Schema of the WebSocket protocol
+  config check                        validate the configuration and print it, secrets redacted
+  migrate status                      list migrations and whether they are applied
+  migrate up [-dry-run]               apply pending migrations
+  migrate down [-n steps] [-dry-run]  revert the last applied migrations
+
+Without a command the server starts. Every setting can be given as a flag
+named after its environment variable, e.g. -scylla-hosts for SCYLLA_HOSTS.
+`
+
+// runCommand executes a CLI subcommand and returns the process exit code.
+func runCommand(args []string) int {
+	switch args[0] {
+	case "schema":
+		schema, err := protocol.Schema()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to generate schema: %v\n", err)
+			return 1
+		}
+		fmt.Println(string(schema))
+		return 0
+	case "config":
+		return runConfig(args[1:])
+	case "migrate":
+		return runMigrate(args[1:])
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n"+usage, args[0], os.Args[0])
+		return 2
+	}
+}
+
+func runMigrate(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintf(os.Stderr, usage, os.Args[0])
+		return 2
+	}
+	fs := flag.NewFlagSet("migrate "+args[0], flag.ContinueOnError)
+	dir := fs.String("dir", "migrations", "migrations directory")
+	dryRun := fs.Bool("dry-run", false, "print statements instead of executing them")
+	steps := fs.Int("n", 1, "number of migrations to revert")
+	loader := config.RegisterFlags(fs)
+	if err := fs.Parse(args[1:]); err != nil {
+		return 2
+	}
+
+	// Only the Scylla settings matter here; InitScylla validates them.
+	cfg, err := loader.Load()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid configuration:\n%v\n", err)
+		return 2
+	}
+	logger := logging.New(cfg.LogLevel, cfg.LogFormat)
+	slog.SetDefault(logger)
+	if err := initScylla(cfg); err != nil {
+		logger.Error("failed to initialize ScyllaDB", "error", err)
+		return 1
+	}
+	defer db.ScyllaSession.Close()
+
+	migrator := db.NewMigrator(db.ScyllaSession, *dir, logger)
+	migrator.DryRun = *dryRun
+
+	switch args[0] {
+	case "status":
+		statuses, err := migrator.Status()
+		if err != nil {
+			logger.Error("failed to read migration status", "error", err)
+			return 1
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "VERSION\tNAME\tSTATUS\tAPPLIED AT\tDOWN")
+		for _, s := range statuses {
+			appliedAt := "-"
+			if !s.AppliedAt.IsZero() {
+				appliedAt = s.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%t\n", s.Version, s.Name, migrationState(s), appliedAt, s.Down != nil)
+		}
+		w.Flush()
+		return 0
+	case "up":
+		n, err := migrator.Up()
+		if err != nil {
+			logger.Error("migration failed", "applied", n, "error", err)
+			return 1
+		}
+		logger.Info("migrations complete", "applied", n, "dry_run", *dryRun)
+		return 0
+	case "down":
+		n, err := migrator.Down(*steps)
+		if err != nil {
+			logger.Error("revert failed", "reverted", n, "error", err)
+			return 1
+		}
+		logger.Info("revert complete", "reverted", n, "dry_run", *dryRun)
+		return 0
+	default:
+		fmt.Fprintf(os.Stderr, "unknown migrate command %q\n\n"+usage, args[0], os.Args[0])
+		return 2
+	}
+}
+
+func runConfig(args []string) int {
+	if len(args) == 0 || args[0] != "check" {
+		fmt.Fprintf(os.Stderr, usage, os.Args[0])
+		return 2
+	}
+	fs := flag.NewFlagSet("config check", flag.ContinueOnError)
+	loader := config.RegisterFlags(fs)
+	if err := fs.Parse(args[1:]); err != nil {
+		return 2
+	}
+	cfg, err := loader.Load()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid configuration:\n%v\n", err)
+		return 1
+	}
+
+	if path := loader.Path(); path != "" {
+		fmt.Printf("config file: %s\n\n", path)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "SETTING\tVALUE\tSOURCE\tRELOAD")
+	for _, s := range cfg.Settings() {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%t\n", s.Key, s.Value, s.Source, s.Reloadable)
+	}
+	w.Flush()
+
+	errs := []error{cfg.Validate(), routes.CheckConfig(cfg)}
+	if scyllaCfg, err := scyllaConfig(cfg); err != nil {
+		errs = append(errs, err)
+	} else if err := scyllaCfg.Validate(); err != nil {
+		errs = append(errs, fmt.Errorf("SCYLLA_*: %w", err))
+	}
+	if err := errors.Join(errs...); err != nil {
+		fmt.Fprintf(os.Stderr, "\ninvalid configuration:\n%v\n", err)
+		return 1
+	}
+	fmt.Println("\nconfiguration is valid")
+	return 0
+}
+
+func migrationState(s db.MigrationStatus) string {
+	switch {
+	case s.Missing:
+		return "missing file"
+	case s.Modified:
+		return "modified"
+	case s.Dirty:
+		return "partially applied"
+	case s.Applied:
+		return "applied"
+	default:
+		return "pending"
+	}
+}
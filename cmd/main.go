@@ -1,45 +1,186 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
 	"fmt"
-	"log"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
 	"servit-go/internal/config"
 	"servit-go/internal/db"
+	"servit-go/internal/health"
+	"servit-go/internal/logging"
+	"servit-go/internal/metrics"
+	"servit-go/internal/middleware"
 	"servit-go/internal/routes"
+	"servit-go/internal/services"
+	"servit-go/internal/tracing"
+	"strings"
+	"syscall"
+	"time"
 
-	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
 )
 
 func main() {
-	// Load environment variables and configuration
-	cfg := config.LoadConfig()
-	db.InitDB(cfg.DatabaseURL)
+	if len(os.Args) > 1 && !strings.HasPrefix(os.Args[1], "-") {
+		os.Exit(runCommand(os.Args[1:]))
+	}
 
-	// Initialize ScyllaDB
-	err := db.InitScylla([]string{"localhost:9042"})
+	// Load defaults, the config file, environment variables and flags.
+	cfg, loader, err := loadConfig(os.Args[1:])
+	if err != nil {
+		os.Exit(2)
+	}
+
+	logger := logging.New(cfg.LogLevel, cfg.LogFormat)
+	slog.SetDefault(logger)
+
+	// Reload on SIGHUP and when the config file changes.
+	watcher := config.NewWatcher(loader, cfg, logger)
+	watcher.Subscribe(func(cfg *config.Config) {
+		logging.SetLevel(cfg.LogLevel)
+	})
+	go watcher.Run(context.Background(), cfg.ConfigWatchInterval)
+
+	exporter, err := tracing.NewExporter(cfg.TraceExporter)
 	if err != nil {
-		fmt.Printf("Failed to initialize ScyllaDB: %v\n", err)
-		return
+		logger.Error("failed to create trace exporter", "error", err)
+		os.Exit(1)
+	}
+	shutdownTracing := tracing.Init(exporter, "servit-go")
+	defer shutdownTracing(context.Background())
+
+	db.InitDB(cfg.DatabaseURL, cfg.DBMaxConns)
+
+	// Initialize ScyllaDB
+	if err := initScylla(cfg); err != nil {
+		logger.Error("failed to initialize ScyllaDB", "error", err)
+		os.Exit(1)
 	}
-	// Run the migrations from the "migrations" directory.
-	if err := db.RunMigrations("migrations"); err != nil {
-		log.Fatalf("failed to run migrations: %v", err)
+	// Run the migrations from the "migrations" directory, unless they are
+	// applied separately with "migrate up".
+	if cfg.AutoMigrate {
+		if err := db.RunMigrations("migrations"); err != nil {
+			logger.Error("failed to run migrations", "error", err)
+			os.Exit(1)
+		}
 	}
 
 	// Initialize Gin router
-	router := gin.Default()
+	router := gin.New()
+	router.Use(gin.Recovery())
+	router.Use(middleware.RequestContext(logger))
+	router.Use(tracing.GinMiddleware())
+	router.Use(middleware.RequestLogger())
+	router.Use(metrics.GinMiddleware())
+
+	checker := health.NewChecker(cfg.HealthTimeout)
+	checker.Add("postgres", health.Postgres(db.DB))
+	checker.Add("scylla", health.Scylla(db.ScyllaSession))
+	checker.Add("migrations", health.Migrations(db.NewMigrator(db.ScyllaSession, "migrations", logger)))
+
+	hub, err := routes.SetupRoutes(router, watcher, checker, logger)
+	if err != nil {
+		logger.Error("failed to set up routes", "error", err)
+		os.Exit(1)
+	}
+
+	server := &http.Server{Addr: ":" + cfg.Port, Handler: router}
+	stop, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+	stopped := make(chan struct{})
+	go func() {
+		<-stop.Done()
+		shutdown(server, hub, checker, cfg, logger)
+		close(stopped)
+	}()
 
-	config := cors.DefaultConfig()
-	config.AllowOrigins = []string{"http://localhost:3000"}
-	config.AllowMethods = []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"}
-	config.AllowHeaders = []string{"Origin", "Content-Type", "Authorization"}
-	router.Use(cors.New(config))
+	logger.Info("starting server", "port", cfg.Port)
+	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		logger.Error("could not start server", "error", err)
+		os.Exit(1)
+	}
+	<-stopped
+	logger.Info("server stopped")
+}
+
+// shutdown fails readiness probes for the drain delay so that load balancers
+// stop routing new connections here, then closes the WebSocket sessions and
+// waits for in-flight requests.
+func shutdown(server *http.Server, hub *services.Hub, checker *health.Checker, cfg *config.Config, logger *slog.Logger) {
+	logger.Info("draining before shutdown", "delay", cfg.DrainDelay)
+	checker.SetDraining(true)
+	time.Sleep(cfg.DrainDelay)
+
+	hub.Shutdown()
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		logger.Warn("shutdown timed out", "error", err)
+	}
+}
+
+// loadConfig loads and validates the configuration, printing any problem to
+// stderr.
+func loadConfig(args []string) (*config.Config, *config.Loader, error) {
+	cfg, loader, err := config.Load(args)
+	if err == nil {
+		err = cfg.Validate()
+	}
+	if err != nil {
+		if !errors.Is(err, flag.ErrHelp) {
+			fmt.Fprintf(os.Stderr, "invalid configuration:\n%v\n", err)
+		}
+		return nil, nil, err
+	}
+	return cfg, loader, nil
+}
 
-	routes.SetupRoutes(router)
+// initScylla connects to the ScyllaDB cluster described by cfg.
+func initScylla(cfg *config.Config) error {
+	scyllaCfg, err := scyllaConfig(cfg)
+	if err != nil {
+		return err
+	}
+	return db.InitScylla(scyllaCfg)
+}
 
-	fmt.Printf("Starting server on port %s\n", cfg.Port)
-	if err := router.Run(":" + cfg.Port); err != nil {
-		fmt.Printf("Could not start server: %v", err)
+// scyllaConfig translates the SCYLLA_* settings.
+func scyllaConfig(cfg *config.Config) (db.ScyllaConfig, error) {
+	dataCenters, err := db.ParseDataCenters(cfg.ScyllaDataCenters)
+	if err != nil {
+		return db.ScyllaConfig{}, fmt.Errorf("SCYLLA_DATACENTERS: %w", err)
+	}
+	var hosts []string
+	for _, h := range strings.Split(cfg.ScyllaHosts, ",") {
+		if h = strings.TrimSpace(h); h != "" {
+			hosts = append(hosts, h)
+		}
 	}
+	return db.ScyllaConfig{
+		Hosts:             hosts,
+		Keyspace:          cfg.ScyllaKeyspace,
+		Replication:       cfg.ScyllaReplication,
+		ReplicationFactor: cfg.ScyllaReplicationFactor,
+		DataCenters:       dataCenters,
+		ReadConsistency:   cfg.ScyllaReadConsistency,
+		WriteConsistency:  cfg.ScyllaWriteConsistency,
+		ProtoVersion:      cfg.ScyllaProtoVersion,
+		Timeout:           cfg.ScyllaTimeout,
+		ConnectTimeout:    cfg.ScyllaConnectTimeout,
+		Retries:           cfg.ScyllaRetries,
+		Username:          cfg.ScyllaUsername,
+		Password:          cfg.ScyllaPassword,
+		TLS:               cfg.ScyllaTLS,
+		TLSCAFile:         cfg.ScyllaTLSCAFile,
+		TLSCertFile:       cfg.ScyllaTLSCertFile,
+		TLSKeyFile:        cfg.ScyllaTLSKeyFile,
+		TLSVerifyHost:     cfg.ScyllaTLSVerifyHost,
+		LocalDC:           cfg.ScyllaLocalDC,
+		TokenAware:        cfg.ScyllaTokenAware,
+	}, nil
 }
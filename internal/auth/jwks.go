@@ -0,0 +1,220 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultJWKSRefresh is how long fetched keys are trusted before the
+	// key set is fetched again.
+	DefaultJWKSRefresh = time.Hour
+	// minJWKSRefresh throttles fetch attempts, successful or not, so that
+	// tokens with made-up kids or an outage of the endpoint can't hammer it.
+	minJWKSRefresh = time.Minute
+)
+
+// JWKS fetches and caches the public keys of a JSON Web Key Set. Keys are
+// refreshed every Refresh interval and when a token names an unknown key,
+// which picks up rotated keys without a restart. Fetches are attempted at
+// most once per minJWKSRefresh; on failures the previously fetched keys stay
+// in use.
+type JWKS struct {
+	URL     string
+	Client  *http.Client
+	Refresh time.Duration
+
+	mu          sync.RWMutex
+	keys        map[string]crypto.PublicKey
+	fetchedAt   time.Time  // of the last successful fetch
+	attemptedAt time.Time  // of the last fetch, successful or not
+	fetchErr    error      // of the last fetch
+	fetchMu     sync.Mutex // serialises fetches
+}
+
+// NewJWKS returns a key set fetched lazily from url.
+func NewJWKS(url string, refresh time.Duration) *JWKS {
+	if refresh <= 0 {
+		refresh = DefaultJWKSRefresh
+	}
+	return &JWKS{
+		URL:     url,
+		Client:  &http.Client{Timeout: 10 * time.Second},
+		Refresh: refresh,
+	}
+}
+
+// Key returns the public key with the given key ID.
+func (s *JWKS) Key(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	s.mu.RLock()
+	key, ok := s.keys[kid]
+	stale := time.Since(s.fetchedAt) > s.Refresh
+	recent := time.Since(s.attemptedAt) < minJWKSRefresh
+	fetchErr := s.fetchErr
+	s.mu.RUnlock()
+
+	if ok && (!stale || recent) {
+		return key, nil
+	}
+	if !ok && recent {
+		if fetchErr != nil {
+			return nil, fetchErr
+		}
+		return nil, fmt.Errorf("unknown key id %q", kid)
+	}
+
+	if err := s.fetch(ctx); err != nil {
+		if ok {
+			// Keep using the cached key while the endpoint is unavailable.
+			return key, nil
+		}
+		return nil, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if key, ok := s.keys[kid]; ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unknown key id %q", kid)
+}
+
+func (s *JWKS) fetch(ctx context.Context) error {
+	s.fetchMu.Lock()
+	defer s.fetchMu.Unlock()
+
+	// Another caller may have fetched while we waited.
+	s.mu.RLock()
+	recent := time.Since(s.attemptedAt) < minJWKSRefresh
+	fetchErr := s.fetchErr
+	s.mu.RUnlock()
+	if recent {
+		return fetchErr
+	}
+
+	keys, err := s.download(ctx)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.attemptedAt = time.Now()
+	s.fetchErr = err
+	if err != nil {
+		return err
+	}
+	s.keys = keys
+	s.fetchedAt = s.attemptedAt
+	return nil
+}
+
+// download fetches and parses the key set.
+func (s *JWKS) download(ctx context.Context) (map[string]crypto.PublicKey, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch JWKS: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch JWKS: unexpected status %s", resp.Status)
+	}
+
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&set); err != nil {
+		return nil, fmt.Errorf("failed to decode JWKS: %w", err)
+	}
+	keys := make(map[string]crypto.PublicKey, len(set.Keys))
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		key, err := jwk.publicKey()
+		if err != nil {
+			// Skip keys we can't use rather than rejecting the whole set.
+			continue
+		}
+		keys[jwk.Kid] = key
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("JWKS contains no usable signing keys")
+	}
+	return keys, nil
+}
+
+// jsonWebKey is an RSA or EC public key in JWK form (RFC 7517).
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func (k jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		if !e.IsInt64() || e.Int64() > 1<<31-1 {
+			return nil, errors.New("RSA exponent out of range")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, errors.New("EC point is not on the curve")
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+	}
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil || len(b) == 0 {
+		return nil, errors.New("invalid base64url integer")
+	}
+	return new(big.Int).SetBytes(b), nil
+}
@@ -0,0 +1,157 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// jwksServer serves a key set that tests can rotate or take down.
+type jwksServer struct {
+	*httptest.Server
+	mu      sync.Mutex
+	keys    map[string]*rsa.PublicKey
+	down    bool
+	fetches int
+}
+
+func newJWKSServer(t *testing.T) *jwksServer {
+	s := &jwksServer{keys: make(map[string]*rsa.PublicKey)}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.fetches++
+		if s.down {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		var set struct {
+			Keys []jsonWebKey `json:"keys"`
+		}
+		for kid, key := range s.keys {
+			set.Keys = append(set.Keys, jsonWebKey{
+				Kty: "RSA",
+				Kid: kid,
+				Use: "sig",
+				N:   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			})
+		}
+		json.NewEncoder(w).Encode(set)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *jwksServer) set(down bool, kids ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.down = down
+	clear(s.keys)
+	for _, kid := range kids {
+		key, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			panic(err)
+		}
+		s.keys[kid] = &key.PublicKey
+	}
+}
+
+func (s *jwksServer) fetchCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.fetches
+}
+
+// expire makes the next lookup behave as if the throttle and, with stale,
+// the refresh interval had elapsed.
+func expire(s *JWKS, stale bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.attemptedAt = s.attemptedAt.Add(-minJWKSRefresh)
+	if stale {
+		s.fetchedAt = s.fetchedAt.Add(-s.Refresh - time.Second)
+	}
+}
+
+func TestJWKSKeyRotation(t *testing.T) {
+	srv := newJWKSServer(t)
+	srv.set(false, "k1")
+	jwks := NewJWKS(srv.URL, time.Hour)
+	ctx := context.Background()
+
+	if _, err := jwks.Key(ctx, "k1"); err != nil {
+		t.Fatalf("Key(k1): %v", err)
+	}
+	srv.set(false, "k2")
+	expire(jwks, false)
+	if _, err := jwks.Key(ctx, "k2"); err != nil {
+		t.Fatalf("Key(k2) after rotation: %v", err)
+	}
+	if _, err := jwks.Key(ctx, "k1"); err == nil {
+		t.Fatal("Key(k1) succeeded after k1 was rotated out")
+	}
+	if got := srv.fetchCount(); got != 2 {
+		t.Fatalf("fetches = %d, want 2", got)
+	}
+}
+
+func TestJWKSUnknownKidIsThrottled(t *testing.T) {
+	srv := newJWKSServer(t)
+	srv.set(false, "k1")
+	jwks := NewJWKS(srv.URL, time.Hour)
+	ctx := context.Background()
+
+	for range 5 {
+		if _, err := jwks.Key(ctx, "made-up"); err == nil {
+			t.Fatal("Key(made-up) succeeded")
+		}
+	}
+	if got := srv.fetchCount(); got != 1 {
+		t.Fatalf("fetches = %d, want 1", got)
+	}
+	if _, err := jwks.Key(ctx, "k1"); err != nil {
+		t.Fatalf("Key(k1): %v", err)
+	}
+}
+
+func TestJWKSOutage(t *testing.T) {
+	srv := newJWKSServer(t)
+	srv.set(false, "k1")
+	jwks := NewJWKS(srv.URL, time.Hour)
+	ctx := context.Background()
+
+	if _, err := jwks.Key(ctx, "k1"); err != nil {
+		t.Fatalf("Key(k1): %v", err)
+	}
+	srv.set(true)
+	expire(jwks, true)
+
+	// The stale key stays in use, and the failed fetch throttles the next
+	// ones for stale and unknown keys alike.
+	for range 5 {
+		if _, err := jwks.Key(ctx, "k1"); err != nil {
+			t.Fatalf("Key(k1) during outage: %v", err)
+		}
+		if _, err := jwks.Key(ctx, "k2"); err == nil {
+			t.Fatal("Key(k2) succeeded during outage")
+		}
+	}
+	if got := srv.fetchCount(); got != 2 {
+		t.Fatalf("fetches = %d, want 2", got)
+	}
+
+	srv.set(false, "k2")
+	expire(jwks, false)
+	if _, err := jwks.Key(ctx, "k2"); err != nil {
+		t.Fatalf("Key(k2) after recovery: %v", err)
+	}
+}
@@ -0,0 +1,125 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// ErrInvalidToken is returned for every token that fails validation. The
+// underlying reason is wrapped for logging but never shown to clients.
+var ErrInvalidToken = errors.New("invalid token")
+
+var (
+	hmacMethods       = []string{"HS256", "HS384", "HS512"}
+	asymmetricMethods = []string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512"}
+)
+
+// JWTOptions configures token validation. At least one of SecretKey and
+// JWKS must be set.
+type JWTOptions struct {
+	SecretKey []byte // accepts HS256/384/512 tokens signed with this key
+	JWKS      *JWKS  // accepts RS, PS and ES tokens signed by a key in the set
+	Issuer    string // required "iss", empty to skip the check
+	Audience  string // required "aud", empty to skip the check
+	Leeway    time.Duration
+	// RequireExpiry rejects tokens without an "exp" claim.
+	RequireExpiry bool
+	// Revocations, when set, rejects revoked tokens.
+	Revocations RevocationStore
+}
+
+// Authenticator validates JWTs and extracts the user they were issued to.
+type Authenticator struct {
+	opts   JWTOptions
+	parser *jwt.Parser
+}
+
+func NewAuthenticator(opts JWTOptions) (*Authenticator, error) {
+	var methods []string
+	if len(opts.SecretKey) > 0 {
+		methods = append(methods, hmacMethods...)
+	}
+	if opts.JWKS != nil {
+		methods = append(methods, asymmetricMethods...)
+	}
+	if len(methods) == 0 {
+		return nil, errors.New("no JWT verification key configured, set SECRET_KEY or JWKS_URL")
+	}
+
+	parserOpts := []jwt.ParserOption{jwt.WithValidMethods(methods), jwt.WithLeeway(opts.Leeway)}
+	if opts.Issuer != "" {
+		parserOpts = append(parserOpts, jwt.WithIssuer(opts.Issuer))
+	}
+	if opts.Audience != "" {
+		parserOpts = append(parserOpts, jwt.WithAudience(opts.Audience))
+	}
+	if opts.RequireExpiry {
+		parserOpts = append(parserOpts, jwt.WithExpirationRequired())
+	}
+	return &Authenticator{opts: opts, parser: jwt.NewParser(parserOpts...)}, nil
+}
+
+// Authenticate validates a token. The user ID is read from the "id" claim
+// and the user name from "sub".
+func (a *Authenticator) Authenticate(ctx context.Context, tokenString string) (Identity, error) {
+	claims := jwt.MapClaims{}
+	_, err := a.parser.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		return a.key(ctx, token)
+	})
+	if err != nil {
+		return Identity{}, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+
+	userID, ok := claims["id"].(string)
+	if !ok || userID == "" {
+		return Identity{}, fmt.Errorf("%w: missing id claim", ErrInvalidToken)
+	}
+	userName, ok := claims["sub"].(string)
+	if !ok {
+		return Identity{}, fmt.Errorf("%w: missing sub claim", ErrInvalidToken)
+	}
+	id := Identity{UserID: userID, UserName: userName}
+	if exp, err := claims.GetExpirationTime(); err == nil && exp != nil {
+		id.ExpiresAt = exp.Time
+	}
+	if iat, err := claims.GetIssuedAt(); err == nil && iat != nil {
+		id.IssuedAt = iat.Time
+	}
+	id.TokenID, _ = claims["jti"].(string)
+
+	if err := a.CheckRevoked(ctx, id); err != nil {
+		return Identity{}, err
+	}
+	return id, nil
+}
+
+// CheckRevoked returns ErrRevoked if the token id was authenticated with has
+// been revoked. Store failures are returned as errors, so callers fail closed.
+func (a *Authenticator) CheckRevoked(ctx context.Context, id Identity) error {
+	if a.opts.Revocations == nil {
+		return nil
+	}
+	revoked, err := a.opts.Revocations.IsRevoked(ctx, id)
+	if err != nil {
+		return err
+	}
+	if revoked {
+		return ErrRevoked
+	}
+	return nil
+}
+
+func (a *Authenticator) key(ctx context.Context, token *jwt.Token) (interface{}, error) {
+	if _, ok := token.Method.(*jwt.SigningMethodHMAC); ok {
+		return a.opts.SecretKey, nil
+	}
+	kid, _ := token.Header["kid"].(string)
+	if kid == "" {
+		return nil, errors.New("token has no kid header")
+	}
+	return a.opts.JWKS.Key(ctx, kid)
+}
@@ -0,0 +1,147 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// PostgresTicketStore keeps tickets in PostgreSQL so that a ticket issued by
+// one node can be redeemed on any other.
+type PostgresTicketStore struct {
+	DB *sql.DB
+}
+
+// NewPostgresTicketStore creates the tickets table if needed and returns the store.
+func NewPostgresTicketStore(db *sql.DB) (*PostgresTicketStore, error) {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS auth_tickets (
+		ticket_hash text PRIMARY KEY,
+		user_id     text NOT NULL,
+		user_name   text NOT NULL,
+		expires_at  timestamptz NOT NULL
+	)`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create auth_tickets table: %w", err)
+	}
+	_, err = db.Exec(`ALTER TABLE auth_tickets
+		ADD COLUMN IF NOT EXISTS token_expires_at timestamptz,
+		ADD COLUMN IF NOT EXISTS token_issued_at timestamptz,
+		ADD COLUMN IF NOT EXISTS token_id text NOT NULL DEFAULT ''`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to migrate auth_tickets table: %w", err)
+	}
+	return &PostgresTicketStore{DB: db}, nil
+}
+
+// Issue implements TicketStore. Expired tickets are pruned on the way.
+func (s *PostgresTicketStore) Issue(ctx context.Context, id Identity, ttl time.Duration) (string, error) {
+	ticket, hash, err := newTicket()
+	if err != nil {
+		return "", err
+	}
+	if _, err := s.DB.ExecContext(ctx, `DELETE FROM auth_tickets WHERE expires_at < now()`); err != nil {
+		return "", fmt.Errorf("failed to prune tickets: %w", err)
+	}
+	_, err = s.DB.ExecContext(ctx,
+		`INSERT INTO auth_tickets (ticket_hash, user_id, user_name, expires_at, token_expires_at, token_issued_at, token_id)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+		hash, id.UserID, id.UserName, time.Now().Add(ttl), nullTime(id.ExpiresAt), nullTime(id.IssuedAt), id.TokenID)
+	if err != nil {
+		return "", fmt.Errorf("failed to store ticket: %w", err)
+	}
+	return ticket, nil
+}
+
+// Redeem implements TicketStore. The row is deleted by the same statement
+// that reads it, so concurrent redemptions can't both succeed.
+func (s *PostgresTicketStore) Redeem(ctx context.Context, ticket string) (Identity, error) {
+	var (
+		id           Identity
+		expires      time.Time
+		tokenExpires sql.NullTime
+		tokenIssued  sql.NullTime
+	)
+	err := s.DB.QueryRowContext(ctx,
+		`DELETE FROM auth_tickets WHERE ticket_hash = $1 RETURNING user_id, user_name, expires_at, token_expires_at, token_issued_at, token_id`,
+		hashTicket(ticket)).Scan(&id.UserID, &id.UserName, &expires, &tokenExpires, &tokenIssued, &id.TokenID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Identity{}, ErrInvalidTicket
+	}
+	if err != nil {
+		return Identity{}, fmt.Errorf("failed to redeem ticket: %w", err)
+	}
+	if time.Now().After(expires) {
+		return Identity{}, ErrInvalidTicket
+	}
+	id.ExpiresAt = tokenExpires.Time
+	id.IssuedAt = tokenIssued.Time
+	return id, nil
+}
+
+func nullTime(t time.Time) sql.NullTime {
+	return sql.NullTime{Time: t, Valid: !t.IsZero()}
+}
+
+// PostgresRevocationStore keeps revocations in PostgreSQL so that they apply
+// on every node.
+type PostgresRevocationStore struct {
+	DB *sql.DB
+}
+
+// NewPostgresRevocationStore creates the revocations table if needed and
+// returns the store.
+func NewPostgresRevocationStore(db *sql.DB) (*PostgresRevocationStore, error) {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS revoked_tokens (
+		kind       text NOT NULL,
+		key        text NOT NULL,
+		revoked_at timestamptz NOT NULL,
+		expires_at timestamptz NOT NULL,
+		PRIMARY KEY (kind, key)
+	)`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create revoked_tokens table: %w", err)
+	}
+	return &PostgresRevocationStore{DB: db}, nil
+}
+
+// RevokeToken implements RevocationStore.
+func (s *PostgresRevocationStore) RevokeToken(ctx context.Context, jti string, expiresAt time.Time) error {
+	return s.revoke(ctx, "jti", jti, expiresAt)
+}
+
+// RevokeUser implements RevocationStore.
+func (s *PostgresRevocationStore) RevokeUser(ctx context.Context, userID string, expiresAt time.Time) error {
+	return s.revoke(ctx, "user", userID, expiresAt)
+}
+
+func (s *PostgresRevocationStore) revoke(ctx context.Context, kind, key string, expiresAt time.Time) error {
+	if _, err := s.DB.ExecContext(ctx, `DELETE FROM revoked_tokens WHERE expires_at < now()`); err != nil {
+		return fmt.Errorf("failed to prune revocations: %w", err)
+	}
+	_, err := s.DB.ExecContext(ctx, `
+		INSERT INTO revoked_tokens (kind, key, revoked_at, expires_at) VALUES ($1, $2, now(), $3)
+		ON CONFLICT (kind, key) DO UPDATE SET revoked_at = now(), expires_at = GREATEST(revoked_tokens.expires_at, $3)`,
+		kind, key, expiresAt)
+	if err != nil {
+		return fmt.Errorf("failed to store revocation: %w", err)
+	}
+	return nil
+}
+
+// IsRevoked implements RevocationStore. Tokens without an iat claim count as
+// issued before any revocation of their user.
+func (s *PostgresRevocationStore) IsRevoked(ctx context.Context, id Identity) (bool, error) {
+	var revoked bool
+	err := s.DB.QueryRowContext(ctx, `
+		SELECT EXISTS (
+			SELECT 1 FROM revoked_tokens
+			WHERE expires_at > now()
+			AND ((kind = 'jti' AND key = $1) OR (kind = 'user' AND key = $2 AND revoked_at >= $3))
+		)`, id.TokenID, id.UserID, id.IssuedAt).Scan(&revoked)
+	if err != nil {
+		return false, fmt.Errorf("revocation check failed: %w", err)
+	}
+	return revoked, nil
+}
@@ -0,0 +1,94 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// DefaultRevocationTTL is how long revocations are kept. It should exceed the
+// lifetime of the tokens being revoked, after which they are expired anyway.
+const DefaultRevocationTTL = 24 * time.Hour
+
+// ErrRevoked is returned for tokens that were revoked.
+var ErrRevoked = errors.New("token revoked")
+
+// RevocationStore is a denylist of revoked tokens. Entries are dropped once
+// they pass their expiry.
+type RevocationStore interface {
+	// RevokeToken revokes the token with the given jti claim.
+	RevokeToken(ctx context.Context, jti string, expiresAt time.Time) error
+	// RevokeUser revokes every token of a user issued up to now.
+	RevokeUser(ctx context.Context, userID string, expiresAt time.Time) error
+	IsRevoked(ctx context.Context, id Identity) (bool, error)
+}
+
+// MemoryRevocationStore keeps revocations in process memory. Revocations
+// only apply to the node they were made on.
+type MemoryRevocationStore struct {
+	tokens map[string]time.Time      // jti -> expiry
+	users  map[string]userRevocation // user ID -> revocation
+	mu     sync.RWMutex
+}
+
+type userRevocation struct {
+	revokedAt time.Time
+	expiresAt time.Time
+}
+
+func NewMemoryRevocationStore() *MemoryRevocationStore {
+	return &MemoryRevocationStore{
+		tokens: make(map[string]time.Time),
+		users:  make(map[string]userRevocation),
+	}
+}
+
+// RevokeToken implements RevocationStore.
+func (s *MemoryRevocationStore) RevokeToken(_ context.Context, jti string, expiresAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.prune(time.Now())
+	s.tokens[jti] = expiresAt
+	return nil
+}
+
+// RevokeUser implements RevocationStore.
+func (s *MemoryRevocationStore) RevokeUser(_ context.Context, userID string, expiresAt time.Time) error {
+	now := time.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.prune(now)
+	s.users[userID] = userRevocation{revokedAt: now, expiresAt: expiresAt}
+	return nil
+}
+
+// IsRevoked implements RevocationStore. Tokens without an iat claim count as
+// issued before any revocation of their user.
+func (s *MemoryRevocationStore) IsRevoked(_ context.Context, id Identity) (bool, error) {
+	now := time.Now()
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if id.TokenID != "" {
+		if exp, ok := s.tokens[id.TokenID]; ok && now.Before(exp) {
+			return true, nil
+		}
+	}
+	if r, ok := s.users[id.UserID]; ok && now.Before(r.expiresAt) && !id.IssuedAt.After(r.revokedAt) {
+		return true, nil
+	}
+	return false, nil
+}
+
+func (s *MemoryRevocationStore) prune(now time.Time) {
+	for k, exp := range s.tokens {
+		if !now.Before(exp) {
+			delete(s.tokens, k)
+		}
+	}
+	for k, r := range s.users {
+		if !now.Before(r.expiresAt) {
+			delete(s.users, k)
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"sync"
+	"time"
+)
+
+// DefaultTicketTTL is how long a connect ticket stays valid.
+const DefaultTicketTTL = 30 * time.Second
+
+// ErrInvalidTicket is returned when a ticket is unknown, expired or was
+// already used.
+var ErrInvalidTicket = errors.New("invalid or expired ticket")
+
+// Identity is the authenticated user a token or ticket was issued to.
+type Identity struct {
+	UserID    string
+	UserName  string
+	ExpiresAt time.Time // expiry of the token, zero if it doesn't expire
+	IssuedAt  time.Time // zero if the token has no iat claim
+	TokenID   string    // jti claim, if any
+}
+
+// TicketStore issues short-lived, single-use connect tickets. Browsers can't
+// set headers on WebSocket upgrades, so they exchange their token for a
+// ticket over REST and pass the ticket in the upgrade URL instead.
+type TicketStore interface {
+	Issue(ctx context.Context, id Identity, ttl time.Duration) (string, error)
+	// Redeem consumes a ticket; a ticket can be redeemed at most once.
+	Redeem(ctx context.Context, ticket string) (Identity, error)
+}
+
+// newTicket returns a random ticket and the hash it is stored under, so
+// that stored tickets can't be replayed.
+func newTicket() (ticket, hash string, err error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	ticket = hex.EncodeToString(b)
+	return ticket, hashTicket(ticket), nil
+}
+
+func hashTicket(ticket string) string {
+	sum := sha256.Sum256([]byte(ticket))
+	return hex.EncodeToString(sum[:])
+}
+
+type ticketEntry struct {
+	identity Identity
+	expires  time.Time
+}
+
+// MemoryTicketStore keeps tickets in process memory. Tickets can only be
+// redeemed on the node that issued them.
+type MemoryTicketStore struct {
+	tickets map[string]ticketEntry
+	mu      sync.Mutex
+}
+
+func NewMemoryTicketStore() *MemoryTicketStore {
+	return &MemoryTicketStore{tickets: make(map[string]ticketEntry)}
+}
+
+// Issue implements TicketStore.
+func (s *MemoryTicketStore) Issue(_ context.Context, id Identity, ttl time.Duration) (string, error) {
+	ticket, hash, err := newTicket()
+	if err != nil {
+		return "", err
+	}
+	now := time.Now()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for k, e := range s.tickets {
+		if now.After(e.expires) {
+			delete(s.tickets, k)
+		}
+	}
+	s.tickets[hash] = ticketEntry{identity: id, expires: now.Add(ttl)}
+	return ticket, nil
+}
+
+// Redeem implements TicketStore.
+func (s *MemoryTicketStore) Redeem(_ context.Context, ticket string) (Identity, error) {
+	hash := hashTicket(ticket)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.tickets[hash]
+	if !ok {
+		return Identity{}, ErrInvalidTicket
+	}
+	delete(s.tickets, hash)
+	if time.Now().After(e.expires) {
+		return Identity{}, ErrInvalidTicket
+	}
+	return e.identity, nil
+}
@@ -1,33 +1,193 @@
 package config
 
 import (
-	"github.com/joho/godotenv"
-	"log"
-	"os"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"time"
 )
 
+// Config holds every setting of the server. Each field is set, in increasing
+// order of precedence, from its default, the config file, the environment
+// variable named by its env tag and the matching command line flag; see Load.
+// Fields tagged secret are redacted when printed, and fields tagged reload
+// can be changed at runtime, see Watcher.
 type Config struct {
-	Port        string
-	DatabaseURL string
-	SecretKey   string
+	ConfigWatchInterval time.Duration `env:"CONFIG_WATCH_INTERVAL" default:"5s" usage:"how often the config file is checked for changes, 0 disables"`
+
+	Port        string `env:"PORT" default:"8080" usage:"HTTP listen port"`
+	DatabaseURL string `env:"DATABASE_URL" secret:"true" usage:"PostgreSQL connection URL"`
+	DBMaxConns  int    `env:"DB_MAX_CONNS" default:"25" usage:"maximum open PostgreSQL connections"`
+	AutoMigrate bool   `env:"AUTO_MIGRATE" default:"true" usage:"apply CQL migrations on startup"`
+
+	// Probes and graceful shutdown.
+	HealthTimeout   time.Duration `env:"HEALTH_CHECK_TIMEOUT" default:"2s" usage:"deadline of each readiness dependency check"`
+	DrainDelay      time.Duration `env:"SHUTDOWN_DRAIN_DELAY" default:"5s" usage:"how long /readyz fails before the server stops on SIGTERM"`
+	ShutdownTimeout time.Duration `env:"SHUTDOWN_TIMEOUT" default:"30s" usage:"how long in-flight requests get to finish"`
+
+	// ScyllaDB cluster settings, see db.ScyllaConfig.
+	ScyllaHosts             string        `env:"SCYLLA_HOSTS" default:"localhost:9042" usage:"comma separated host:port list"`
+	ScyllaKeyspace          string        `env:"SCYLLA_KEYSPACE" default:"messaging"`
+	ScyllaReplication       string        `env:"SCYLLA_REPLICATION" default:"SimpleStrategy" usage:"SimpleStrategy or NetworkTopologyStrategy"`
+	ScyllaReplicationFactor int           `env:"SCYLLA_REPLICATION_FACTOR" default:"1" usage:"SimpleStrategy only"`
+	ScyllaDataCenters       string        `env:"SCYLLA_DATACENTERS" usage:"NetworkTopologyStrategy, e.g. dc1=3,dc2=3"`
+	ScyllaReadConsistency   string        `env:"SCYLLA_READ_CONSISTENCY" default:"QUORUM"`
+	ScyllaWriteConsistency  string        `env:"SCYLLA_WRITE_CONSISTENCY" default:"QUORUM"`
+	ScyllaProtoVersion      int           `env:"SCYLLA_PROTO_VERSION" default:"4"`
+	ScyllaTimeout           time.Duration `env:"SCYLLA_TIMEOUT" default:"11s"`
+	ScyllaConnectTimeout    time.Duration `env:"SCYLLA_CONNECT_TIMEOUT" default:"11s"`
+	ScyllaRetries           int           `env:"SCYLLA_RETRIES" default:"3"`
+	ScyllaUsername          string        `env:"SCYLLA_USERNAME"`
+	ScyllaPassword          string        `env:"SCYLLA_PASSWORD" secret:"true"`
+	ScyllaTLS               bool          `env:"SCYLLA_TLS" default:"false"`
+	ScyllaTLSCAFile         string        `env:"SCYLLA_TLS_CA_FILE"`
+	ScyllaTLSCertFile       string        `env:"SCYLLA_TLS_CERT_FILE"`
+	ScyllaTLSKeyFile        string        `env:"SCYLLA_TLS_KEY_FILE"`
+	ScyllaTLSVerifyHost     bool          `env:"SCYLLA_TLS_VERIFY_HOST" default:"true"`
+	ScyllaLocalDC           string        `env:"SCYLLA_LOCAL_DC" usage:"prefer hosts in this data center"`
+	ScyllaTokenAware        bool          `env:"SCYLLA_TOKEN_AWARE" default:"true"`
+
+	SecretKey   string `env:"SECRET_KEY" secret:"true" usage:"HMAC key of HS256 tokens, at least 32 bytes"`
+	AuthCookie  string `env:"AUTH_COOKIE" default:"servit_token" usage:"cookie carrying the JWT, empty to disable"`
+	TicketStore string `env:"TICKET_STORE" default:"memory" usage:"memory or postgres"`
+
+	// JWT validation, see auth.JWTOptions.
+	JWTIssuer        string        `env:"JWT_ISSUER"`
+	JWTAudience      string        `env:"JWT_AUDIENCE"`
+	JWTLeeway        time.Duration `env:"JWT_LEEWAY" default:"30s"`
+	JWTRequireExpiry bool          `env:"JWT_REQUIRE_EXP" default:"true"`
+	JWKSURL          string        `env:"JWKS_URL" usage:"enables RS/PS/ES tokens"`
+	JWKSRefresh      time.Duration `env:"JWKS_REFRESH" default:"1h"`
+	ReauthWindow     time.Duration `env:"REAUTH_WINDOW" default:"2m" usage:"how early WebSocket sessions are asked to re-authenticate"`
+
+	AdminToken         string        `env:"ADMIN_TOKEN" secret:"true" usage:"bearer token of the /admin API, empty to disable it"`
+	RevocationStore    string        `env:"REVOCATION_STORE" default:"memory" usage:"memory or postgres"`
+	RevocationTTL      time.Duration `env:"REVOCATION_TTL" default:"24h" usage:"how long revocations are kept"`
+	RevocationInterval time.Duration `env:"REVOCATION_CHECK_INTERVAL" default:"30s" usage:"how often live sessions are re-checked, 0 disables"`
+
+	LogLevel      string `env:"LOG_LEVEL" reload:"true" default:"info" usage:"debug, info, warn or error"`
+	LogFormat     string `env:"LOG_FORMAT" default:"text" usage:"text or json"`
+	TraceExporter string `env:"TRACE_EXPORTER" default:"none" usage:"none, stdout or memory"`
+
+	AllowedOrigins string `env:"ALLOWED_ORIGINS" reload:"true" default:"http://localhost:3000" usage:"comma separated, e.g. https://app.example.com,https://*.example.com"`
+	DevMode        bool   `env:"DEV_MODE" reload:"true" default:"false" usage:"relaxes origin checks to allow any loopback origin"`
+
+	// Rate limits are comma separated policy=rate:burst entries, see ratelimit.ParseLimits.
+	WSRateLimits      string `env:"WS_RATE_LIMITS" reload:"true" default:"channel_message=5:20,direct_message=5:20,typing=2:10,not_typing=2:10,*=10:30" usage:"keyed by frame type"`
+	HTTPRateLimits    string `env:"HTTP_RATE_LIMITS" reload:"true" default:"*=5:20" usage:"keyed by route"`
+	RateLimitStore    string `env:"RATE_LIMIT_STORE" default:"memory" usage:"memory or postgres"`
+	MaxRateViolations int    `env:"MAX_RATE_VIOLATIONS" reload:"true" default:"20" usage:"violations per minute before a WebSocket is closed"`
+
+	// WebSocket frame size limits in bytes, see services.SizeLimits.
+	WSReadLimit      int    `env:"WS_READ_LIMIT" reload:"true" default:"65536"`
+	WSFrameLimits    string `env:"WS_FRAME_LIMITS" reload:"true" default:"switch_chat=1024,typing=1024,not_typing=1024,*=16384" usage:"inbound, type=bytes entries"`
+	WSWriteLimits    string `env:"WS_WRITE_LIMITS" reload:"true" default:"*=1048576" usage:"outbound, type=bytes entries"`
+	WSReadBuffer     int    `env:"WS_READ_BUFFER" default:"1024"`
+	WSWriteBuffer    int    `env:"WS_WRITE_BUFFER" default:"1024"`
+	WSCompression    bool   `env:"WS_COMPRESSION" reload:"true" default:"false" usage:"negotiate permessage-deflate"`
+	WSCompressionLvl int    `env:"WS_COMPRESSION_LEVEL" reload:"true" default:"1" usage:"flate level, -2 to 9"`
+
+	// WebSocket keepalive and queueing.
+	WSPingInterval time.Duration `env:"WS_PING_INTERVAL" default:"54s"`
+	WSPongWait     time.Duration `env:"WS_PONG_WAIT" default:"60s" usage:"must exceed the ping interval"`
+	WSWriteWait    time.Duration `env:"WS_WRITE_WAIT" default:"10s"`
+	WSSendBuffer   int           `env:"WS_SEND_BUFFER" default:"1024" usage:"frames queued per session before it is closed as a slow consumer"`
+	WSEphemeralBuf int           `env:"WS_EPHEMERAL_BUFFER" default:"64" usage:"typing indicators queued per session, oldest dropped first"`
+	WSReplayBuffer int           `env:"WS_REPLAY_BUFFER" default:"256" usage:"frames retained per session for resume, 0 disables resuming"`
+	WSReplayTTL    time.Duration `env:"WS_REPLAY_TTL" default:"2m" usage:"how long a closed session can be resumed"`
+
+	// Long-poll transport.
+	PollWait        time.Duration `env:"POLL_WAIT" default:"25s" usage:"how long a long-poll request waits for frames"`
+	PollIdleTimeout time.Duration `env:"POLL_IDLE_TIMEOUT" default:"1m" usage:"how long a long-poll session lives between polls, must exceed POLL_WAIT"`
+
+	// Idempotency keys of the REST message endpoints.
+	IdempotencyStore string        `env:"IDEMPOTENCY_STORE" default:"memory" usage:"memory or postgres"`
+	IdempotencyTTL   time.Duration `env:"IDEMPOTENCY_TTL" default:"24h" usage:"how long a response is replayed for a reused Idempotency-Key"`
+
+	// Outgoing webhooks, see webhooks.Options.
+	WebhookStore       string        `env:"WEBHOOK_STORE" default:"memory" usage:"memory or postgres"`
+	WebhookWorkers     int           `env:"WEBHOOK_WORKERS" default:"4" usage:"concurrent webhook deliveries"`
+	WebhookTimeout     time.Duration `env:"WEBHOOK_TIMEOUT" default:"10s" usage:"timeout of each delivery attempt"`
+	WebhookMaxAttempts int           `env:"WEBHOOK_MAX_ATTEMPTS" default:"8" usage:"attempts before a delivery is dead"`
+	WebhookBackoff     time.Duration `env:"WEBHOOK_BACKOFF" default:"10s" usage:"delay before the first retry, doubled after each"`
+	WebhookMaxBackoff  time.Duration `env:"WEBHOOK_MAX_BACKOFF" default:"1h"`
+
+	// Message history paging.
+	DefaultPageSize int `env:"DEFAULT_PAGE_SIZE" reload:"true" default:"10"`
+	MaxPageSize     int `env:"MAX_PAGE_SIZE" reload:"true" default:"100"`
+
+	sources map[string]Source // env key -> layer the value came from
 }
 
-func LoadConfig() *Config {
-	err := godotenv.Load()
-	if err != nil {
-		log.Println("No .env file found")
-	}
+// minSecretKeyLength is the shortest HMAC key accepted, 256 bits for HS256.
+const minSecretKeyLength = 32
 
-	return &Config{
-		Port:        getEnv("PORT", "8080"),
-		DatabaseURL: getEnv("DATABASE_URL", ""),
-		SecretKey:   getEnv("SECRET_KEY", ""),
+// Validate checks the settings that don't need other packages to interpret
+// and reports every problem at once.
+func (c *Config) Validate() error {
+	var errs []error
+	check := func(ok bool, key, format string, args ...any) {
+		if !ok {
+			errs = append(errs, fmt.Errorf("%s: %s", key, fmt.Sprintf(format, args...)))
+		}
 	}
-}
+	oneOf := func(key, value string, allowed ...string) {
+		check(slices.Contains(allowed, value), key, "%q is not one of %v", value, allowed)
+	}
+
+	port, err := strconv.Atoi(c.Port)
+	check(err == nil && port > 0 && port < 65536, "PORT", "%q is not a valid port", c.Port)
+	check(c.HealthTimeout > 0, "HEALTH_CHECK_TIMEOUT", "must be positive")
+	check(c.DrainDelay >= 0, "SHUTDOWN_DRAIN_DELAY", "must not be negative")
+	check(c.ShutdownTimeout > 0, "SHUTDOWN_TIMEOUT", "must be positive")
+	check(c.DatabaseURL != "", "DATABASE_URL", "is required")
+	check(c.ConfigWatchInterval >= 0, "CONFIG_WATCH_INTERVAL", "must not be negative")
+	check(c.DBMaxConns > 0, "DB_MAX_CONNS", "must be positive")
 
-func getEnv(key, defaultValue string) string {
-	if value, exists := os.LookupEnv(key); exists {
-		return value
+	if c.SecretKey == "" {
+		check(c.JWKSURL != "", "SECRET_KEY", "is required unless JWKS_URL is set")
+	} else {
+		check(len(c.SecretKey) >= minSecretKeyLength, "SECRET_KEY", "must be at least %d bytes", minSecretKeyLength)
 	}
-	return defaultValue
+	check(c.AdminToken == "" || len(c.AdminToken) >= 16, "ADMIN_TOKEN", "must be at least 16 characters")
+	check(c.JWTLeeway >= 0, "JWT_LEEWAY", "must not be negative")
+	check(c.JWKSRefresh > 0, "JWKS_REFRESH", "must be positive")
+	check(c.ReauthWindow > 0, "REAUTH_WINDOW", "must be positive")
+	check(c.RevocationTTL > 0, "REVOCATION_TTL", "must be positive")
+	check(c.RevocationInterval >= 0, "REVOCATION_CHECK_INTERVAL", "must not be negative")
+
+	oneOf("LOG_LEVEL", c.LogLevel, "debug", "info", "warn", "error")
+	oneOf("LOG_FORMAT", c.LogFormat, "text", "json")
+	oneOf("TRACE_EXPORTER", c.TraceExporter, "none", "stdout", "memory")
+	oneOf("TICKET_STORE", c.TicketStore, "memory", "postgres")
+	oneOf("REVOCATION_STORE", c.RevocationStore, "memory", "postgres")
+	oneOf("RATE_LIMIT_STORE", c.RateLimitStore, "memory", "postgres")
+	oneOf("IDEMPOTENCY_STORE", c.IdempotencyStore, "memory", "postgres")
+	oneOf("WEBHOOK_STORE", c.WebhookStore, "memory", "postgres")
+
+	check(c.MaxRateViolations >= 0, "MAX_RATE_VIOLATIONS", "must not be negative")
+	check(c.WSReadLimit >= 0, "WS_READ_LIMIT", "must not be negative")
+	check(c.WSReadBuffer > 0, "WS_READ_BUFFER", "must be positive")
+	check(c.WSWriteBuffer > 0, "WS_WRITE_BUFFER", "must be positive")
+	check(c.WSCompressionLvl >= -2 && c.WSCompressionLvl <= 9, "WS_COMPRESSION_LEVEL", "must be between -2 and 9")
+	check(c.WSPingInterval > 0, "WS_PING_INTERVAL", "must be positive")
+	check(c.WSPongWait > c.WSPingInterval, "WS_PONG_WAIT", "must exceed WS_PING_INTERVAL (%s)", c.WSPingInterval)
+	check(c.WSWriteWait > 0, "WS_WRITE_WAIT", "must be positive")
+	check(c.WSSendBuffer > 0, "WS_SEND_BUFFER", "must be positive")
+	check(c.WSEphemeralBuf > 0, "WS_EPHEMERAL_BUFFER", "must be positive")
+	check(c.WSReplayBuffer >= 0, "WS_REPLAY_BUFFER", "must not be negative")
+	check(c.WSReplayTTL > 0, "WS_REPLAY_TTL", "must be positive")
+	check(c.PollWait > 0, "POLL_WAIT", "must be positive")
+	check(c.PollIdleTimeout > c.PollWait, "POLL_IDLE_TIMEOUT", "must exceed POLL_WAIT")
+	check(c.IdempotencyTTL > 0, "IDEMPOTENCY_TTL", "must be positive")
+	check(c.WebhookWorkers > 0, "WEBHOOK_WORKERS", "must be positive")
+	check(c.WebhookTimeout > 0, "WEBHOOK_TIMEOUT", "must be positive")
+	check(c.WebhookMaxAttempts > 0, "WEBHOOK_MAX_ATTEMPTS", "must be positive")
+	check(c.WebhookBackoff > 0, "WEBHOOK_BACKOFF", "must be positive")
+	check(c.WebhookMaxBackoff >= c.WebhookBackoff, "WEBHOOK_MAX_BACKOFF", "must be at least WEBHOOK_BACKOFF (%s)", c.WebhookBackoff)
+	check(c.MaxPageSize > 0, "MAX_PAGE_SIZE", "must be positive")
+	check(c.DefaultPageSize > 0 && c.DefaultPageSize <= c.MaxPageSize, "DEFAULT_PAGE_SIZE", "must be between 1 and MAX_PAGE_SIZE (%d)", c.MaxPageSize)
+
+	return errors.Join(errs...)
 }
@@ -0,0 +1,305 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/joho/godotenv"
+	"github.com/pelletier/go-toml/v2"
+	"gopkg.in/yaml.v3"
+)
+
+// Source is the configuration layer a setting was taken from.
+type Source string
+
+const (
+	SourceDefault Source = "default"
+	SourceFile    Source = "file"
+	SourceEnv     Source = "env"
+	SourceFlag    Source = "flag"
+)
+
+// redacted replaces the value of secret settings when printed.
+const redacted = "[redacted]"
+
+// Loader builds a Config from defaults, a YAML or TOML file, the environment
+// and command line flags, each layer overriding the previous one.
+type Loader struct {
+	path  *string
+	flags map[string]string // env key -> raw flag value
+}
+
+// RegisterFlags adds -config and one flag per setting to fs, named after the
+// setting's environment variable, e.g. -scylla-hosts for SCYLLA_HOSTS.
+func RegisterFlags(fs *flag.FlagSet) *Loader {
+	l := &Loader{
+		path:  fs.String("config", os.Getenv("CONFIG_FILE"), "YAML or TOML config file (CONFIG_FILE)"),
+		flags: make(map[string]string),
+	}
+	for _, f := range (&Config{}).fields() {
+		set := func(s string) error {
+			l.flags[f.key] = s
+			return nil
+		}
+		usage := f.usage
+		if usage == "" {
+			usage = f.key
+		} else {
+			usage += " (" + f.key + ")"
+		}
+		if f.value.Kind() == reflect.Bool {
+			fs.BoolFunc(f.flagName(), usage, set)
+		} else {
+			fs.Func(f.flagName(), usage, set)
+		}
+	}
+	return l
+}
+
+// Path returns the config file in use, if any.
+func (l *Loader) Path() string {
+	return *l.path
+}
+
+// Load reads every layer into a new Config. It reports malformed values and
+// unknown file keys but does not validate the result; see Config.Validate.
+func (l *Loader) Load() (*Config, error) {
+	if err := godotenv.Load(); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("failed to read .env: %w", err)
+	}
+
+	cfg := &Config{sources: make(map[string]Source)}
+	fields := cfg.fields()
+	byKey := make(map[string]field, len(fields))
+	var errs []error
+	set := func(f field, raw string, src Source) {
+		if err := f.set(raw); err != nil {
+			errs = append(errs, fmt.Errorf("%s (%s): %w", f.key, src, err))
+			return
+		}
+		cfg.sources[f.key] = src
+	}
+
+	for _, f := range fields {
+		byKey[f.key] = f
+		set(f, f.def, SourceDefault)
+	}
+	if *l.path != "" {
+		values, err := readFile(*l.path)
+		if err != nil {
+			return nil, err
+		}
+		for key, raw := range values {
+			f, ok := byKey[key]
+			if !ok {
+				errs = append(errs, fmt.Errorf("%s: unknown setting in %s", key, *l.path))
+				continue
+			}
+			set(f, raw, SourceFile)
+		}
+	}
+	for _, f := range fields {
+		if raw, ok := os.LookupEnv(f.key); ok {
+			set(f, raw, SourceEnv)
+		}
+	}
+	for key, raw := range l.flags {
+		set(byKey[key], raw, SourceFlag)
+	}
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// Load parses args as flags, see RegisterFlags, and loads the configuration.
+// The returned Loader reloads it with the same flags.
+func Load(args []string) (*Config, *Loader, error) {
+	fs := flag.NewFlagSet(filepath.Base(os.Args[0]), flag.ContinueOnError)
+	loader := RegisterFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return nil, nil, err
+	}
+	if fs.NArg() > 0 {
+		return nil, nil, fmt.Errorf("unexpected argument %q", fs.Arg(0))
+	}
+	cfg, err := loader.Load()
+	return cfg, loader, err
+}
+
+// readFile returns the settings of a YAML or TOML file keyed by environment
+// variable name. Keys may be written in any case, with - or _ separators.
+func readFile(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+	raw := make(map[string]any)
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &raw)
+	case ".toml":
+		err = toml.Unmarshal(data, &raw)
+	default:
+		return nil, fmt.Errorf("unsupported config file type %q, use .yaml, .yml or .toml", ext)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+
+	values := make(map[string]string, len(raw))
+	for k, v := range raw {
+		key := strings.ToUpper(strings.ReplaceAll(k, "-", "_"))
+		s, err := scalar(v)
+		if err != nil {
+			return nil, fmt.Errorf("%s in %s: %w", k, path, err)
+		}
+		values[key] = s
+	}
+	return values, nil
+}
+
+// scalar formats a decoded file value as it would be written in the
+// environment. Lists, e.g. of hosts or origins, are joined with commas.
+func scalar(v any) (string, error) {
+	switch v := v.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return v, nil
+	case bool, int, int64, uint64, float64:
+		return fmt.Sprint(v), nil
+	case []any:
+		parts := make([]string, len(v))
+		for i, item := range v {
+			s, err := scalar(item)
+			if err != nil {
+				return "", err
+			}
+			if _, nested := item.([]any); nested {
+				return "", errors.New("nested lists are not supported")
+			}
+			parts[i] = s
+		}
+		return strings.Join(parts, ","), nil
+	default:
+		return "", fmt.Errorf("unsupported value of type %T, settings are flat", v)
+	}
+}
+
+// field is a Config setting described by its struct tags.
+type field struct {
+	key    string
+	def    string
+	usage  string
+	secret bool
+	reload bool
+	value  reflect.Value
+}
+
+func (c *Config) fields() []field {
+	v := reflect.ValueOf(c).Elem()
+	t := v.Type()
+	fields := make([]field, 0, t.NumField())
+	for i := range t.NumField() {
+		sf := t.Field(i)
+		key := sf.Tag.Get("env")
+		if key == "" {
+			continue
+		}
+		fields = append(fields, field{
+			key:    key,
+			def:    sf.Tag.Get("default"),
+			usage:  sf.Tag.Get("usage"),
+			secret: sf.Tag.Get("secret") == "true",
+			reload: sf.Tag.Get("reload") == "true",
+			value:  v.Field(i),
+		})
+	}
+	return fields
+}
+
+func (f field) flagName() string {
+	return strings.ToLower(strings.ReplaceAll(f.key, "_", "-"))
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+func (f field) set(raw string) error {
+	switch {
+	case f.value.Type() == durationType:
+		d, err := time.ParseDuration(raw)
+		if err != nil {
+			return fmt.Errorf("invalid duration %q", raw)
+		}
+		f.value.SetInt(int64(d))
+	case f.value.Kind() == reflect.String:
+		f.value.SetString(raw)
+	case f.value.Kind() == reflect.Bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return fmt.Errorf("invalid boolean %q", raw)
+		}
+		f.value.SetBool(b)
+	case f.value.Kind() == reflect.Int:
+		i, err := strconv.Atoi(raw)
+		if err != nil {
+			return fmt.Errorf("invalid integer %q", raw)
+		}
+		f.value.SetInt(int64(i))
+	default:
+		return fmt.Errorf("unsupported setting type %s", f.value.Type())
+	}
+	return nil
+}
+
+func (f field) String() string {
+	if f.value.Type() == durationType {
+		return time.Duration(f.value.Int()).String()
+	}
+	return fmt.Sprint(f.value.Interface())
+}
+
+// Setting is a printable configuration entry.
+type Setting struct {
+	Key        string
+	Value      string // redacted for secrets
+	Source     Source
+	Reloadable bool // applied without a restart, see Watcher
+}
+
+// Settings lists every setting with its value and source, secrets redacted.
+func (c *Config) Settings() []Setting {
+	fields := c.fields()
+	settings := make([]Setting, 0, len(fields))
+	for _, f := range fields {
+		value := f.String()
+		if f.secret && value != "" {
+			value = redacted
+		}
+		src := c.sources[f.key]
+		if src == "" {
+			src = SourceDefault
+		}
+		settings = append(settings, Setting{Key: f.key, Value: value, Source: src, Reloadable: f.reload})
+	}
+	return settings
+}
+
+// String prints the configuration with secrets redacted, so that it is safe
+// to log.
+func (c *Config) String() string {
+	var b strings.Builder
+	for _, s := range c.Settings() {
+		fmt.Fprintf(&b, "%s=%s\n", s.Key, s.Value)
+	}
+	return b.String()
+}
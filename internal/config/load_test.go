@@ -0,0 +1,91 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeConfig writes a config file named name in a temporary directory and
+// returns its path.
+func writeConfig(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadLayers(t *testing.T) {
+	path := writeConfig(t, "servit.yaml", `
+port: 9000
+log-level: debug
+scylla_keyspace: from_file
+allowed_origins:
+  - https://a.example.com
+  - https://b.example.com
+`)
+	t.Setenv("LOG_LEVEL", "warn")
+	t.Setenv("SCYLLA_KEYSPACE", "from_env")
+
+	cfg, _, err := Load([]string{"-config", path, "-scylla-keyspace", "from_flag"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		key, value string
+		source     Source
+	}{
+		{"DB_MAX_CONNS", "25", SourceDefault},
+		{"PORT", "9000", SourceFile},
+		{"ALLOWED_ORIGINS", "https://a.example.com,https://b.example.com", SourceFile},
+		{"LOG_LEVEL", "warn", SourceEnv},
+		{"SCYLLA_KEYSPACE", "from_flag", SourceFlag},
+	} {
+		var found bool
+		for _, s := range cfg.Settings() {
+			if s.Key != tc.key {
+				continue
+			}
+			found = true
+			if s.Value != tc.value || s.Source != tc.source {
+				t.Errorf("%s = %q from %s, want %q from %s", tc.key, s.Value, s.Source, tc.value, tc.source)
+			}
+		}
+		if !found {
+			t.Errorf("%s not listed", tc.key)
+		}
+	}
+}
+
+func TestLoadRejectsBadSettings(t *testing.T) {
+	for _, tc := range []struct {
+		name, file, content string
+		args                []string
+		want                string
+	}{
+		{"unknown key", "servit.yaml", "not_a_setting: 1\n", nil, "NOT_A_SETTING: unknown setting"},
+		{"malformed file value", "servit.toml", "ws_ping_interval = \"soon\"\n", nil, `WS_PING_INTERVAL (file): invalid duration "soon"`},
+		{"malformed flag", "servit.yaml", "", []string{"-db-max-conns", "many"}, `DB_MAX_CONNS (flag): invalid integer "many"`},
+		{"nested list", "servit.yaml", "allowed_origins: [[a]]\n", nil, "nested lists are not supported"},
+		{"unsupported file type", "servit.json", "{}", nil, "unsupported config file type"},
+	} {
+		args := append([]string{"-config", writeConfig(t, tc.file, tc.content)}, tc.args...)
+		if _, _, err := Load(args); err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s: got %v, want %q", tc.name, err, tc.want)
+		}
+	}
+}
+
+func TestSettingsRedactSecrets(t *testing.T) {
+	t.Setenv("SECRET_KEY", "0123456789abcdef0123456789abcdef")
+	cfg, _, err := Load(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := cfg.String(); strings.Contains(s, "0123456789abcdef") || !strings.Contains(s, "SECRET_KEY="+redacted) {
+		t.Errorf("secret not redacted:\n%s", s)
+	}
+}
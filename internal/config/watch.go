@@ -0,0 +1,179 @@
+package config
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"maps"
+	"os"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"servit-go/internal/metrics"
+)
+
+// Watcher holds the live configuration. It reloads it when the config file
+// changes or the process receives SIGHUP, and hands validated changes to its
+// subscribers. Only settings tagged reload are applied; changes to the others
+// are logged and take effect after a restart.
+type Watcher struct {
+	loader  *Loader
+	logger  *slog.Logger
+	current atomic.Pointer[Config]
+
+	mu          sync.Mutex // serializes reloads
+	checks      []func(*Config) error
+	subscribers []func(*Config)
+	modTime     time.Time
+	size        int64
+}
+
+// NewWatcher creates a Watcher serving cfg, which was loaded by loader.
+func NewWatcher(loader *Loader, cfg *Config, logger *slog.Logger) *Watcher {
+	w := &Watcher{loader: loader, logger: logger}
+	w.current.Store(cfg)
+	w.modTime, w.size = w.stat()
+	return w
+}
+
+// Current returns the configuration in effect. It must not be modified.
+func (w *Watcher) Current() *Config {
+	return w.current.Load()
+}
+
+// Check adds a validation run on every reloaded configuration in addition to
+// Config.Validate, e.g. parsing settings owned by another package.
+func (w *Watcher) Check(fn func(*Config) error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.checks = append(w.checks, fn)
+}
+
+// Subscribe registers fn to be called with each new configuration once it is
+// validated and in effect.
+func (w *Watcher) Subscribe(fn func(*Config)) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.subscribers = append(w.subscribers, fn)
+}
+
+// Reload loads the configuration again. Invalid configurations are rejected
+// and the current one is kept.
+func (w *Watcher) Reload() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.modTime, w.size = w.stat()
+
+	loaded, err := w.loader.Load()
+	if err != nil {
+		return w.reject(err)
+	}
+	next, changed, restart := w.Current().merge(loaded)
+	if len(restart) > 0 {
+		w.logger.Warn("ignoring configuration changes that require a restart", "settings", restart)
+	}
+	if len(changed) == 0 {
+		metrics.ConfigReloads.WithLabelValues("unchanged").Inc()
+		w.logger.Info("configuration unchanged")
+		return nil
+	}
+	errs := []error{next.Validate()}
+	for _, check := range w.checks {
+		errs = append(errs, check(next))
+	}
+	if err := errors.Join(errs...); err != nil {
+		return w.reject(err)
+	}
+
+	w.current.Store(next)
+	for _, fn := range w.subscribers {
+		fn(next)
+	}
+	metrics.ConfigReloads.WithLabelValues("applied").Inc()
+	w.logger.Info("configuration reloaded", "settings", changed)
+	return nil
+}
+
+func (w *Watcher) reject(err error) error {
+	metrics.ConfigReloads.WithLabelValues("rejected").Inc()
+	w.logger.Error("rejected configuration reload, keeping the current configuration", "error", err)
+	return err
+}
+
+// Run reloads the configuration on SIGHUP and, if a config file is used,
+// whenever its modification time or size changes, checked every interval.
+// It returns when ctx is done.
+func (w *Watcher) Run(ctx context.Context, interval time.Duration) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	var tick <-chan time.Time
+	if w.loader.Path() != "" && interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			w.logger.Info("reloading configuration on SIGHUP")
+			w.Reload()
+		case <-tick:
+			if w.fileChanged() {
+				w.logger.Info("reloading configuration after file change", "path", w.loader.Path())
+				w.Reload()
+			}
+		}
+	}
+}
+
+func (w *Watcher) fileChanged() bool {
+	modTime, size := w.stat()
+	if modTime.IsZero() {
+		// Missing, e.g. while an editor replaces it; check again next time.
+		return false
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return !modTime.Equal(w.modTime) || size != w.size
+}
+
+func (w *Watcher) stat() (time.Time, int64) {
+	if w.loader.Path() == "" {
+		return time.Time{}, 0
+	}
+	info, err := os.Stat(w.loader.Path())
+	if err != nil {
+		return time.Time{}, 0
+	}
+	return info.ModTime(), info.Size()
+}
+
+// merge returns a copy of c with the reloadable settings of loaded, and the
+// keys that changed and the keys whose change needs a restart.
+func (c *Config) merge(loaded *Config) (*Config, []string, []string) {
+	next := *c
+	next.sources = maps.Clone(c.sources)
+	var changed, restart []string
+	nextFields, loadedFields := next.fields(), loaded.fields()
+	for i, f := range nextFields {
+		from := loadedFields[i]
+		if f.String() == from.String() {
+			continue
+		}
+		if !f.reload {
+			restart = append(restart, f.key)
+			continue
+		}
+		f.value.Set(from.value)
+		next.sources[f.key] = loaded.sources[f.key]
+		changed = append(changed, f.key)
+	}
+	return &next, changed, restart
+}
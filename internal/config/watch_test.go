@@ -0,0 +1,79 @@
+package config
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestWatcherReload(t *testing.T) {
+	const base = "database_url: postgres://localhost/servit\nsecret_key: 0123456789abcdef0123456789abcdef\n"
+	path := writeConfig(t, "servit.yaml", base)
+	cfg, loader, err := Load([]string{"-config", path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	w := NewWatcher(loader, cfg, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	w.Check(func(c *Config) error {
+		if strings.Contains(c.AllowedOrigins, "evil") {
+			return errors.New("ALLOWED_ORIGINS: refused by check")
+		}
+		return nil
+	})
+	var applied []*Config
+	w.Subscribe(func(c *Config) { applied = append(applied, c) })
+
+	rewrite := func(extra string) {
+		t.Helper()
+		if err := os.WriteFile(path, []byte(base+extra), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if !w.fileChanged() {
+			t.Fatal("file change not detected")
+		}
+	}
+
+	for _, tc := range []struct {
+		name, extra string
+		err         string // "" when the reload succeeds
+	}{
+		{"invalid value", "log_level: verbose\n", `LOG_LEVEL: "verbose" is not one of`},
+		{"malformed value", "max_rate_violations: lots\n", "invalid integer"},
+		{"failed check", "allowed_origins: https://evil.example\n", "refused by check"},
+		{"restart only", "port: 9001\n", ""},
+	} {
+		rewrite(tc.extra)
+		err := w.Reload()
+		if (err == nil) != (tc.err == "") || err != nil && !strings.Contains(err.Error(), tc.err) {
+			t.Errorf("%s: got %v, want %q", tc.name, err, tc.err)
+		}
+		if w.Current() != cfg || len(applied) != 0 {
+			t.Errorf("%s: configuration replaced", tc.name)
+		}
+	}
+
+	rewrite("port: 9001\nlog_level: debug\nmax_rate_violations: 3\n")
+	if err := w.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	next := w.Current()
+	if len(applied) != 1 || applied[0] != next {
+		t.Fatalf("subscribers called %d times", len(applied))
+	}
+	if next.LogLevel != "debug" || next.MaxRateViolations != 3 || next.Port != "8080" {
+		t.Errorf("got LOG_LEVEL=%s MAX_RATE_VIOLATIONS=%d PORT=%s, want debug, 3 and 8080",
+			next.LogLevel, next.MaxRateViolations, next.Port)
+	}
+	if cfg.LogLevel != "info" {
+		t.Errorf("previous configuration modified: LOG_LEVEL=%s", cfg.LogLevel)
+	}
+	if w.fileChanged() {
+		t.Error("reloaded file reported as changed")
+	}
+}
@@ -0,0 +1,465 @@
+package db
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gocql/gocql"
+)
+
+// migrationsTable records applied migrations in the session keyspace.
+const migrationsTable = "schema_migrations"
+
+// legacyKeyspace is the keyspace the first migrations were written for; they
+// qualify their tables with it. Migrations are not edited once released, as
+// that changes their checksum, so the qualifier is rewritten to the session
+// keyspace when they run instead.
+const legacyKeyspace = "messaging"
+
+var legacyQualifier = regexp.MustCompile(`(?i)\b` + legacyKeyspace + `\.`)
+
+// Migration is one versioned CQL migration. Files are named
+// <version>_<name>.cql, with an optional <version>_<name>.down.cql that
+// reverts it. A file may contain several statements separated by ";".
+type Migration struct {
+	Version  int64
+	Name     string
+	Checksum string // sha256 of the up script
+	Up       []string
+	Down     []string // nil without a down script
+}
+
+// MigrationStatus describes a migration and its state in the database.
+type MigrationStatus struct {
+	Migration
+	Applied   bool
+	AppliedAt time.Time
+	// Dirty is set when the migration failed after applying some of its
+	// statements; the next run resumes after the last applied one.
+	Dirty    bool
+	Modified bool // the file changed after the migration was applied
+	Missing  bool // applied, but the file no longer exists
+}
+
+type appliedMigration struct {
+	version   int64
+	name      string
+	checksum  string
+	applied   int // statements applied
+	dirty     bool
+	appliedAt time.Time
+}
+
+// Migrator applies the migrations in Dir to the session's keyspace.
+type Migrator struct {
+	Session *gocql.Session
+	Dir     string
+	Logger  *slog.Logger
+	// DryRun prints the statements that would run to Out instead of
+	// executing them.
+	DryRun bool
+	Out    io.Writer
+	// Keyspace is the session keyspace. Tables qualified with the legacy
+	// messaging keyspace are created in it.
+	Keyspace string
+}
+
+func NewMigrator(session *gocql.Session, dir string, logger *slog.Logger) *Migrator {
+	return &Migrator{Session: session, Dir: dir, Logger: logger, Out: os.Stdout, Keyspace: ScyllaKeyspace}
+}
+
+// statement returns stmt as run against the session keyspace.
+func (m *Migrator) statement(stmt string) string {
+	if m.Keyspace == "" || strings.EqualFold(m.Keyspace, legacyKeyspace) {
+		return stmt
+	}
+	return legacyQualifier.ReplaceAllLiteralString(stmt, m.Keyspace+".")
+}
+
+// RunMigrations applies every pending migration in migrationsDir.
+func RunMigrations(migrationsDir string) error {
+	_, err := NewMigrator(ScyllaSession, migrationsDir, slog.Default()).Up()
+	return err
+}
+
+// LoadMigrations reads and parses the migrations in dir, ordered by version.
+func LoadMigrations(dir string) ([]Migration, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read migrations directory: %w", err)
+	}
+
+	byVersion := make(map[int64]*Migration)
+	downs := make(map[int64]string)
+	for _, entry := range entries {
+		fileName := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(fileName, ".cql") {
+			continue
+		}
+		base, isDown := strings.CutSuffix(strings.TrimSuffix(fileName, ".cql"), ".down")
+		versionStr, name, ok := strings.Cut(base, "_")
+		version, err := strconv.ParseInt(versionStr, 10, 64)
+		if !ok || err != nil {
+			return nil, fmt.Errorf("invalid migration file name %s: expected <version>_<name>.cql", fileName)
+		}
+		data, err := os.ReadFile(filepath.Join(dir, fileName))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read migration file %s: %w", fileName, err)
+		}
+		statements, err := SplitStatements(string(data))
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse migration file %s: %w", fileName, err)
+		}
+
+		if isDown {
+			downs[version] = fileName
+			m := byVersion[version]
+			if m == nil {
+				m = &Migration{Version: version, Name: name}
+				byVersion[version] = m
+			}
+			// An empty down script reverts nothing, unlike a missing one.
+			if statements == nil {
+				statements = []string{}
+			}
+			m.Down = statements
+			continue
+		}
+		if m := byVersion[version]; m != nil && m.Checksum != "" {
+			return nil, fmt.Errorf("duplicate migration version %d", version)
+		}
+		m := byVersion[version]
+		if m == nil {
+			m = &Migration{Version: version}
+			byVersion[version] = m
+		}
+		sum := sha256.Sum256(data)
+		m.Name = name
+		m.Checksum = hex.EncodeToString(sum[:])
+		m.Up = statements
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for version, m := range byVersion {
+		if m.Checksum == "" {
+			return nil, fmt.Errorf("down migration %s has no up migration", downs[version])
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// Status reports every migration on disk or in the database.
+func (m *Migrator) Status() ([]MigrationStatus, error) {
+	migrations, err := LoadMigrations(m.Dir)
+	if err != nil {
+		return nil, err
+	}
+	applied, err := m.applied()
+	if err != nil {
+		return nil, err
+	}
+	return mergeStatus(migrations, applied), nil
+}
+
+// ReadStatus is Status for probes: it only reads, without creating the
+// migrations table, and a missing table means no migration was applied.
+func (m *Migrator) ReadStatus() ([]MigrationStatus, error) {
+	migrations, err := LoadMigrations(m.Dir)
+	if err != nil {
+		return nil, err
+	}
+	applied := make(map[int64]appliedMigration)
+	var table string
+	err = m.Session.Query(`SELECT table_name FROM system_schema.tables WHERE keyspace_name = ? AND table_name = ?`,
+		m.Keyspace, migrationsTable).Scan(&table)
+	switch {
+	case errors.Is(err, gocql.ErrNotFound):
+	case err != nil:
+		return nil, fmt.Errorf("failed to look up %s: %w", migrationsTable, err)
+	default:
+		if applied, err = m.readApplied(); err != nil {
+			return nil, err
+		}
+	}
+	return mergeStatus(migrations, applied), nil
+}
+
+func mergeStatus(migrations []Migration, applied map[int64]appliedMigration) []MigrationStatus {
+	statuses := make([]MigrationStatus, 0, len(migrations))
+	seen := make(map[int64]bool)
+	for _, mig := range migrations {
+		s := MigrationStatus{Migration: mig}
+		if a, ok := applied[mig.Version]; ok {
+			s.Applied = !a.dirty
+			s.Dirty = a.dirty
+			s.AppliedAt = a.appliedAt
+			s.Modified = a.checksum != mig.Checksum
+		}
+		seen[mig.Version] = true
+		statuses = append(statuses, s)
+	}
+	for version, a := range applied {
+		if !seen[version] {
+			statuses = append(statuses, MigrationStatus{
+				Migration: Migration{Version: version, Name: a.name, Checksum: a.checksum},
+				Applied:   !a.dirty,
+				Dirty:     a.dirty,
+				AppliedAt: a.appliedAt,
+				Missing:   true,
+			})
+		}
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Version < statuses[j].Version })
+	return statuses
+}
+
+// Up applies every pending migration in order and returns how many were
+// applied. It refuses to run when an applied migration was edited.
+func (m *Migrator) Up() (int, error) {
+	migrations, err := LoadMigrations(m.Dir)
+	if err != nil {
+		return 0, err
+	}
+	applied, err := m.applied()
+	if err != nil {
+		return 0, err
+	}
+	if err := checkModified(migrations, applied); err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for _, mig := range migrations {
+		a, ok := applied[mig.Version]
+		if ok && !a.dirty {
+			continue
+		}
+		if err := m.apply(mig, a.applied); err != nil {
+			return count, err
+		}
+		count++
+	}
+	if count == 0 {
+		m.Logger.Info("migrations up to date")
+	}
+	return count, nil
+}
+
+// checkModified fails if an applied migration was edited since.
+func checkModified(migrations []Migration, applied map[int64]appliedMigration) error {
+	for _, s := range mergeStatus(migrations, applied) {
+		if s.Modified {
+			return fmt.Errorf("migration %d_%s was modified after it was applied (checksum %s, applied %s)",
+				s.Version, s.Name, s.Checksum, applied[s.Version].checksum)
+		}
+	}
+	return nil
+}
+
+// apply runs the statements of a migration from index from, recording
+// progress after each one so that a failed migration can be resumed.
+func (m *Migrator) apply(mig Migration, from int) error {
+	if from > 0 {
+		m.Logger.Info("resuming migration", "version", mig.Version, "name", mig.Name, "statement", from+1)
+	} else {
+		m.Logger.Info("applying migration", "version", mig.Version, "name", mig.Name)
+	}
+	for i := from; i < len(mig.Up); i++ {
+		stmt := m.statement(mig.Up[i])
+		if m.DryRun {
+			fmt.Fprintf(m.Out, "-- %d_%s statement %d\n%s;\n\n", mig.Version, mig.Name, i+1, stmt)
+			continue
+		}
+		if err := m.Session.Query(stmt).Exec(); err != nil {
+			return fmt.Errorf("failed to execute statement %d of migration %d_%s: %w", i+1, mig.Version, mig.Name, err)
+		}
+		if err := m.record(mig, i+1, i+1 < len(mig.Up)); err != nil {
+			return err
+		}
+	}
+	if len(mig.Up) == 0 && !m.DryRun {
+		if err := m.record(mig, 0, false); err != nil {
+			return err
+		}
+	}
+	m.Logger.Info("migration applied", "version", mig.Version, "name", mig.Name, "dry_run", m.DryRun)
+	return nil
+}
+
+// Down reverts the last steps applied migrations using their down scripts.
+func (m *Migrator) Down(steps int) (int, error) {
+	migrations, err := LoadMigrations(m.Dir)
+	if err != nil {
+		return 0, err
+	}
+	applied, err := m.applied()
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for i := len(migrations) - 1; i >= 0 && count < steps; i-- {
+		mig := migrations[i]
+		a, ok := applied[mig.Version]
+		if !ok {
+			continue
+		}
+		if a.dirty {
+			return count, fmt.Errorf("migration %d_%s is partially applied, fix it before reverting", mig.Version, mig.Name)
+		}
+		if mig.Down == nil {
+			return count, fmt.Errorf("migration %d_%s has no down script", mig.Version, mig.Name)
+		}
+		m.Logger.Info("reverting migration", "version", mig.Version, "name", mig.Name)
+		for j, stmt := range mig.Down {
+			stmt = m.statement(stmt)
+			if m.DryRun {
+				fmt.Fprintf(m.Out, "-- %d_%s down statement %d\n%s;\n\n", mig.Version, mig.Name, j+1, stmt)
+				continue
+			}
+			if err := m.Session.Query(stmt).Exec(); err != nil {
+				return count, fmt.Errorf("failed to execute down statement %d of migration %d_%s: %w", j+1, mig.Version, mig.Name, err)
+			}
+		}
+		if !m.DryRun {
+			if err := m.Session.Query(`DELETE FROM `+migrationsTable+` WHERE version = ?`, mig.Version).Exec(); err != nil {
+				return count, fmt.Errorf("failed to remove migration %d from %s: %w", mig.Version, migrationsTable, err)
+			}
+		}
+		count++
+	}
+	return count, nil
+}
+
+func (m *Migrator) ensureTable() error {
+	err := m.Session.Query(`CREATE TABLE IF NOT EXISTS ` + migrationsTable + ` (
+		version bigint PRIMARY KEY,
+		name text,
+		checksum text,
+		statements_applied int,
+		dirty boolean,
+		applied_at timestamp
+	)`).Exec()
+	if err != nil {
+		return fmt.Errorf("failed to create %s table: %w", migrationsTable, err)
+	}
+	return nil
+}
+
+func (m *Migrator) applied() (map[int64]appliedMigration, error) {
+	// A dry run doesn't create the table; if it is missing nothing was applied.
+	if !m.DryRun {
+		if err := m.ensureTable(); err != nil {
+			return nil, err
+		}
+	}
+	applied, err := m.readApplied()
+	if err != nil && m.DryRun {
+		m.Logger.Warn("cannot read applied migrations, assuming none", "error", err)
+		return make(map[int64]appliedMigration), nil
+	}
+	return applied, err
+}
+
+func (m *Migrator) readApplied() (map[int64]appliedMigration, error) {
+	applied := make(map[int64]appliedMigration)
+	iter := m.Session.Query(`SELECT version, name, checksum, statements_applied, dirty, applied_at FROM ` + migrationsTable).Iter()
+	var a appliedMigration
+	for iter.Scan(&a.version, &a.name, &a.checksum, &a.applied, &a.dirty, &a.appliedAt) {
+		applied[a.version] = a
+	}
+	if err := iter.Close(); err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", migrationsTable, err)
+	}
+	return applied, nil
+}
+
+func (m *Migrator) record(mig Migration, statementsApplied int, dirty bool) error {
+	err := m.Session.Query(`INSERT INTO `+migrationsTable+` (version, name, checksum, statements_applied, dirty, applied_at)
+		VALUES (?, ?, ?, ?, ?, ?)`,
+		mig.Version, mig.Name, mig.Checksum, statementsApplied, dirty, time.Now()).Exec()
+	if err != nil {
+		return fmt.Errorf("failed to record migration %d: %w", mig.Version, err)
+	}
+	return nil
+}
+
+// SplitStatements splits a CQL script into statements on ";", ignoring
+// semicolons inside string literals, quoted identifiers, $$ strings and
+// comments. Comments are removed from the returned statements.
+func SplitStatements(src string) ([]string, error) {
+	var (
+		statements []string
+		current    strings.Builder
+	)
+	flush := func() {
+		if stmt := strings.TrimSpace(current.String()); stmt != "" {
+			statements = append(statements, stmt)
+		}
+		current.Reset()
+	}
+
+	for i := 0; i < len(src); i++ {
+		ch := src[i]
+		switch {
+		case ch == ';':
+			flush()
+		case strings.HasPrefix(src[i:], "--"), strings.HasPrefix(src[i:], "//"):
+			end := strings.IndexByte(src[i:], '\n')
+			if end < 0 {
+				i = len(src)
+			} else {
+				i += end
+				current.WriteByte('\n')
+			}
+		case strings.HasPrefix(src[i:], "/*"):
+			end := strings.Index(src[i+2:], "*/")
+			if end < 0 {
+				return nil, errors.New("unterminated block comment")
+			}
+			i += 2 + end + 1
+			current.WriteByte(' ')
+		case strings.HasPrefix(src[i:], "$$"):
+			end := strings.Index(src[i+2:], "$$")
+			if end < 0 {
+				return nil, errors.New("unterminated $$ string")
+			}
+			current.WriteString(src[i : i+2+end+2])
+			i += 2 + end + 1
+		case ch == '\'' || ch == '"':
+			// Quotes are escaped by doubling them.
+			j := i + 1
+			for ; j < len(src); j++ {
+				if src[j] == ch {
+					if j+1 < len(src) && src[j+1] == ch {
+						j++
+						continue
+					}
+					break
+				}
+			}
+			if j >= len(src) {
+				return nil, fmt.Errorf("unterminated %c quote", ch)
+			}
+			current.WriteString(src[i : j+1])
+			i = j
+		default:
+			current.WriteByte(ch)
+		}
+	}
+	flush()
+	return statements, nil
+}
@@ -0,0 +1,117 @@
+package db
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestSplitStatements(t *testing.T) {
+	for _, tc := range []struct {
+		name, src string
+		want      []string
+	}{
+		{"statements", "CREATE TABLE a (id int PRIMARY KEY);\nDROP TABLE b;", []string{"CREATE TABLE a (id int PRIMARY KEY)", "DROP TABLE b"}},
+		{"no trailing semicolon", "DROP TABLE a;\nDROP TABLE b\n", []string{"DROP TABLE a", "DROP TABLE b"}},
+		{"empty", " \n;;\n", nil},
+		{"string literal", "INSERT INTO a (s) VALUES ('x;y');", []string{"INSERT INTO a (s) VALUES ('x;y')"}},
+		{"doubled quote", "INSERT INTO a (s) VALUES ('it''s;');", []string{"INSERT INTO a (s) VALUES ('it''s;')"}},
+		{"quoted identifier", `SELECT "a;b" FROM t;`, []string{`SELECT "a;b" FROM t`}},
+		{"dollar string", "INSERT INTO a (s) VALUES ($$x;y$$);", []string{"INSERT INTO a (s) VALUES ($$x;y$$)"}},
+		{"line comments", "-- drop a; first\nDROP TABLE a; // then b;\nDROP TABLE b;", []string{"DROP TABLE a", "DROP TABLE b"}},
+		{"block comment", "DROP /* a; b */ TABLE a;", []string{"DROP   TABLE a"}},
+		{"comment only", "-- nothing to do;\n", nil},
+		{"comment marker in a string", "INSERT INTO a (s) VALUES ('--;');", []string{"INSERT INTO a (s) VALUES ('--;')"}},
+	} {
+		got, err := SplitStatements(tc.src)
+		if err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+			continue
+		}
+		if !slices.Equal(got, tc.want) {
+			t.Errorf("%s: got %q, want %q", tc.name, got, tc.want)
+		}
+	}
+
+	for _, src := range []string{"SELECT 'a;", `SELECT "a`, "SELECT $$a;", "DROP /* a;"} {
+		if _, err := SplitStatements(src); err == nil {
+			t.Errorf("%q: no error", src)
+		}
+	}
+}
+
+func TestLoadMigrations(t *testing.T) {
+	dir := t.TempDir()
+	for name, src := range map[string]string{
+		"1_create.cql":      "CREATE TABLE a (id int PRIMARY KEY);",
+		"1_create.down.cql": "DROP TABLE a;",
+		"2_seed.cql":        "INSERT INTO a (id) VALUES (1)",
+		"2_seed.down.cql":   "-- the rows are dropped with the table\n",
+		"3_index.cql":       "CREATE INDEX ON a (id);",
+		"README.md":         "not a migration",
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(src), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	migrations, err := LoadMigrations(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(migrations) != 3 {
+		t.Fatalf("loaded %d migrations, want 3", len(migrations))
+	}
+	if m := migrations[0]; m.Name != "create" || !slices.Equal(m.Down, []string{"DROP TABLE a"}) {
+		t.Errorf("unexpected migration %+v", m)
+	}
+	if m := migrations[1]; m.Down == nil || len(m.Down) != 0 || !slices.Equal(m.Up, []string{"INSERT INTO a (id) VALUES (1)"}) {
+		t.Errorf("empty down script not loaded: %+v", m)
+	}
+	if m := migrations[2]; m.Down != nil {
+		t.Errorf("missing down script loaded: %+v", m)
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "4_orphan.down.cql"), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadMigrations(dir); err == nil || !strings.Contains(err.Error(), "4_orphan.down.cql") {
+		t.Errorf("down script without an up script: %v", err)
+	}
+}
+
+func TestCheckModified(t *testing.T) {
+	migrations := []Migration{
+		{Version: 1, Name: "create", Checksum: "aaa"},
+		{Version: 2, Name: "seed", Checksum: "bbb"},
+	}
+	applied := map[int64]appliedMigration{
+		1: {version: 1, name: "create", checksum: "aaa"},
+		2: {version: 2, name: "seed", checksum: "ccc"},
+		3: {version: 3, name: "deleted", checksum: "ddd"},
+	}
+
+	statuses := mergeStatus(migrations, applied)
+	var modified, missing []int64
+	for _, s := range statuses {
+		if s.Modified {
+			modified = append(modified, s.Version)
+		}
+		if s.Missing {
+			missing = append(missing, s.Version)
+		}
+	}
+	if !slices.Equal(modified, []int64{2}) || !slices.Equal(missing, []int64{3}) {
+		t.Errorf("modified %v and missing %v, want [2] and [3]", modified, missing)
+	}
+
+	err := checkModified(migrations, applied)
+	if err == nil || !strings.Contains(err.Error(), "2_seed") || !strings.Contains(err.Error(), "checksum bbb, applied ccc") {
+		t.Errorf("got %v", err)
+	}
+	delete(applied, 2)
+	if err := checkModified(migrations, applied); err != nil {
+		t.Errorf("pending migration reported: %v", err)
+	}
+}
@@ -2,7 +2,8 @@ package db
 
 import (
 	"database/sql"
-	"log"
+	"log/slog"
+	"os"
 
 	_ "github.com/lib/pq" // PostgreSQL driver
 )
@@ -10,21 +11,23 @@ import (
 var DB *sql.DB
 
 // InitDB initializes the database connection and sets up connection pooling.
-func InitDB(databaseURL string) {
+func InitDB(databaseURL string, maxConns int) {
 	var err error
 	DB, err = sql.Open("postgres", databaseURL)
 	if err != nil {
-		log.Fatalf("Failed to connect to database: %v", err)
+		slog.Error("failed to connect to database", "error", err)
+		os.Exit(1)
 	}
 
 	// Set connection pool limits
-	DB.SetMaxOpenConns(25) // Max number of open connections to the database
-	DB.SetMaxIdleConns(25) // Max number of idle connections in the pool
+	DB.SetMaxOpenConns(maxConns) // Max number of open connections to the database
+	DB.SetMaxIdleConns(maxConns) // Max number of idle connections in the pool
 
 	err = DB.Ping()
 	if err != nil {
-		log.Fatalf("Failed to ping database: %v", err)
+		slog.Error("failed to ping database", "error", err)
+		os.Exit(1)
 	}
 
-	log.Println("Database connection established with connection pooling")
+	slog.Info("database connection established with connection pooling")
 }
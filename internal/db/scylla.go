@@ -1,22 +1,219 @@
 package db
 
 import (
+	"errors"
 	"fmt"
 	"os"
-	"path/filepath"
+	"regexp"
 	"sort"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/gocql/gocql"
 )
 
 var ScyllaSession *gocql.Session
 
-// InitScylla initializes the ScyllaDB connection
-func InitScylla(hosts []string) error {
+// ScyllaKeyspace is the keyspace of ScyllaSession, set by InitScylla.
+var ScyllaKeyspace = legacyKeyspace
+
+// Consistency levels applied by the chat queries, set by InitScylla.
+var (
+	ReadConsistency  = gocql.Quorum
+	WriteConsistency = gocql.Quorum
+)
+
+// ScyllaConfig describes how to connect to the ScyllaDB cluster and how the
+// keyspace is created.
+type ScyllaConfig struct {
+	Hosts    []string
+	Keyspace string
+	// Replication is SimpleStrategy with ReplicationFactor, or
+	// NetworkTopologyStrategy with a factor per data center.
+	Replication       string
+	ReplicationFactor int
+	DataCenters       map[string]int
+
+	ReadConsistency  string // e.g. LOCAL_QUORUM
+	WriteConsistency string
+	ProtoVersion     int
+
+	Timeout        time.Duration
+	ConnectTimeout time.Duration
+	Retries        int // retries per query with exponential backoff
+
+	Username string
+	Password string
+
+	TLS           bool
+	TLSCAFile     string
+	TLSCertFile   string // client certificate, optional
+	TLSKeyFile    string
+	TLSVerifyHost bool
+
+	// LocalDC routes queries to hosts in this data center first.
+	LocalDC    string
+	TokenAware bool // route queries to a replica of the partition
+}
+
+var keyspaceName = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_]{0,47}$`)
+
+// Validate reports the first invalid setting.
+func (c *ScyllaConfig) Validate() error {
+	if len(c.Hosts) == 0 {
+		return errors.New("at least one Scylla host is required")
+	}
+	for _, h := range c.Hosts {
+		if strings.TrimSpace(h) == "" {
+			return errors.New("Scylla hosts must not be empty")
+		}
+	}
+	if !keyspaceName.MatchString(c.Keyspace) {
+		return fmt.Errorf("invalid keyspace name %q: use up to 48 letters, digits and underscores, starting with a letter", c.Keyspace)
+	}
+	switch c.Replication {
+	case "SimpleStrategy":
+		if c.ReplicationFactor < 1 {
+			return fmt.Errorf("replication factor must be at least 1, got %d", c.ReplicationFactor)
+		}
+	case "NetworkTopologyStrategy":
+		if len(c.DataCenters) == 0 {
+			return errors.New("NetworkTopologyStrategy needs a replication factor per data center, e.g. dc1=3")
+		}
+		for dc, rf := range c.DataCenters {
+			if rf < 1 {
+				return fmt.Errorf("replication factor of data center %s must be at least 1, got %d", dc, rf)
+			}
+		}
+		if c.LocalDC != "" {
+			if _, ok := c.DataCenters[c.LocalDC]; !ok {
+				return fmt.Errorf("local data center %s has no replication factor", c.LocalDC)
+			}
+		}
+	default:
+		return fmt.Errorf("unknown replication strategy %q, use SimpleStrategy or NetworkTopologyStrategy", c.Replication)
+	}
+	if _, err := gocql.ParseConsistencyWrapper(c.ReadConsistency); err != nil {
+		return fmt.Errorf("invalid read consistency %q", c.ReadConsistency)
+	}
+	if _, err := gocql.ParseConsistencyWrapper(c.WriteConsistency); err != nil {
+		return fmt.Errorf("invalid write consistency %q", c.WriteConsistency)
+	}
+	if c.ProtoVersion < 3 || c.ProtoVersion > 4 {
+		return fmt.Errorf("unsupported protocol version %d, use 3 or 4", c.ProtoVersion)
+	}
+	if c.Timeout <= 0 || c.ConnectTimeout <= 0 {
+		return errors.New("Scylla timeouts must be positive")
+	}
+	if c.Retries < 0 {
+		return fmt.Errorf("retries must not be negative, got %d", c.Retries)
+	}
+	if (c.Username == "") != (c.Password == "") {
+		return errors.New("Scylla username and password must be set together")
+	}
+	if c.TLS {
+		if (c.TLSCertFile == "") != (c.TLSKeyFile == "") {
+			return errors.New("Scylla TLS certificate and key must be set together")
+		}
+		for _, f := range []string{c.TLSCAFile, c.TLSCertFile, c.TLSKeyFile} {
+			if f == "" {
+				continue
+			}
+			if _, err := os.Stat(f); err != nil {
+				return fmt.Errorf("Scylla TLS file: %w", err)
+			}
+		}
+	} else if c.TLSCAFile != "" || c.TLSCertFile != "" {
+		return errors.New("Scylla TLS files are set but TLS is disabled")
+	}
+	return nil
+}
+
+// ParseDataCenters parses a comma separated list of dc=replication_factor entries.
+func ParseDataCenters(s string) (map[string]int, error) {
+	dcs := make(map[string]int)
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		name, rfStr, ok := strings.Cut(entry, "=")
+		rf, err := strconv.Atoi(rfStr)
+		if !ok || err != nil || strings.TrimSpace(name) == "" {
+			return nil, fmt.Errorf("invalid data center %q: expected name=replication_factor", entry)
+		}
+		dcs[strings.TrimSpace(name)] = rf
+	}
+	return dcs, nil
+}
+
+// replication returns the replication map of the keyspace as CQL.
+func (c *ScyllaConfig) replication() string {
+	if c.Replication == "SimpleStrategy" {
+		return fmt.Sprintf("{'class': 'SimpleStrategy', 'replication_factor': %d}", c.ReplicationFactor)
+	}
+	dcs := make([]string, 0, len(c.DataCenters))
+	for dc := range c.DataCenters {
+		dcs = append(dcs, dc)
+	}
+	sort.Strings(dcs)
+	parts := []string{"'class': 'NetworkTopologyStrategy'"}
+	for _, dc := range dcs {
+		parts = append(parts, fmt.Sprintf("'%s': %d", strings.ReplaceAll(dc, "'", "''"), c.DataCenters[dc]))
+	}
+	return "{" + strings.Join(parts, ", ") + "}"
+}
+
+func (c *ScyllaConfig) cluster() *gocql.ClusterConfig {
+	cluster := gocql.NewCluster(c.Hosts...)
+	cluster.ProtoVersion = c.ProtoVersion
+	cluster.Timeout = c.Timeout
+	cluster.ConnectTimeout = c.ConnectTimeout
+	cluster.Consistency, _ = gocql.ParseConsistencyWrapper(c.ReadConsistency)
+	cluster.RetryPolicy = &gocql.ExponentialBackoffRetryPolicy{
+		NumRetries: c.Retries,
+		Min:        100 * time.Millisecond,
+		Max:        2 * time.Second,
+	}
+	if c.Username != "" {
+		cluster.Authenticator = gocql.PasswordAuthenticator{Username: c.Username, Password: c.Password}
+	}
+	if c.TLS {
+		cluster.SslOpts = &gocql.SslOptions{
+			CaPath:                 c.TLSCAFile,
+			CertPath:               c.TLSCertFile,
+			KeyPath:                c.TLSKeyFile,
+			EnableHostVerification: c.TLSVerifyHost,
+		}
+	}
+
+	// Host selection policies hold per-session state, so every cluster
+	// config gets its own.
+	var policy gocql.HostSelectionPolicy
+	if c.LocalDC != "" {
+		policy = gocql.DCAwareRoundRobinPolicy(c.LocalDC)
+	} else {
+		policy = gocql.RoundRobinHostPolicy()
+	}
+	if c.TokenAware {
+		policy = gocql.TokenAwareHostPolicy(policy)
+	}
+	cluster.PoolConfig.HostSelectionPolicy = policy
+	return cluster
+}
+
+// InitScylla validates cfg, creates the keyspace if needed and opens the
+// session used by the rest of the application.
+func InitScylla(cfg ScyllaConfig) error {
+	if err := cfg.Validate(); err != nil {
+		return fmt.Errorf("invalid Scylla configuration: %w", err)
+	}
+	ReadConsistency, _ = gocql.ParseConsistencyWrapper(cfg.ReadConsistency)
+	WriteConsistency, _ = gocql.ParseConsistencyWrapper(cfg.WriteConsistency)
+
 	// First connection without a keyspace to create it.
-	cluster := gocql.NewCluster(hosts...)
-	cluster.ProtoVersion = 4
+	cluster := cfg.cluster()
 	session, err := cluster.CreateSession()
 	if err != nil {
 		return fmt.Errorf("failed to connect to ScyllaDB: %w", err)
@@ -24,59 +221,21 @@ func InitScylla(hosts []string) error {
 	defer session.Close()
 
 	// Create keyspace if it doesn't exist.
-	err = session.Query(`
-		CREATE KEYSPACE IF NOT EXISTS messaging 
-		WITH replication = {
-			'class': 'SimpleStrategy',
-			'replication_factor': 1
-		}`).Exec()
+	err = session.Query(fmt.Sprintf(`CREATE KEYSPACE IF NOT EXISTS %s WITH replication = %s`,
+		cfg.Keyspace, cfg.replication())).Exec()
 	if err != nil {
 		return fmt.Errorf("failed to create keyspace: %w", err)
 	}
 
 	// Now connect again with the keyspace specified.
-	cluster.Keyspace = "messaging"
+	cluster = cfg.cluster()
+	cluster.Keyspace = cfg.Keyspace
 	scyllaSession, err := cluster.CreateSession()
 	if err != nil {
 		return fmt.Errorf("failed to create session with keyspace: %w", err)
 	}
 
 	ScyllaSession = scyllaSession
-	return nil
-}
-
-func RunMigrations(migrationsDir string) error {
-	// Read the migration directory entries.
-	entries, err := os.ReadDir(migrationsDir)
-	if err != nil {
-		return fmt.Errorf("failed to read migrations directory: %w", err)
-	}
-
-	// Filter for .cql files and sort them to ensure they run in order.
-	var migrationFiles []string
-	for _, entry := range entries {
-		if !entry.IsDir() && strings.HasSuffix(entry.Name(), ".cql") {
-			migrationFiles = append(migrationFiles, entry.Name())
-		}
-	}
-	sort.Strings(migrationFiles)
-
-	// Execute each migration.
-	for _, fileName := range migrationFiles {
-		filePath := filepath.Join(migrationsDir, fileName)
-		fmt.Printf("Applying migration: %s\n", fileName)
-
-		data, err := os.ReadFile(filePath)
-		if err != nil {
-			return fmt.Errorf("failed to read migration file %s: %w", fileName, err)
-		}
-
-		// Assume each migration file contains a single CQL statement.
-		cqlQuery := string(data)
-		if err := ScyllaSession.Query(cqlQuery).Exec(); err != nil {
-			return fmt.Errorf("failed to execute migration %s: %w", fileName, err)
-		}
-		fmt.Printf("Migration %s applied successfully.\n", fileName)
-	}
+	ScyllaKeyspace = cfg.Keyspace
 	return nil
 }
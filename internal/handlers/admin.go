@@ -0,0 +1,62 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"servit-go/internal/auth"
+	"servit-go/internal/logging"
+	"servit-go/internal/services"
+)
+
+// RevokeHandler revokes every token of a user, or a single token by jti, and
+// disconnects the affected WebSocket sessions on this node. Other nodes pick
+// up the revocation through Hub.WatchRevocations.
+func RevokeHandler(w http.ResponseWriter, r *http.Request, revocations auth.RevocationStore, hub *services.Hub, ttl time.Duration) {
+	var req struct {
+		UserID    string    `json:"user_id"`
+		JTI       string    `json:"jti"`
+		ExpiresAt time.Time `json:"expires_at"` // optional, defaults to now + ttl
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if (req.UserID == "") == (req.JTI == "") {
+		http.Error(w, "Exactly one of user_id and jti is required", http.StatusBadRequest)
+		return
+	}
+	if req.ExpiresAt.IsZero() {
+		req.ExpiresAt = time.Now().Add(ttl)
+	}
+
+	logger := logging.FromContext(r.Context())
+	var (
+		err          error
+		disconnected int
+	)
+	if req.UserID != "" {
+		err = revocations.RevokeUser(r.Context(), req.UserID, req.ExpiresAt)
+		if err == nil {
+			disconnected = hub.DisconnectUser(req.UserID)
+		}
+	} else {
+		err = revocations.RevokeToken(r.Context(), req.JTI, req.ExpiresAt)
+		if err == nil {
+			disconnected = hub.DisconnectToken(req.JTI)
+		}
+	}
+	if err != nil {
+		logger.Error("failed to revoke tokens", "revoked_user_id", req.UserID, "jti", req.JTI, "error", err)
+		http.Error(w, "Failed to revoke tokens", http.StatusInternalServerError)
+		return
+	}
+	logger.Info("tokens revoked", "revoked_user_id", req.UserID, "jti", req.JTI, "disconnected", disconnected)
+
+	response := struct {
+		Disconnected int `json:"disconnected"`
+	}{Disconnected: disconnected}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,38 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"servit-go/internal/auth"
+	"servit-go/internal/logging"
+	"servit-go/internal/middleware"
+)
+
+// IssueTicketHandler exchanges the caller's credentials for a single-use
+// ticket to open a WebSocket with ?ticket=.
+func IssueTicketHandler(w http.ResponseWriter, r *http.Request, tickets auth.TicketStore) {
+	id, ok := middleware.CurrentIdentity(r.Context())
+	if !ok {
+		http.Error(w, "User not authenticated", http.StatusUnauthorized)
+		return
+	}
+
+	ticket, err := tickets.Issue(r.Context(), id, auth.DefaultTicketTTL)
+	if err != nil {
+		logging.FromContext(r.Context()).Error("failed to issue ticket", "error", err)
+		http.Error(w, "Failed to issue ticket", http.StatusInternalServerError)
+		return
+	}
+
+	response := struct {
+		Ticket    string `json:"ticket"`
+		ExpiresIn int    `json:"expires_in"` // seconds
+	}{
+		Ticket:    ticket,
+		ExpiresIn: int(auth.DefaultTicketTTL.Seconds()),
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(response)
+}
@@ -4,18 +4,41 @@ import (
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
-	"log"
 	"net/http"
+	"servit-go/internal/logging"
 	"servit-go/internal/middleware"
 	"servit-go/internal/models"
 	"servit-go/internal/services"
 	"strconv"
-
-	"github.com/gorilla/websocket"
+	"sync/atomic"
 )
 
-var upgrader = websocket.Upgrader{
-	CheckOrigin: func(r *http.Request) bool { return true },
+// Pagination bounds the page_size of message history requests.
+type Pagination struct {
+	Default int // used when page_size is missing or invalid
+	Max     int // larger page sizes are capped
+}
+
+var pagination atomic.Pointer[Pagination]
+
+func init() {
+	pagination.Store(&Pagination{Default: 10, Max: 100})
+}
+
+// ConfigurePagination sets the page sizes of the history handlers. It may be
+// called again while serving, e.g. after a configuration reload.
+func ConfigurePagination(p Pagination) {
+	pagination.Store(&p)
+}
+
+// requestPageSize returns the page_size of r within the configured bounds.
+func requestPageSize(r *http.Request) int {
+	p := pagination.Load()
+	ps, err := strconv.Atoi(r.URL.Query().Get("page_size"))
+	if err != nil || ps < 1 {
+		return p.Default
+	}
+	return min(ps, p.Max)
 }
 
 // FetchPaginatedMessagesHandler retrieves messages between two users using paging state.
@@ -28,19 +51,14 @@ func FetchPaginatedMessagesHandler(w http.ResponseWriter, r *http.Request, chatS
 		var err error
 		pagingState, err = base64.StdEncoding.DecodeString(pagingStateStr)
 		if err != nil {
-			log.Print(err)
+			logging.FromContext(r.Context()).Warn("invalid paging_state", "error", err)
 			http.Error(w, "Invalid paging_state", http.StatusBadRequest)
 			return
 		}
 	}
 
-	// Optionally, allow the frontend to specify a page size; default to 50.
-	pageSize := 10
-	if psStr := r.URL.Query().Get("page_size"); psStr != "" {
-		if ps, err := strconv.Atoi(psStr); err == nil {
-			pageSize = ps
-		}
-	}
+	// Optionally allow the frontend to specify a page size.
+	pageSize := requestPageSize(r)
 
 	// Get fromUserID from context (authenticated user)
 	fromUserID, ok := r.Context().Value(middleware.UserIDKey).(string)
@@ -50,9 +68,9 @@ func FetchPaginatedMessagesHandler(w http.ResponseWriter, r *http.Request, chatS
 	}
 
 	// Fetch paginated messages using conversation-based query.
-	messages, newPagingState, err := chatService.QueryMessages(fromUserID, toUserID, pageSize, pagingState)
+	messages, newPagingState, err := chatService.QueryMessages(r.Context(), fromUserID, toUserID, pageSize, pagingState)
 	if err != nil {
-		log.Print(err)
+		logging.FromContext(r.Context()).Error("failed to fetch direct messages", "to_user_id", toUserID, "error", err)
 		http.Error(w, "Failed to fetch messages", http.StatusInternalServerError)
 		return
 	}
@@ -88,24 +106,19 @@ func FetchPaginatedChannelMessagesHandler(w http.ResponseWriter, r *http.Request
 		var err error
 		pagingState, err = base64.StdEncoding.DecodeString(pagingStateStr)
 		if err != nil {
-			log.Print(err)
+			logging.FromContext(r.Context()).Warn("invalid paging_state", "error", err)
 			http.Error(w, "Invalid paging_state", http.StatusBadRequest)
 			return
 		}
 	}
 
-	// Optionally allow the frontend to specify a page size; default to 10.
-	pageSize := 10
-	if psStr := r.URL.Query().Get("page_size"); psStr != "" {
-		if ps, err := strconv.Atoi(psStr); err == nil {
-			pageSize = ps
-		}
-	}
+	// Optionally allow the frontend to specify a page size.
+	pageSize := requestPageSize(r)
 
 	// Fetch paginated channel messages.
-	messages, newPagingState, err := chatService.QueryChannelMessages(channelID, pageSize, pagingState)
+	messages, newPagingState, err := chatService.QueryChannelMessages(r.Context(), channelID, pageSize, pagingState)
 	if err != nil {
-		log.Print(err)
+		logging.FromContext(r.Context()).Error("failed to fetch channel messages", "channel_id", channelID, "error", err)
 		http.Error(w, fmt.Sprintf("Failed to fetch channel messages: %v", err), http.StatusInternalServerError)
 		return
 	}
@@ -0,0 +1,231 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"servit-go/internal/logging"
+	"servit-go/internal/middleware"
+	"servit-go/internal/models"
+	"servit-go/internal/protocol"
+	"servit-go/internal/services"
+
+	"github.com/gin-contrib/sse"
+	"github.com/gin-gonic/gin"
+)
+
+// pollBatch is the most frames returned by one long-poll response.
+const pollBatch = 100
+
+// sseRetry is the reconnection delay suggested to EventSource clients.
+const sseRetry = 3 * time.Second
+
+// closeEvent reports the end of an HTTP session, like a WebSocket close frame.
+type closeEvent struct {
+	Code   int    `json:"code"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// pollResponse is the body of a long-poll response.
+type pollResponse struct {
+	SessionID string            `json:"session_id"`
+	Frames    []json.RawMessage `json:"frames"`
+	Closed    *closeEvent       `json:"closed,omitempty"`
+}
+
+// openSession registers an HTTP session for the authenticated user.
+func openSession(r *http.Request, hub *services.Hub, transport string, idle time.Duration) *services.Client {
+	id, _ := middleware.CurrentIdentity(r.Context())
+	sessionID := logging.NewID()
+	client := &services.Client{
+		ID:        id.UserID,
+		Username:  id.UserName,
+		SessionID: sessionID,
+		Token:     id,
+		Logger:    logging.FromContext(r.Context()).With("session_id", sessionID),
+		Context:   context.WithoutCancel(r.Context()),
+		Transport: transport,
+	}
+	hub.OpenSession(client, idle)
+	client.Logger.Info("http session started", "transport", transport)
+	return client
+}
+
+// EventsHandler streams the frames of a new session as Server-Sent Events.
+// Events are named after the frame type and carry the JSON frame. Numbered
+// frames have the ID "<session_id>:<seq>", so a reconnecting EventSource
+// resumes its previous session through the Last-Event-ID header; clients
+// that reconnect by hand pass it as the last_event_id query parameter.
+func EventsHandler(c *gin.Context, hub *services.Hub) {
+	client := openSession(c.Request, hub, "/events", 0)
+	defer client.Logger.Info("http session ended")
+	defer client.End()
+
+	lastEventID := c.GetHeader("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = c.Query("last_event_id")
+	}
+	if lastEventID != "" {
+		resumeFromEventID(c.Request.Context(), client, lastEventID)
+	}
+
+	w := c.Writer
+	w.Header().Set("Content-Type", sse.ContentType)
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no") // keeps nginx from buffering the stream
+	w.WriteHeader(http.StatusOK)
+	io.WriteString(w, "retry: "+strconv.FormatInt(sseRetry.Milliseconds(), 10)+"\n\n")
+	w.Flush()
+
+	ctx := c.Request.Context()
+	for {
+		// Wake up every ping interval to send a comment, which keeps proxies
+		// from timing out an idle stream.
+		waitCtx, cancel := context.WithTimeout(ctx, hub.PingInterval)
+		frame, err := client.NextFrame(waitCtx)
+		cancel()
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			io.WriteString(w, ": keepalive\n\n")
+			w.Flush()
+			continue
+		}
+		if code, reason, ok := frame.CloseMessage(); ok {
+			data, _ := json.Marshal(closeEvent{Code: code, Reason: reason})
+			sse.Encode(w, sse.Event{Event: "close", Data: string(data)})
+			w.Flush()
+			return
+		}
+		message, ok := client.EncodeFrame(frame)
+		if !ok {
+			continue
+		}
+		event := sse.Event{Event: frame.Type, Data: string(message)}
+		if frame.Seq > 0 {
+			event.Id = client.SessionID + ":" + strconv.FormatUint(frame.Seq, 10)
+		}
+		sse.Encode(w, event)
+		w.Flush()
+	}
+}
+
+// resumeFromEventID resumes the session named by an SSE event ID, as a
+// resume frame would.
+func resumeFromEventID(ctx context.Context, client *services.Client, eventID string) {
+	sessionID, seq, ok := strings.Cut(eventID, ":")
+	lastSeq, err := strconv.ParseUint(seq, 10, 64)
+	if !ok || err != nil {
+		client.Logger.Debug("ignoring malformed last event id", "last_event_id", eventID)
+		return
+	}
+	data, _ := json.Marshal(protocol.Resume{SessionID: sessionID, LastSeq: lastSeq})
+	frame, _ := json.Marshal(models.WSMessage{Type: protocol.TypeResume, Data: data})
+	if event := client.HandleFrame(ctx, frame); event != nil {
+		client.Logger.Info("resume from last event id rejected", "code", event.Code, "error", event.Message)
+	}
+}
+
+// PollHandler serves long-poll sessions. A request without session_id opens
+// a session, and its response carries the welcome frame. Later requests pass
+// the session_id and wait up to POLL_WAIT for frames; a session that isn't
+// polled for POLL_IDLE_TIMEOUT ends.
+func PollHandler(c *gin.Context, hub *services.Hub) {
+	var client *services.Client
+	if sessionID := c.Query("session_id"); sessionID != "" {
+		id, _ := middleware.CurrentIdentity(c.Request.Context())
+		client = hub.Session(id.UserID, sessionID)
+		if client == nil || client.Transport != "/poll" {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Unknown session"})
+			return
+		}
+	} else {
+		client = openSession(c.Request, hub, "/poll", hub.PollIdle)
+	}
+
+	frames, err := client.Poll(c.Request.Context(), pollBatch)
+	if errors.Is(err, services.ErrPollInProgress) {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		return // the client went away
+	}
+	resp := pollResponse{SessionID: client.SessionID, Frames: make([]json.RawMessage, 0, len(frames))}
+	for _, frame := range frames {
+		if code, reason, ok := frame.CloseMessage(); ok {
+			resp.Closed = &closeEvent{Code: code, Reason: reason}
+			continue
+		}
+		if message, ok := client.EncodeFrame(frame); ok {
+			resp.Frames = append(resp.Frames, message)
+		}
+	}
+	writeJSON(c.Writer, http.StatusOK, resp)
+}
+
+// frameErrorStatus maps the error codes of frames sent over HTTP to status
+// codes; other codes are answered with 400.
+var frameErrorStatus = map[string]int{
+	protocol.ErrRateLimited:  http.StatusTooManyRequests,
+	protocol.ErrForbidden:    http.StatusForbidden,
+	protocol.ErrUnauthorized: http.StatusUnauthorized,
+	protocol.ErrTooLarge:     http.StatusRequestEntityTooLarge,
+	protocol.ErrInternal:     http.StatusInternalServerError,
+}
+
+// SessionFrameHandler handles a frame sent over HTTP on behalf of one of the
+// user's sessions, whatever its transport. The body is a JSON frame as sent
+// over a WebSocket. Rejected frames are answered with the error event and a
+// matching status instead of an error frame on the session.
+func SessionFrameHandler(c *gin.Context, hub *services.Hub) {
+	id, _ := middleware.CurrentIdentity(c.Request.Context())
+	client := hub.Session(id.UserID, c.Param("session_id"))
+	if client == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Unknown session"})
+		return
+	}
+
+	body := io.Reader(c.Request.Body)
+	if limit := hub.ReadLimit(); limit > 0 {
+		body = http.MaxBytesReader(c.Writer, c.Request.Body, int64(limit))
+	}
+	message, err := io.ReadAll(body)
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			writeJSON(c.Writer, http.StatusRequestEntityTooLarge, protocol.NewError(protocol.ErrTooLarge,
+				"Frames may not exceed "+strconv.FormatInt(tooLarge.Limit, 10)+" bytes", ""))
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read the request body"})
+		return
+	}
+
+	event := client.HandleFrame(c.Request.Context(), message)
+	if event == nil {
+		c.Status(http.StatusNoContent)
+		return
+	}
+	writeFrameError(c, *event)
+}
+
+// writeFrameError answers an HTTP request with a rejected frame's error
+// event.
+func writeFrameError(c *gin.Context, event models.ErrorEvent) {
+	status, ok := frameErrorStatus[event.Code]
+	if !ok {
+		status = http.StatusBadRequest
+	}
+	if event.RetryAfterMs > 0 {
+		c.Header("Retry-After", strconv.FormatInt((event.RetryAfterMs+999)/1000, 10))
+	}
+	writeJSON(c.Writer, status, event)
+}
@@ -0,0 +1,58 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"runtime"
+	"time"
+
+	"servit-go/internal/health"
+	"servit-go/internal/services"
+)
+
+// startedAt is reported as the process uptime by the status endpoint.
+var startedAt = time.Now()
+
+// statusTopQueues is how many of the deepest send queues are listed.
+const statusTopQueues = 10
+
+// HealthzHandler reports that the process is alive. It checks no
+// dependencies so that a database outage doesn't get the process restarted.
+func HealthzHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// ReadyzHandler reports whether the process can serve traffic: its
+// dependencies answer and it isn't draining. Failing checks are only named;
+// their errors are reported by DebugStatusHandler.
+func ReadyzHandler(w http.ResponseWriter, r *http.Request, checker *health.Checker) {
+	report := checker.Run(r.Context())
+	status := http.StatusOK
+	if !report.Ready {
+		status = http.StatusServiceUnavailable
+	}
+	writeJSON(w, status, report.Redacted())
+}
+
+// DebugStatusHandler reports dependency latency along with Hub session counts
+// and send queue depths.
+func DebugStatusHandler(w http.ResponseWriter, r *http.Request, checker *health.Checker, hub *services.Hub) {
+	writeJSON(w, http.StatusOK, struct {
+		health.Report
+		Uptime     string            `json:"uptime"`
+		Goroutines int               `json:"goroutines"`
+		Hub        services.HubStats `json:"hub"`
+	}{
+		Report:     checker.Run(r.Context()),
+		Uptime:     time.Since(startedAt).Truncate(time.Second).String(),
+		Goroutines: runtime.NumGoroutine(),
+		Hub:        hub.Stats(statusTopQueues),
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,46 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"servit-go/internal/health"
+	"servit-go/internal/services"
+)
+
+func TestReadyzHidesCheckErrors(t *testing.T) {
+	const detail = "dial tcp 10.0.3.7:5432: password authentication failed for user servit"
+	checker := health.NewChecker(time.Second)
+	checker.Add("postgres", func(context.Context) error { return errors.New(detail) })
+	checker.Add("scylla", func(context.Context) error { return nil })
+
+	rec := httptest.NewRecorder()
+	ReadyzHandler(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil), checker)
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("got %d, want 503", rec.Code)
+	}
+	if strings.Contains(rec.Body.String(), "10.0.3.7") {
+		t.Errorf("check error leaked: %s", rec.Body)
+	}
+	var report health.Report
+	if err := json.Unmarshal(rec.Body.Bytes(), &report); err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Checks) != 2 || report.Checks[0].Name != "postgres" || report.Checks[0].Healthy || !report.Checks[1].Healthy {
+		t.Errorf("unexpected checks %+v", report.Checks)
+	}
+
+	rec = httptest.NewRecorder()
+	DebugStatusHandler(rec, httptest.NewRequest(http.MethodGet, "/debug/status", nil), checker, services.NewHub(slog.New(slog.NewTextHandler(io.Discard, nil)), services.HubOptions{}))
+	if !strings.Contains(rec.Body.String(), detail) {
+		t.Errorf("debug status lacks the check error: %s", rec.Body)
+	}
+}
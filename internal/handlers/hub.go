@@ -1,13 +1,16 @@
 package handlers
 
 import (
-	"log"
+	"context"
 	"net/http"
 	"strconv"
 	"time"
 
+	"servit-go/internal/logging"
+	"servit-go/internal/metrics"
 	"servit-go/internal/middleware"
 	"servit-go/internal/models"
+	"servit-go/internal/protocol"
 	"servit-go/internal/services"
 
 	"github.com/gin-gonic/gin"
@@ -15,22 +18,49 @@ import (
 
 // WsHandler upgrades the HTTP connection to a WebSocket and creates a new Client.
 func WsHandler(c *gin.Context, r *http.Request, hub *services.Hub) {
-	userID := r.Context().Value(middleware.UserIDKey).(string)
-	username := r.Context().Value(middleware.UserNameKey).(string)
-	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+	id, _ := middleware.CurrentIdentity(r.Context())
+	sessionID := logging.NewID()
+	logger := logging.FromContext(r.Context()).With("session_id", sessionID)
+
+	version, codec, subprotocol, err := protocol.Negotiate(r)
+	if err != nil {
+		metrics.UpgradeFailures.WithLabelValues("/ws").Inc()
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	var responseHeader http.Header
+	if subprotocol != "" {
+		responseHeader = http.Header{"Sec-WebSocket-Protocol": {subprotocol}}
+	}
+
+	conn, err := upgrade(c.Writer, c.Request, responseHeader)
 	if err != nil {
-		log.Println("WebSocket upgrade error:", err)
+		metrics.UpgradeFailures.WithLabelValues("/ws").Inc()
+		logger.Warn("websocket upgrade failed", "error", err)
 		return
 	}
+	logger.Info("websocket session started", "protocol_version", version, "codec", codec.Name())
+	defer logger.Info("websocket session ended")
+	metrics.Sessions.WithLabelValues("/ws").Inc()
+	defer metrics.Sessions.WithLabelValues("/ws").Dec()
 	client := &services.Client{
-		ID:         userID,
-		Username:   username,
+		ID:         id.UserID,
+		Username:   id.UserName,
+		SessionID:  sessionID,
+		Token:      id,
+		Version:    version,
+		Codec:      codec,
+		Logger:     logger,
+		Context:    context.WithoutCancel(r.Context()),
 		Conn:       conn,
-		Send:       make(chan []byte, 1024),
+		Send:       make(chan *protocol.Frame, hub.SendBuffer),
+		Ephemeral:  make(chan *protocol.Frame, hub.EphemeralBuffer),
 		Hub:        hub,
 		ActiveChat: nil,
 		Unread:     make(map[string]int),
 	}
+	// Welcome first so that it precedes any frame queued once registered.
+	client.Welcome()
 	hub.Register(client)
 	go client.ReadPump()
 	client.WritePump()
@@ -0,0 +1,106 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"servit-go/internal/auth"
+	"servit-go/internal/logging"
+	"servit-go/internal/middleware"
+	"servit-go/internal/models"
+	"servit-go/internal/protocol"
+	"servit-go/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxMessageBody bounds the body of message requests.
+const maxMessageBody = 64 << 10
+
+// PostChannelMessageHandler posts the message in the body to the channel in
+// the path, as a channel_message frame would, and answers 201 with the
+// stored message. With onBehalf, for admin routes, the message is posted as
+// the sender_id of the body instead of the authenticated user.
+func PostChannelMessageHandler(c *gin.Context, hub *services.Hub, onBehalf bool) {
+	var msg models.ChannelMessage
+	if !decodeMessage(c, &msg) {
+		return
+	}
+	channelID := c.Param("channel_id")
+	if msg.ChannelID != "" && msg.ChannelID != channelID {
+		writeJSON(c.Writer, http.StatusBadRequest, protocol.NewError(protocol.ErrInvalidPayload, "channel_id does not match the path", ""))
+		return
+	}
+	msg.ChannelID = channelID
+
+	sender, ok := messageSender(c, msg.SenderID, msg.SenderUsername, onBehalf)
+	if !ok {
+		return
+	}
+	err := services.PostChannelMessage(c.Request.Context(), hub, sender, &msg)
+	writeMessageResult(c, protocol.TypeChannelMessage, msg, err)
+}
+
+// PostDirectMessageHandler sends the message in the body to the user in the
+// path, like PostChannelMessageHandler.
+func PostDirectMessageHandler(c *gin.Context, hub *services.Hub, onBehalf bool) {
+	var msg models.DMMessage
+	if !decodeMessage(c, &msg) {
+		return
+	}
+	receiverID := c.Param("user_id")
+	if msg.ReceiverID != "" && msg.ReceiverID != receiverID {
+		writeJSON(c.Writer, http.StatusBadRequest, protocol.NewError(protocol.ErrInvalidPayload, "receiver_id does not match the path", ""))
+		return
+	}
+	msg.ReceiverID = receiverID
+
+	sender, ok := messageSender(c, msg.SenderID, "", onBehalf)
+	if !ok {
+		return
+	}
+	err := services.PostDirectMessage(c.Request.Context(), hub, sender, &msg)
+	writeMessageResult(c, protocol.TypeDirectMessage, msg, err)
+}
+
+func decodeMessage(c *gin.Context, msg any) bool {
+	err := json.NewDecoder(http.MaxBytesReader(c.Writer, c.Request.Body, maxMessageBody)).Decode(msg)
+	var tooLarge *http.MaxBytesError
+	switch {
+	case errors.As(err, &tooLarge):
+		writeJSON(c.Writer, http.StatusRequestEntityTooLarge, protocol.NewError(protocol.ErrTooLarge, "Request body is too large", ""))
+		return false
+	case err != nil:
+		writeJSON(c.Writer, http.StatusBadRequest, protocol.NewError(protocol.ErrInvalidPayload, "Body is not a valid message", ""))
+		return false
+	}
+	return true
+}
+
+// messageSender returns the identity a message is posted as.
+func messageSender(c *gin.Context, senderID, username string, onBehalf bool) (auth.Identity, bool) {
+	if !onBehalf {
+		id, _ := middleware.CurrentIdentity(c.Request.Context())
+		return id, true
+	}
+	if senderID == "" {
+		writeJSON(c.Writer, http.StatusBadRequest, protocol.NewError(protocol.ErrInvalidPayload, "sender_id is required", ""))
+		return auth.Identity{}, false
+	}
+	return auth.Identity{UserID: senderID, UserName: username}, true
+}
+
+func writeMessageResult(c *gin.Context, frameType string, msg any, err error) {
+	if err == nil {
+		writeJSON(c.Writer, http.StatusCreated, msg)
+		return
+	}
+	var frameErr *services.FrameError
+	if errors.As(err, &frameErr) {
+		writeFrameError(c, protocol.NewError(frameErr.Code, frameErr.Message, frameType))
+		return
+	}
+	logging.FromContext(c.Request.Context()).Error("failed to post message", "type", frameType, "error", err)
+	writeFrameError(c, protocol.NewError(protocol.ErrInternal, "Failed to store the message", frameType))
+}
@@ -0,0 +1,53 @@
+package handlers
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"servit-go/internal/models"
+	"servit-go/internal/protocol"
+	"servit-go/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestMalformedMessageIDsAreBadRequests(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	hub := services.NewHub(slog.New(slog.NewTextHandler(io.Discard, nil)), services.HubOptions{})
+	router := gin.New()
+	router.POST("/channels/:channel_id/messages", func(c *gin.Context) {
+		PostChannelMessageHandler(c, hub, true)
+	})
+	router.POST("/dms/:user_id/messages", func(c *gin.Context) {
+		PostDirectMessageHandler(c, hub, true)
+	})
+
+	const id = "9f1c2a64-5b1e-4c47-8d7e-3a2f6b0c9d11"
+	for _, tc := range []struct {
+		path, body, message string
+	}{
+		{"/channels/general/messages", `{"sender_id": "` + id + `", "content": "hi"}`, "channel_id must be a UUID"},
+		{"/channels/" + id + "/messages", `{"sender_id": "alice", "content": "hi"}`, "sender_id must be a UUID"},
+		{"/dms/bob/messages", `{"sender_id": "` + id + `", "content": "hi"}`, "receiver_id must be a UUID"},
+		{"/dms/" + id + "/messages", `{"sender_id": "alice", "content": "hi"}`, "sender_id must be a UUID"},
+	} {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, tc.path, strings.NewReader(tc.body)))
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: got %d, want 400", tc.path, rec.Code)
+			continue
+		}
+		var event models.ErrorEvent
+		if err := json.Unmarshal(rec.Body.Bytes(), &event); err != nil {
+			t.Fatal(err)
+		}
+		if event.Code != protocol.ErrInvalidPayload || event.Message != tc.message {
+			t.Errorf("%s: got %+v", tc.path, event)
+		}
+	}
+}
@@ -2,30 +2,35 @@ package handlers
 
 import (
 	"encoding/json"
-	"log"
 	"net/http"
+	"servit-go/internal/logging"
+	"servit-go/internal/metrics"
 	"servit-go/internal/middleware"
 	"servit-go/internal/services"
 )
 
 func OnlineHandler(c http.ResponseWriter, r *http.Request, onlineService *services.OnlineService) {
 	userId := r.Context().Value(middleware.UserIDKey).(string)
+	logger := logging.FromContext(r.Context()).With("session_id", logging.NewID())
 
-	conn, err := upgrader.Upgrade(c, r, nil)
+	conn, err := upgrade(c, r, nil)
 	if err != nil {
-		log.Println("WebSocket Upgrade Error:", err)
+		metrics.UpgradeFailures.WithLabelValues("/ws/online").Inc()
+		logger.Warn("websocket upgrade failed", "error", err)
 		return
 	}
 	defer conn.Close()
+	metrics.Sessions.WithLabelValues("/ws/online").Inc()
+	defer metrics.Sessions.WithLabelValues("/ws/online").Dec()
 	// Register the user connection
 	onlineService.Register(userId, conn)
-	defer onlineService.Unregister(userId)
+	defer onlineService.Unregister(userId, conn)
 
 	// Listen for messages from the client (optional, can listen for pings, etc.)
 	for {
 		_, _, err := conn.ReadMessage()
 		if err != nil {
-			log.Println("Error reading message:", err)
+			logger.Debug("online connection closed", "error", err)
 			break
 		}
 	}
@@ -0,0 +1,138 @@
+package handlers
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"servit-go/internal/auth"
+	"servit-go/internal/middleware"
+	"servit-go/internal/protocol"
+	"servit-go/internal/services"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/gorilla/websocket"
+)
+
+var testSecret = []byte("0123456789abcdef0123456789abcdef")
+
+type revocationServer struct {
+	*httptest.Server
+	hub         *services.Hub
+	revocations *auth.MemoryRevocationStore
+}
+
+func newRevocationServer(t *testing.T) *revocationServer {
+	gin.SetMode(gin.TestMode)
+	revocations := auth.NewMemoryRevocationStore()
+	authenticator, err := auth.NewAuthenticator(auth.JWTOptions{SecretKey: testSecret, Revocations: revocations})
+	if err != nil {
+		t.Fatal(err)
+	}
+	hub := services.NewHub(slog.New(slog.NewTextHandler(io.Discard, nil)), services.HubOptions{Authenticator: authenticator})
+	router := gin.New()
+	router.GET("/ws", middleware.JWTAuthMiddleware(middleware.AuthOptions{Authenticator: authenticator}), func(c *gin.Context) {
+		WsHandler(c, c.Request, hub)
+	})
+	s := &revocationServer{Server: httptest.NewServer(router), hub: hub, revocations: revocations}
+	t.Cleanup(s.Close)
+	return s
+}
+
+func signToken(t *testing.T, jti string, issuedAt time.Time) string {
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"id":  "user-1",
+		"sub": "alice",
+		"jti": jti,
+		"iat": issuedAt.Unix(),
+		"exp": issuedAt.Add(time.Hour).Unix(),
+	}).SignedString(testSecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+// connect opens a session with token and waits for it to be registered.
+func (s *revocationServer) connect(t *testing.T, token string) *websocket.Conn {
+	url := "ws" + strings.TrimPrefix(s.URL, "http") + "/ws"
+	conn, _, err := websocket.DefaultDialer.Dial(url, http.Header{"Authorization": {"Bearer " + token}})
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	if _, _, err := conn.ReadMessage(); err != nil { // welcome
+		t.Fatalf("read welcome: %v", err)
+	}
+	s.waitSessions(t, 1)
+	return conn
+}
+
+func (s *revocationServer) waitSessions(t *testing.T, want int) {
+	deadline := time.Now().Add(2 * time.Second)
+	for len(s.hub.Sessions("user-1")) != want {
+		if time.Now().After(deadline) {
+			t.Fatalf("sessions = %d, want %d", len(s.hub.Sessions("user-1")), want)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func expectClose(t *testing.T, conn *websocket.Conn, code int) {
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for {
+		_, _, err := conn.ReadMessage()
+		if err == nil {
+			continue
+		}
+		if !websocket.IsCloseError(err, code) {
+			t.Fatalf("read: %v, want close %d", err, code)
+		}
+		return
+	}
+}
+
+func TestNewTokenSurvivesUserRevocation(t *testing.T) {
+	s := newRevocationServer(t)
+	old := s.connect(t, signToken(t, "old", time.Now().Add(-time.Minute)))
+
+	if err := s.revocations.RevokeUser(context.Background(), "user-1", time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if n := s.hub.DisconnectUser("user-1"); n != 1 {
+		t.Fatalf("DisconnectUser closed %d sessions, want 1", n)
+	}
+	expectClose(t, old, protocol.CloseSessionRevoked)
+	s.waitSessions(t, 0)
+
+	// iat has a precision of a second, and tokens issued within the second
+	// of the revocation count as revoked.
+	s.connect(t, signToken(t, "new", time.Now().Add(time.Second)))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go s.hub.WatchRevocations(ctx, 5*time.Millisecond)
+	time.Sleep(50 * time.Millisecond)
+	if n := len(s.hub.Sessions("user-1")); n != 1 {
+		t.Fatalf("session with a token issued after the revocation was closed, sessions = %d", n)
+	}
+}
+
+func TestRevokedTokenDisconnectsSession(t *testing.T) {
+	s := newRevocationServer(t)
+	conn := s.connect(t, signToken(t, "jti-1", time.Now()))
+
+	if err := s.revocations.RevokeToken(context.Background(), "jti-1", time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if n := s.hub.DisconnectToken("jti-1"); n != 1 {
+		t.Fatalf("DisconnectToken closed %d sessions, want 1", n)
+	}
+	expectClose(t, conn, protocol.CloseSessionRevoked)
+}
@@ -0,0 +1,67 @@
+package handlers
+
+import (
+	"compress/flate"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/gorilla/websocket"
+)
+
+// UpgraderOptions configures the WebSocket upgrader shared by every endpoint.
+type UpgraderOptions struct {
+	ReadBufferSize    int
+	WriteBufferSize   int
+	EnableCompression bool // negotiate permessage-deflate with clients that support it
+	CompressionLevel  int  // flate level used for compressed connections
+	// CheckOrigin validates the Origin header; nil only accepts same-origin requests.
+	CheckOrigin func(r *http.Request) bool
+}
+
+var (
+	upgraderMu sync.RWMutex // guards upgrader and compressionLevel
+	upgrader   = websocket.Upgrader{
+		WriteBufferPool: &sync.Pool{},
+	}
+	compressionLevel = flate.BestSpeed
+)
+
+// ConfigureUpgrader applies opts to the shared upgrader. It may be called
+// again while serving, e.g. after a configuration reload, and then only
+// affects new connections.
+func ConfigureUpgrader(opts UpgraderOptions) error {
+	if opts.EnableCompression &&
+		(opts.CompressionLevel < flate.HuffmanOnly || opts.CompressionLevel > flate.BestCompression) {
+		return fmt.Errorf("compression level %d out of range [%d, %d]",
+			opts.CompressionLevel, flate.HuffmanOnly, flate.BestCompression)
+	}
+	upgraderMu.Lock()
+	defer upgraderMu.Unlock()
+	upgrader.ReadBufferSize = opts.ReadBufferSize
+	upgrader.WriteBufferSize = opts.WriteBufferSize
+	upgrader.EnableCompression = opts.EnableCompression
+	upgrader.CheckOrigin = opts.CheckOrigin
+	compressionLevel = opts.CompressionLevel
+	return nil
+}
+
+// upgrade upgrades the request and applies the compression level when
+// permessage-deflate was negotiated.
+func upgrade(w http.ResponseWriter, r *http.Request, responseHeader http.Header) (*websocket.Conn, error) {
+	upgraderMu.RLock()
+	u, level := upgrader, compressionLevel
+	upgraderMu.RUnlock()
+
+	conn, err := u.Upgrade(w, r, responseHeader)
+	if err != nil {
+		return nil, err
+	}
+	if u.EnableCompression {
+		if err := conn.SetCompressionLevel(level); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return conn, nil
+}
@@ -0,0 +1,219 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"servit-go/internal/logging"
+	"servit-go/internal/middleware"
+	"servit-go/internal/webhooks"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Bounds of the limit parameter of delivery logs.
+const (
+	defaultDeliveries = 50
+	maxDeliveries     = 200
+)
+
+// CreateWebhookHandler registers a webhook for the channel in the path,
+// owned by the authenticated user, who must own the channel. With onBehalf,
+// for admin routes, it is owned by the owner_id of the body instead. The
+// response is the only one to include the secret. The URL must be allowed by
+// policy.
+func CreateWebhookHandler(c *gin.Context, store webhooks.Store, policy webhooks.Policy, onBehalf bool) {
+	var req struct {
+		OwnerID string   `json:"owner_id"`
+		URL     string   `json:"url"`
+		Events  []string `json:"events"` // defaults to every event
+		Secret  string   `json:"secret"` // defaults to a random one
+	}
+	if err := json.NewDecoder(c.Request.Body).Decode(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+	channelID := c.Param("channel_id")
+	logger := logging.FromContext(c.Request.Context())
+	if !onBehalf {
+		id, _ := middleware.CurrentIdentity(c.Request.Context())
+		owner, err := store.ChannelOwner(c.Request.Context(), channelID)
+		switch {
+		case errors.Is(err, webhooks.ErrNotFound) || err == nil && owner != id.UserID:
+			c.JSON(http.StatusForbidden, gin.H{"error": "Only the owner of the channel can register webhooks"})
+			return
+		case err != nil:
+			logger.Error("failed to look up channel owner", "channel_id", channelID, "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create the webhook"})
+			return
+		}
+		req.OwnerID = id.UserID
+	}
+	w := &webhooks.Webhook{
+		ID:        logging.NewID(),
+		ChannelID: channelID,
+		OwnerID:   req.OwnerID,
+		URL:       req.URL,
+		Secret:    req.Secret,
+		Events:    req.Events,
+		CreatedAt: time.Now().UTC(),
+	}
+	if err := w.Validate(policy); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := store.CreateWebhook(c.Request.Context(), w); err != nil {
+		logger.Error("failed to create webhook", "channel_id", w.ChannelID, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create the webhook"})
+		return
+	}
+	logger.Info("webhook created", "webhook_id", w.ID, "channel_id", w.ChannelID, "owner_id", w.OwnerID)
+	c.JSON(http.StatusCreated, w)
+}
+
+// SetChannelOwnerHandler makes the owner_id of the body the owner of the
+// channel in the path.
+func SetChannelOwnerHandler(c *gin.Context, store webhooks.Store) {
+	var req struct {
+		OwnerID string `json:"owner_id"`
+	}
+	if err := json.NewDecoder(c.Request.Body).Decode(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+	if req.OwnerID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "owner_id is required"})
+		return
+	}
+	channelID := c.Param("channel_id")
+	logger := logging.FromContext(c.Request.Context())
+	if err := store.SetChannelOwner(c.Request.Context(), channelID, req.OwnerID); err != nil {
+		logger.Error("failed to set channel owner", "channel_id", channelID, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to set the channel owner"})
+		return
+	}
+	logger.Info("channel owner set", "channel_id", channelID, "owner_id", req.OwnerID)
+	c.Status(http.StatusNoContent)
+}
+
+// ChannelWebhooksHandler lists the webhooks of the channel in the path.
+func ChannelWebhooksHandler(c *gin.Context, store webhooks.Store) {
+	list, err := store.ChannelWebhooks(c.Request.Context(), c.Param("channel_id"))
+	writeWebhooks(c, list, err)
+}
+
+// OwnWebhooksHandler lists the webhooks owned by the user.
+func OwnWebhooksHandler(c *gin.Context, store webhooks.Store) {
+	id, _ := middleware.CurrentIdentity(c.Request.Context())
+	list, err := store.OwnerWebhooks(c.Request.Context(), id.UserID)
+	writeWebhooks(c, list, err)
+}
+
+func writeWebhooks(c *gin.Context, list []webhooks.Webhook, err error) {
+	if err != nil {
+		logging.FromContext(c.Request.Context()).Error("failed to list webhooks", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list webhooks"})
+		return
+	}
+	for i := range list {
+		list[i].Secret = ""
+	}
+	c.JSON(http.StatusOK, gin.H{"webhooks": append(make([]webhooks.Webhook, 0, len(list)), list...)})
+}
+
+// ownWebhook returns the webhook in the path if the user owns it. Otherwise
+// it answers the request and returns nil.
+func ownWebhook(c *gin.Context, store webhooks.Store) *webhooks.Webhook {
+	id, _ := middleware.CurrentIdentity(c.Request.Context())
+	w, err := store.Webhook(c.Request.Context(), c.Param("webhook_id"))
+	switch {
+	case errors.Is(err, webhooks.ErrNotFound) || err == nil && w.OwnerID != id.UserID:
+		c.JSON(http.StatusNotFound, gin.H{"error": "Unknown webhook"})
+		return nil
+	case err != nil:
+		logging.FromContext(c.Request.Context()).Error("failed to load webhook", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load the webhook"})
+		return nil
+	}
+	w.Secret = ""
+	return w
+}
+
+// WebhookHandler returns a webhook owned by the user.
+func WebhookHandler(c *gin.Context, store webhooks.Store) {
+	if w := ownWebhook(c, store); w != nil {
+		c.JSON(http.StatusOK, w)
+	}
+}
+
+// DeleteWebhookHandler deletes a webhook owned by the user, along with its
+// deliveries.
+func DeleteWebhookHandler(c *gin.Context, store webhooks.Store) {
+	w := ownWebhook(c, store)
+	if w == nil {
+		return
+	}
+	err := store.DeleteWebhook(c.Request.Context(), w.ID)
+	if err != nil && !errors.Is(err, webhooks.ErrNotFound) {
+		logging.FromContext(c.Request.Context()).Error("failed to delete webhook", "webhook_id", w.ID, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete the webhook"})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// DeliveriesHandler returns the delivery log of a webhook owned by the user,
+// newest first. The status parameter filters it, e.g. status=dead lists the
+// dead letters.
+func DeliveriesHandler(c *gin.Context, store webhooks.Store) {
+	w := ownWebhook(c, store)
+	if w == nil {
+		return
+	}
+	status := c.Query("status")
+	if status != "" && !slices.Contains([]string{webhooks.StatusPending, webhooks.StatusDelivered, webhooks.StatusDead}, status) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "status must be pending, delivered or dead"})
+		return
+	}
+	limit := defaultDeliveries
+	if s := c.Query("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 || n > maxDeliveries {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be between 1 and " + strconv.Itoa(maxDeliveries)})
+			return
+		}
+		limit = n
+	}
+	deliveries, err := store.Deliveries(c.Request.Context(), w.ID, status, limit)
+	if err != nil {
+		logging.FromContext(c.Request.Context()).Error("failed to list webhook deliveries", "webhook_id", w.ID, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list deliveries"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"deliveries": append(make([]webhooks.Delivery, 0, len(deliveries)), deliveries...)})
+}
+
+// RedeliverHandler queues a delivered or dead delivery of a webhook owned by
+// the user again.
+func RedeliverHandler(c *gin.Context, store webhooks.Store, dispatcher *webhooks.Dispatcher) {
+	w := ownWebhook(c, store)
+	if w == nil {
+		return
+	}
+	d, err := dispatcher.Redeliver(c.Request.Context(), w.ID, c.Param("delivery_id"))
+	switch {
+	case errors.Is(err, webhooks.ErrNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Unknown delivery"})
+	case errors.Is(err, webhooks.ErrPending):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case err != nil:
+		logging.FromContext(c.Request.Context()).Error("failed to redeliver webhook", "webhook_id", w.ID, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to redeliver"})
+	default:
+		c.JSON(http.StatusAccepted, d)
+	}
+}
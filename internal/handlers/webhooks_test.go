@@ -0,0 +1,67 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"servit-go/internal/auth"
+	"servit-go/internal/middleware"
+	"servit-go/internal/webhooks"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestOnlyChannelOwnersRegisterWebhooks(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	authenticator, err := auth.NewAuthenticator(auth.JWTOptions{SecretKey: testSecret})
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := webhooks.NewMemoryStore()
+	router := gin.New()
+	router.POST("/channels/:channel_id/webhooks", middleware.JWTAuthMiddleware(middleware.AuthOptions{Authenticator: authenticator}), func(c *gin.Context) {
+		CreateWebhookHandler(c, store, webhooks.Policy{}, false)
+	})
+	token := signToken(t, "", time.Now())
+	register := func(channelID string) *httptest.ResponseRecorder {
+		body := `{"owner_id": "someone-else", "url": "https://example.com/hook"}`
+		req := httptest.NewRequest(http.MethodPost, "/channels/"+channelID+"/webhooks", strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	ctx := context.Background()
+	if err := store.SetChannelOwner(ctx, "general", "user-1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.SetChannelOwner(ctx, "random", "user-2"); err != nil {
+		t.Fatal(err)
+	}
+	for _, channelID := range []string{"random", "unowned"} {
+		if rec := register(channelID); rec.Code != http.StatusForbidden {
+			t.Errorf("%s: got %d, want 403", channelID, rec.Code)
+		}
+	}
+
+	rec := register("general")
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("got %d: %s", rec.Code, rec.Body)
+	}
+	var w webhooks.Webhook
+	if err := json.Unmarshal(rec.Body.Bytes(), &w); err != nil {
+		t.Fatal(err)
+	}
+	if w.OwnerID != "user-1" || w.ChannelID != "general" || w.Secret == "" {
+		t.Errorf("unexpected webhook %+v", w)
+	}
+	if list, _ := store.ChannelWebhooks(ctx, "general"); len(list) != 1 {
+		t.Errorf("expected one webhook, got %d", len(list))
+	}
+}
@@ -0,0 +1,65 @@
+package health
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sync/atomic"
+
+	"servit-go/internal/db"
+
+	"github.com/gocql/gocql"
+)
+
+// Postgres pings the connection pool.
+func Postgres(pool *sql.DB) Check {
+	return func(ctx context.Context) error {
+		if pool == nil {
+			return errors.New("not connected")
+		}
+		return pool.PingContext(ctx)
+	}
+}
+
+// Scylla runs a trivial query on the session.
+func Scylla(session *gocql.Session) Check {
+	return func(ctx context.Context) error {
+		if session == nil || session.Closed() {
+			return errors.New("not connected")
+		}
+		return session.Query("SELECT release_version FROM system.local").WithContext(ctx).Exec()
+	}
+}
+
+// Migrations fails until every migration of m is applied cleanly. Once they
+// are, the result is remembered and the table is no longer queried. It never
+// creates the migrations table, which is left to the migrator.
+func Migrations(m *db.Migrator) Check {
+	var applied atomic.Bool
+	return func(ctx context.Context) error {
+		if applied.Load() {
+			return nil
+		}
+		statuses, err := m.ReadStatus()
+		if err != nil {
+			return err
+		}
+		pending := 0
+		for _, s := range statuses {
+			switch {
+			case s.Dirty:
+				return fmt.Errorf("migration %d is partially applied", s.Version)
+			case s.Modified:
+				return fmt.Errorf("migration %d changed after it was applied", s.Version)
+			case !s.Applied:
+				pending++
+			}
+		}
+		if pending > 0 {
+			return fmt.Errorf("%d migrations pending", pending)
+		}
+		applied.Store(true)
+		return nil
+	}
+}
@@ -0,0 +1,126 @@
+package health
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Check reports whether a dependency is usable. It must honour ctx.
+type Check func(ctx context.Context) error
+
+// Result is the outcome of one Check.
+type Result struct {
+	Name      string        `json:"name"`
+	Healthy   bool          `json:"healthy"`
+	Latency   time.Duration `json:"-"`
+	LatencyMS float64       `json:"latency_ms"`
+	Error     string        `json:"error,omitempty"`
+}
+
+// Report is the readiness of the process.
+type Report struct {
+	Ready    bool      `json:"ready"`
+	Draining bool      `json:"draining"`
+	Checks   []Result  `json:"checks"`
+	At       time.Time `json:"checked_at"`
+}
+
+// Redacted returns the report without the errors of its checks, which may
+// name hosts or credentials, for unauthenticated probes.
+func (r Report) Redacted() Report {
+	checks := make([]Result, len(r.Checks))
+	for i, result := range r.Checks {
+		result.Error = ""
+		checks[i] = result
+	}
+	r.Checks = checks
+	return r
+}
+
+type namedCheck struct {
+	name  string
+	check Check
+}
+
+// Checker runs the dependency checks that decide readiness. A draining
+// process is never ready, so load balancers stop routing to it before it
+// shuts down.
+type Checker struct {
+	timeout  time.Duration
+	draining atomic.Bool
+
+	mu     sync.RWMutex
+	checks []namedCheck
+}
+
+// NewChecker creates a Checker giving each check timeout to complete.
+func NewChecker(timeout time.Duration) *Checker {
+	return &Checker{timeout: timeout}
+}
+
+// Add registers a check run on every readiness probe.
+func (c *Checker) Add(name string, check Check) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.checks = append(c.checks, namedCheck{name: name, check: check})
+}
+
+// SetDraining marks the process as shutting down.
+func (c *Checker) SetDraining(draining bool) {
+	c.draining.Store(draining)
+}
+
+// Draining reports whether the process is shutting down.
+func (c *Checker) Draining() bool {
+	return c.draining.Load()
+}
+
+// Run runs every check concurrently, each bounded by the checker's timeout.
+func (c *Checker) Run(ctx context.Context) Report {
+	c.mu.RLock()
+	checks := c.checks
+	c.mu.RUnlock()
+
+	results := make([]Result, len(checks))
+	var wg sync.WaitGroup
+	for i, nc := range checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i] = c.run(ctx, nc)
+		}()
+	}
+	wg.Wait()
+
+	report := Report{Draining: c.Draining(), Checks: results, At: time.Now()}
+	report.Ready = !report.Draining
+	for _, r := range results {
+		report.Ready = report.Ready && r.Healthy
+	}
+	return report
+}
+
+func (c *Checker) run(ctx context.Context, nc namedCheck) Result {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	start := time.Now()
+	errc := make(chan error, 1)
+	go func() { errc <- nc.check(ctx) }()
+	var err error
+	select {
+	case err = <-errc:
+	case <-ctx.Done():
+		// Checks that ignore ctx are abandoned rather than blocking the probe.
+		err = ctx.Err()
+	}
+	latency := time.Since(start)
+
+	r := Result{Name: nc.name, Healthy: err == nil, Latency: latency, LatencyMS: float64(latency.Microseconds()) / 1000}
+	if err != nil {
+		r.Error = err.Error()
+	}
+	return r
+}
@@ -0,0 +1,40 @@
+// Package idempotency remembers the responses of requests sent with an
+// Idempotency-Key header, so that a retried request gets the original
+// response instead of being applied twice.
+package idempotency
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// DefaultTTL is how long the response of a request is remembered.
+const DefaultTTL = 24 * time.Hour
+
+// lockTTL bounds how long a key stays reserved by a request that never
+// completes, e.g. because its node crashed.
+const lockTTL = time.Minute
+
+// ErrInProgress is returned by Begin while another request holds the key.
+var ErrInProgress = errors.New("a request with this idempotency key is in progress")
+
+// Record is the response of a completed request.
+type Record struct {
+	Fingerprint string // identifies the request the key was first used with
+	Status      int
+	ContentType string
+	Body        []byte
+}
+
+// Store reserves keys for the requests using them and keeps their responses.
+type Store interface {
+	// Begin reserves key for a request. If a request already completed with
+	// key its record is returned; if one is still running ErrInProgress is.
+	Begin(ctx context.Context, key, fingerprint string) (*Record, error)
+	// Complete records the response of the request holding key for ttl.
+	Complete(ctx context.Context, key string, rec Record, ttl time.Duration) error
+	// Release frees key without recording a response, so that the request
+	// can be retried.
+	Release(ctx context.Context, key string) error
+}
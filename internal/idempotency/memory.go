@@ -0,0 +1,71 @@
+package idempotency
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+type entry struct {
+	record    *Record // nil while the request is in progress
+	expiresAt time.Time
+}
+
+// MemoryStore keeps keys in process memory. Keys are per node.
+type MemoryStore struct {
+	entries   map[string]entry
+	lastPrune time.Time
+	mu        sync.Mutex
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{entries: make(map[string]entry), lastPrune: time.Now()}
+}
+
+// Begin implements Store.
+func (s *MemoryStore) Begin(_ context.Context, key, fingerprint string) (*Record, error) {
+	now := time.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.prune(now)
+	if e, ok := s.entries[key]; ok && now.Before(e.expiresAt) {
+		if e.record == nil {
+			return nil, ErrInProgress
+		}
+		rec := *e.record
+		return &rec, nil
+	}
+	s.entries[key] = entry{expiresAt: now.Add(lockTTL)}
+	return nil, nil
+}
+
+// Complete implements Store.
+func (s *MemoryStore) Complete(_ context.Context, key string, rec Record, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.entries[key] = entry{record: &rec, expiresAt: time.Now().Add(ttl)}
+	return nil
+}
+
+// Release implements Store.
+func (s *MemoryStore) Release(_ context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if e, ok := s.entries[key]; ok && e.record == nil {
+		delete(s.entries, key)
+	}
+	return nil
+}
+
+// prune drops expired entries at most once per lock TTL.
+func (s *MemoryStore) prune(now time.Time) {
+	if now.Sub(s.lastPrune) < lockTTL {
+		return
+	}
+	s.lastPrune = now
+	for key, e := range s.entries {
+		if !now.Before(e.expiresAt) {
+			delete(s.entries, key)
+		}
+	}
+}
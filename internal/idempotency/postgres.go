@@ -0,0 +1,101 @@
+package idempotency
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// beginQuery reserves a key unless a live row holds it. Expired rows are
+// taken over by the same statement.
+const beginQuery = `
+INSERT INTO idempotency_keys AS k (key, fingerprint, expires_at)
+VALUES ($1, $2, $3)
+ON CONFLICT (key) DO UPDATE SET
+	fingerprint = EXCLUDED.fingerprint,
+	status = NULL,
+	content_type = NULL,
+	body = NULL,
+	expires_at = EXCLUDED.expires_at
+WHERE k.expires_at <= now()
+RETURNING key`
+
+// PostgresStore keeps keys in PostgreSQL so that a retry may reach any node.
+type PostgresStore struct {
+	DB *sql.DB
+}
+
+// NewPostgresStore creates the keys table if needed and returns the store.
+func NewPostgresStore(db *sql.DB) (*PostgresStore, error) {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS idempotency_keys (
+		key          text PRIMARY KEY,
+		fingerprint  text NOT NULL,
+		status       integer,
+		content_type text,
+		body         bytea,
+		expires_at   timestamptz NOT NULL
+	)`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create idempotency_keys table: %w", err)
+	}
+	return &PostgresStore{DB: db}, nil
+}
+
+// Begin implements Store. Expired keys are pruned on the way.
+func (s *PostgresStore) Begin(ctx context.Context, key, fingerprint string) (*Record, error) {
+	if _, err := s.DB.ExecContext(ctx, `DELETE FROM idempotency_keys WHERE expires_at < now() - interval '1 hour'`); err != nil {
+		return nil, fmt.Errorf("failed to prune idempotency keys: %w", err)
+	}
+	var reserved string
+	err := s.DB.QueryRowContext(ctx, beginQuery, key, fingerprint, time.Now().Add(lockTTL)).Scan(&reserved)
+	if err == nil {
+		return nil, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("failed to reserve idempotency key: %w", err)
+	}
+
+	var (
+		rec         Record
+		status      sql.NullInt64
+		contentType sql.NullString
+	)
+	err = s.DB.QueryRowContext(ctx,
+		`SELECT fingerprint, status, content_type, body FROM idempotency_keys WHERE key = $1`,
+		key).Scan(&rec.Fingerprint, &status, &contentType, &rec.Body)
+	if errors.Is(err, sql.ErrNoRows) {
+		// Released since the insert; let the client retry.
+		return nil, ErrInProgress
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read idempotency key: %w", err)
+	}
+	if !status.Valid {
+		return nil, ErrInProgress
+	}
+	rec.Status = int(status.Int64)
+	rec.ContentType = contentType.String
+	return &rec, nil
+}
+
+// Complete implements Store.
+func (s *PostgresStore) Complete(ctx context.Context, key string, rec Record, ttl time.Duration) error {
+	_, err := s.DB.ExecContext(ctx,
+		`UPDATE idempotency_keys SET status = $2, content_type = $3, body = $4, expires_at = $5 WHERE key = $1`,
+		key, rec.Status, rec.ContentType, rec.Body, time.Now().Add(ttl))
+	if err != nil {
+		return fmt.Errorf("failed to record idempotent response: %w", err)
+	}
+	return nil
+}
+
+// Release implements Store.
+func (s *PostgresStore) Release(ctx context.Context, key string) error {
+	_, err := s.DB.ExecContext(ctx, `DELETE FROM idempotency_keys WHERE key = $1 AND status IS NULL`, key)
+	if err != nil {
+		return fmt.Errorf("failed to release idempotency key: %w", err)
+	}
+	return nil
+}
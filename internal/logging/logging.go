@@ -0,0 +1,71 @@
+package logging
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+type contextKey struct{}
+
+// level is shared by the loggers built by New so that SetLevel can change it
+// at runtime.
+var level slog.LevelVar
+
+// New builds a leveled logger writing to stdout.
+// format is "json" or "text"; lvl is one of debug, info, warn or error.
+func New(lvl, format string) *slog.Logger {
+	return NewWithWriter(os.Stdout, lvl, format)
+}
+
+// NewWithWriter is like New but writes to w.
+func NewWithWriter(w io.Writer, lvl, format string) *slog.Logger {
+	level.Set(ParseLevel(lvl))
+	opts := &slog.HandlerOptions{Level: &level}
+	if strings.EqualFold(format, "json") {
+		return slog.New(slog.NewJSONHandler(w, opts))
+	}
+	return slog.New(slog.NewTextHandler(w, opts))
+}
+
+// SetLevel changes the level of every logger built by New.
+func SetLevel(lvl string) {
+	level.Set(ParseLevel(lvl))
+}
+
+// ParseLevel converts a level name into a slog.Level, defaulting to info.
+func ParseLevel(level string) slog.Level {
+	var l slog.Level
+	if err := l.UnmarshalText([]byte(level)); err != nil {
+		return slog.LevelInfo
+	}
+	return l
+}
+
+// WithContext returns a copy of ctx carrying logger.
+func WithContext(ctx context.Context, logger *slog.Logger) context.Context {
+	return context.WithValue(ctx, contextKey{}, logger)
+}
+
+// FromContext returns the logger stored in ctx, or the default logger.
+func FromContext(ctx context.Context) *slog.Logger {
+	if ctx != nil {
+		if logger, ok := ctx.Value(contextKey{}).(*slog.Logger); ok {
+			return logger
+		}
+	}
+	return slog.Default()
+}
+
+// NewID returns a random identifier used to correlate requests and sessions.
+func NewID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "unknown"
+	}
+	return hex.EncodeToString(b)
+}
@@ -1,7 +1,7 @@
 package manager
 
 import (
-	"log"
+	"log/slog"
 	"sync"
 
 	"github.com/gorilla/websocket"
@@ -46,7 +46,7 @@ func (m *OnlineManager) BroadcastStatus() {
 		status := <-m.broadcast
 		for _, conn := range m.clients {
 			if err := conn.WriteJSON(status); err != nil {
-				log.Println("Error broadcasting status:", err)
+				slog.Warn("failed to broadcast online status", "user_id", status.UserID, "error", err)
 			}
 		}
 	}
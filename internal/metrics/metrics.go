@@ -0,0 +1,216 @@
+package metrics
+
+import (
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// factory registers every collector with a constant "node" label so that
+// series from several servers can be told apart after aggregation.
+var factory = promauto.With(prometheus.WrapRegistererWith(
+	prometheus.Labels{"node": nodeName()},
+	prometheus.DefaultRegisterer,
+))
+
+var (
+	// ConnectedClients is the number of distinct users registered with the Hub.
+	ConnectedClients = factory.NewGauge(prometheus.GaugeOpts{
+		Namespace: "servit",
+		Name:      "connected_clients",
+		Help:      "Number of distinct users currently connected to the hub.",
+	})
+
+	// Sessions is the number of open WebSocket connections.
+	Sessions = factory.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "servit",
+		Name:      "websocket_sessions",
+		Help:      "Number of open WebSocket sessions.",
+	}, []string{"endpoint"})
+
+	// MessagesIn counts frames received from clients by WSMessage.Type.
+	MessagesIn = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "servit",
+		Name:      "messages_in_total",
+		Help:      "WebSocket frames received from clients by type.",
+	}, []string{"type"})
+
+	// MessagesOut counts frames queued for clients by WSMessage.Type.
+	MessagesOut = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "servit",
+		Name:      "messages_out_total",
+		Help:      "WebSocket frames queued for delivery to clients by type.",
+	}, []string{"type"})
+
+	// MessagesDropped counts frames discarded because a client's Send buffer was full.
+	MessagesDropped = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "servit",
+		Name:      "messages_dropped_total",
+		Help:      "WebSocket frames dropped because the client send buffer was full.",
+	}, []string{"type"})
+
+	// FrameDuration tracks inbound frame handling latency by type and outcome.
+	FrameDuration = factory.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "servit",
+		Name:      "frame_handling_duration_seconds",
+		Help:      "Inbound WebSocket frame handling latency by type and outcome.",
+		Buckets:   []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1},
+	}, []string{"type", "outcome"})
+
+	// OversizedFrames counts frames rejected for exceeding their size limit.
+	OversizedFrames = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "servit",
+		Name:      "oversized_frames_total",
+		Help:      "WebSocket frames rejected for exceeding their size limit by direction and type.",
+	}, []string{"direction", "type"})
+
+	// QueryDuration tracks ScyllaDB query latency by operation.
+	QueryDuration = factory.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "servit",
+		Name:      "scylla_query_duration_seconds",
+		Help:      "ScyllaDB query latency by operation.",
+		Buckets:   []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5},
+	}, []string{"query", "status"})
+
+	// HTTPRequests counts HTTP requests by route, method and status code.
+	HTTPRequests = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "servit",
+		Name:      "http_requests_total",
+		Help:      "HTTP requests by route, method and status code.",
+	}, []string{"route", "method", "status"})
+
+	// HTTPDuration tracks HTTP request latency by route and method.
+	HTTPDuration = factory.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "servit",
+		Name:      "http_request_duration_seconds",
+		Help:      "HTTP request latency by route and method.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"route", "method"})
+
+	// RateLimited counts requests and frames rejected by the rate limiter.
+	RateLimited = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "servit",
+		Name:      "rate_limited_total",
+		Help:      "Requests and frames rejected by the rate limiter by scope and policy.",
+	}, []string{"scope", "policy"})
+
+	// RateLimitDisconnects counts sessions closed for repeatedly exceeding rate limits.
+	RateLimitDisconnects = factory.NewCounter(prometheus.CounterOpts{
+		Namespace: "servit",
+		Name:      "rate_limit_disconnects_total",
+		Help:      "WebSocket sessions closed for repeatedly exceeding rate limits.",
+	})
+
+	// OriginRejections counts requests refused by the origin policy.
+	OriginRejections = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "servit",
+		Name:      "origin_rejections_total",
+		Help:      "Requests rejected by the origin allowlist, by source (cors, websocket or cookie).",
+	}, []string{"source"})
+
+	// RevokedSessions counts WebSocket sessions closed because their token was revoked.
+	RevokedSessions = factory.NewCounter(prometheus.CounterOpts{
+		Namespace: "servit",
+		Name:      "revoked_sessions_total",
+		Help:      "WebSocket sessions closed after their token was revoked.",
+	})
+
+	// UpgradeFailures counts failed WebSocket upgrades by endpoint.
+	UpgradeFailures = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "servit",
+		Name:      "websocket_upgrade_failures_total",
+		Help:      "Failed WebSocket upgrade attempts by endpoint.",
+	}, []string{"endpoint"})
+
+	// SendQueueDepth samples the depth of client send queues as frames are queued.
+	SendQueueDepth = factory.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "servit",
+		Name:      "send_queue_depth",
+		Help:      "Frames waiting in a client send queue, by queue (reliable or ephemeral), sampled on enqueue.",
+		Buckets:   []float64{0, 1, 4, 16, 64, 256, 1024},
+	}, []string{"queue"})
+
+	// FramesShed counts ephemeral frames discarded to make room for newer ones.
+	FramesShed = factory.NewCounter(prometheus.CounterOpts{
+		Namespace: "servit",
+		Name:      "frames_shed_total",
+		Help:      "Ephemeral frames (typing indicators) discarded from full client queues.",
+	})
+
+	// SlowConsumerDisconnects counts sessions closed because their send queue was full.
+	SlowConsumerDisconnects = factory.NewCounter(prometheus.CounterOpts{
+		Namespace: "servit",
+		Name:      "slow_consumer_disconnects_total",
+		Help:      "WebSocket sessions closed because they could not keep up with their frames.",
+	})
+
+	// SessionResumes counts resume frames by result.
+	SessionResumes = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "servit",
+		Name:      "session_resumes_total",
+		Help:      "Resume frames by result (resumed, gap or unknown_session).",
+	}, []string{"result"})
+	// FramesReplayed counts frames replayed to resumed sessions.
+	FramesReplayed = factory.NewCounter(prometheus.CounterOpts{
+		Namespace: "servit",
+		Name:      "frames_replayed_total",
+		Help:      "Frames replayed to clients that resumed a previous session.",
+	})
+	// WebhookDeliveries counts webhook delivery attempts and dropped events.
+	WebhookDeliveries = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "servit",
+		Name:      "webhook_deliveries_total",
+		Help:      "Webhook delivery attempts by result (delivered, retried or dead), and events dropped (dropped).",
+	}, []string{"result"})
+	// ConfigReloads counts configuration reloads by result.
+	ConfigReloads = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "servit",
+		Name:      "config_reloads_total",
+		Help:      "Configuration reloads by result (applied, unchanged or rejected).",
+	}, []string{"result"})
+)
+
+// Handler exposes the registered metrics in the Prometheus text format.
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
+
+// ObserveQuery records the latency and outcome of a ScyllaDB query started at start.
+func ObserveQuery(query string, start time.Time, err error) {
+	status := "ok"
+	if err != nil {
+		status = "error"
+	}
+	QueryDuration.WithLabelValues(query, status).Observe(time.Since(start).Seconds())
+}
+
+// GinMiddleware records request counts and latency per registered route.
+// Requests that matched no route are grouped under "unmatched" to keep
+// label cardinality bounded.
+func GinMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		method := c.Request.Method
+		HTTPRequests.WithLabelValues(route, method, strconv.Itoa(c.Writer.Status())).Inc()
+		HTTPDuration.WithLabelValues(route, method).Observe(time.Since(start).Seconds())
+	}
+}
+
+func nodeName() string {
+	if name, err := os.Hostname(); err == nil && name != "" {
+		return name
+	}
+	return "unknown"
+}
@@ -0,0 +1,27 @@
+package middleware
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"servit-go/internal/logging"
+
+	"github.com/gin-gonic/gin"
+)
+
+// AdminAuthMiddleware guards internal endpoints with a shared admin token
+// sent as "Authorization: Bearer <token>".
+func AdminAuthMiddleware(adminToken string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		scheme, token, _ := strings.Cut(c.GetHeader("Authorization"), " ")
+		if adminToken == "" || !strings.EqualFold(scheme, "Bearer") ||
+			subtle.ConstantTimeCompare([]byte(token), []byte(adminToken)) != 1 {
+			logging.FromContext(c.Request.Context()).Warn("admin request rejected", "path", c.Request.URL.Path)
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid admin token"})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
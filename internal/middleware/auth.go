@@ -2,12 +2,16 @@ package middleware
 
 import (
 	"context"
-	"fmt"
+	"errors"
+	"mime"
 	"net/http"
-	"os"
+	"strings"
+
+	"servit-go/internal/auth"
+	"servit-go/internal/logging"
+	"servit-go/internal/metrics"
 
 	"github.com/gin-gonic/gin"
-	"github.com/golang-jwt/jwt/v5"
 )
 
 // Define a context key for storing the user ID
@@ -15,44 +19,119 @@ type contextKey string
 
 var UserNameKey = contextKey("user_name")
 var UserIDKey = contextKey("user_id")
+var IdentityKey = contextKey("identity") // the whole auth.Identity
 
-func JWTAuthMiddleware() gin.HandlerFunc {
-	return func(c *gin.Context) {
-		// Get the token from the query parameter instead of the Authorization header
-		tokenString := c.Query("token")
-		if tokenString == "" {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Token query parameter missing"})
-			c.Abort()
-			return
-		}
+// TicketParam is the query parameter carrying a connect ticket.
+const TicketParam = "ticket"
 
-		secretKey := os.Getenv("SECRET_KEY")
-		token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-				return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-			}
-			return []byte(secretKey), nil
-		})
+// AuthOptions configures where JWTAuthMiddleware looks for credentials.
+type AuthOptions struct {
+	Authenticator *auth.Authenticator
+	CookieName    string // cookie holding the token, empty to disable cookie auth
+	// Tickets, when set, accepts a single-use connect ticket in the "ticket"
+	// query parameter. Only WebSocket upgrades should enable it.
+	Tickets auth.TicketStore
+	// Origins, when set, guards cookie authentication against cross-site
+	// request forgery: requests other than GET, HEAD and OPTIONS
+	// authenticated with the cookie must come from an allowed Origin with
+	// an application/json Content-Type.
+	Origins *OriginPolicy
+}
+
+// errCrossSite rejects cookie-authenticated requests failing the Origins
+// check of AuthOptions.
+var errCrossSite = errors.New("Cookie-authenticated requests must be JSON from an allowed origin")
 
+// JWTAuthMiddleware authenticates the request with, in order, an
+// "Authorization: Bearer" header, the auth cookie or a connect ticket, and
+// stores the user in the request context.
+func JWTAuthMiddleware(opts AuthOptions) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, err := authenticate(c, opts)
 		if err != nil {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
+			status := http.StatusUnauthorized
+			if errors.Is(err, errCrossSite) {
+				status = http.StatusForbidden
+			}
+			c.JSON(status, gin.H{"error": err.Error()})
 			c.Abort()
 			return
 		}
 
-		if claims, ok := token.Claims.(jwt.MapClaims); ok && token.Valid {
-			// Store the user ID in the request context without modifying the key
-			userName := claims["sub"].(string)
-			userId := claims["id"].(string)
-			ctx := c.Request.Context()
-			ctx = context.WithValue(ctx, UserNameKey, userName)
-			ctx = context.WithValue(ctx, UserIDKey, userId)
-			c.Request = c.Request.WithContext(ctx)
-
-			c.Next()
-		} else {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
-			c.Abort()
+		ctx := c.Request.Context()
+		ctx = context.WithValue(ctx, UserNameKey, id.UserName)
+		ctx = context.WithValue(ctx, UserIDKey, id.UserID)
+		ctx = context.WithValue(ctx, IdentityKey, id)
+		ctx = logging.WithContext(ctx, logging.FromContext(ctx).With("user_id", id.UserID))
+		c.Request = c.Request.WithContext(ctx)
+
+		c.Next()
+	}
+}
+
+func authenticate(c *gin.Context, opts AuthOptions) (auth.Identity, error) {
+	if header := c.GetHeader("Authorization"); header != "" {
+		scheme, token, ok := strings.Cut(header, " ")
+		if !ok || !strings.EqualFold(scheme, "Bearer") || token == "" {
+			return auth.Identity{}, errors.New("Malformed Authorization header")
 		}
+		return verifyToken(c, opts.Authenticator, token)
 	}
+	if opts.CookieName != "" {
+		if token, err := c.Cookie(opts.CookieName); err == nil && token != "" {
+			if opts.Origins != nil && !sameSiteRequest(c.Request, opts.Origins) {
+				metrics.OriginRejections.WithLabelValues("cookie").Inc()
+				logging.FromContext(c.Request.Context()).Warn("cookie-authenticated request rejected",
+					"origin", c.GetHeader("Origin"), "content_type", c.ContentType(), "path", c.Request.URL.Path)
+				return auth.Identity{}, errCrossSite
+			}
+			return verifyToken(c, opts.Authenticator, token)
+		}
+	}
+	if opts.Tickets != nil {
+		if ticket := c.Query(TicketParam); ticket != "" {
+			id, err := opts.Tickets.Redeem(c.Request.Context(), ticket)
+			if err != nil {
+				if !errors.Is(err, auth.ErrInvalidTicket) {
+					logging.FromContext(c.Request.Context()).Error("failed to redeem ticket", "error", err)
+				}
+				return auth.Identity{}, errors.New("Invalid ticket")
+			}
+			if err := opts.Authenticator.CheckRevoked(c.Request.Context(), id); err != nil {
+				logging.FromContext(c.Request.Context()).Info("ticket rejected", "error", err)
+				return auth.Identity{}, errors.New("Invalid ticket")
+			}
+			return id, nil
+		}
+	}
+	return auth.Identity{}, errors.New("Missing credentials")
+}
+
+// sameSiteRequest reports whether a cookie-authenticated request is safe from
+// cross-site request forgery. Browsers send cookies along with forms posted
+// from any site, but those can't set a JSON Content-Type without a CORS
+// preflight, and always carry the Origin of the page.
+func sameSiteRequest(r *http.Request, origins *OriginPolicy) bool {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	}
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return mediaType == "application/json" && origins.Allowed(r.Header.Get("Origin"))
+}
+
+func verifyToken(c *gin.Context, authenticator *auth.Authenticator, token string) (auth.Identity, error) {
+	id, err := authenticator.Authenticate(c.Request.Context(), token)
+	if err != nil {
+		logging.FromContext(c.Request.Context()).Info("token rejected", "error", err)
+		return auth.Identity{}, errors.New("Invalid token")
+	}
+	return id, nil
+}
+
+// CurrentIdentity returns the user authenticated by JWTAuthMiddleware,
+// including the claims revocations are checked against.
+func CurrentIdentity(ctx context.Context) (auth.Identity, bool) {
+	id, ok := ctx.Value(IdentityKey).(auth.Identity)
+	return id, ok && id.UserID != ""
 }
@@ -0,0 +1,68 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"servit-go/internal/auth"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func TestCookieAuthRequiresSameSiteRequests(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	secret := []byte("0123456789abcdef0123456789abcdef")
+	authenticator, err := auth.NewAuthenticator(auth.JWTOptions{SecretKey: secret})
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"id":  "user-1",
+		"sub": "alice",
+		"exp": time.Now().Add(time.Hour).Unix(),
+	}).SignedString(secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	origins, err := NewOriginPolicy([]string{"https://app.example.com"}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	router := gin.New()
+	router.Use(JWTAuthMiddleware(AuthOptions{Authenticator: authenticator, CookieName: "servit_token", Origins: origins}))
+	router.Any("/", func(c *gin.Context) { c.Status(http.StatusNoContent) })
+
+	for _, tc := range []struct {
+		name, method, origin, contentType string
+		bearer                            bool
+		want                              int
+	}{
+		{"GET", http.MethodGet, "", "", false, http.StatusNoContent},
+		{"JSON from an allowed origin", http.MethodPost, "https://app.example.com", "application/json; charset=utf-8", false, http.StatusNoContent},
+		{"form from an allowed origin", http.MethodPost, "https://app.example.com", "application/x-www-form-urlencoded", false, http.StatusForbidden},
+		{"JSON from another origin", http.MethodPost, "https://evil.example", "application/json", false, http.StatusForbidden},
+		{"JSON without an origin", http.MethodDelete, "", "application/json", false, http.StatusForbidden},
+		{"bearer token", http.MethodPost, "https://evil.example", "text/plain", true, http.StatusNoContent},
+	} {
+		req := httptest.NewRequest(tc.method, "/", nil)
+		if tc.bearer {
+			req.Header.Set("Authorization", "Bearer "+token)
+		} else {
+			req.AddCookie(&http.Cookie{Name: "servit_token", Value: token})
+		}
+		if tc.origin != "" {
+			req.Header.Set("Origin", tc.origin)
+		}
+		if tc.contentType != "" {
+			req.Header.Set("Content-Type", tc.contentType)
+		}
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		if rec.Code != tc.want {
+			t.Errorf("%s: got %d, want %d", tc.name, rec.Code, tc.want)
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	"servit-go/internal/idempotency"
+	"servit-go/internal/logging"
+
+	"github.com/gin-gonic/gin"
+)
+
+// IdempotencyHeader carries the client chosen key of a retryable request.
+const IdempotencyHeader = "Idempotency-Key"
+
+// maxIdempotencyKey bounds the length of idempotency keys.
+const maxIdempotencyKey = 255
+
+// maxIdempotentBody bounds the bodies buffered to fingerprint requests.
+const maxIdempotentBody = 1 << 20
+
+// IdempotencyMiddleware answers a request carrying an Idempotency-Key that
+// was already used by the same user with the response of the first request,
+// marked with an "Idempotent-Replayed: true" header. Reusing a key for a
+// different request is rejected with 422, and a key whose request is still
+// running with 409. Responses with a 5xx status aren't recorded, so those
+// requests may be retried. It must run after JWTAuthMiddleware.
+func IdempotencyMiddleware(store idempotency.Store, ttl time.Duration) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := c.GetHeader(IdempotencyHeader)
+		if key == "" {
+			c.Next()
+			return
+		}
+		if len(key) > maxIdempotencyKey {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": IdempotencyHeader + " is too long"})
+			return
+		}
+		body, err := io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, maxIdempotentBody))
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			c.AbortWithStatusJSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Request body is too large"})
+			return
+		}
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "Failed to read the request body"})
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+		ctx := c.Request.Context()
+		logger := logging.FromContext(ctx)
+		userID, _ := ctx.Value(UserIDKey).(string)
+		key = userID + ":" + key
+		fingerprint := requestFingerprint(c.Request.Method, c.Request.URL.Path, body)
+
+		rec, err := store.Begin(ctx, key, fingerprint)
+		switch {
+		case errors.Is(err, idempotency.ErrInProgress):
+			c.AbortWithStatusJSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		case err != nil:
+			logger.Error("idempotency check failed", "error", err)
+			c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{"error": "Failed to check the idempotency key"})
+			return
+		case rec != nil && rec.Fingerprint != fingerprint:
+			c.AbortWithStatusJSON(http.StatusUnprocessableEntity,
+				gin.H{"error": IdempotencyHeader + " was already used for a different request"})
+			return
+		case rec != nil:
+			c.Header("Idempotent-Replayed", "true")
+			c.Data(rec.Status, rec.ContentType, rec.Body)
+			c.Abort()
+			return
+		}
+
+		recorder := &responseRecorder{ResponseWriter: c.Writer}
+		c.Writer = recorder
+		c.Next()
+
+		// The outcome is recorded even if the client went away, since that
+		// is when it retries.
+		ctx = context.WithoutCancel(ctx)
+
+		status := recorder.Status()
+		if status >= http.StatusInternalServerError {
+			if err := store.Release(ctx, key); err != nil {
+				logger.Error("failed to release idempotency key", "error", err)
+			}
+			return
+		}
+		rec = &idempotency.Record{
+			Fingerprint: fingerprint,
+			Status:      status,
+			ContentType: recorder.Header().Get("Content-Type"),
+			Body:        recorder.body.Bytes(),
+		}
+		if err := store.Complete(ctx, key, *rec, ttl); err != nil {
+			logger.Error("failed to record idempotent response", "error", err)
+		}
+	}
+}
+
+// requestFingerprint identifies a request by its method, path and body.
+func requestFingerprint(method, path string, body []byte) string {
+	h := sha256.New()
+	io.WriteString(h, method+" "+path+"\n")
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// responseRecorder copies the response body while writing it.
+type responseRecorder struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *responseRecorder) Write(b []byte) (int, error) {
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *responseRecorder) WriteString(s string) (int, error) {
+	w.body.WriteString(s)
+	return w.ResponseWriter.WriteString(s)
+}
@@ -0,0 +1,64 @@
+package middleware
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"servit-go/internal/logging"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RequestIDHeader carries the correlation ID of a request.
+const RequestIDHeader = "X-Request-ID"
+
+var RequestIDKey = contextKey("request_id")
+
+// RequestContext assigns a correlation ID to every request, echoes it in the
+// response and stores a request scoped logger in the request context.
+// An incoming X-Request-ID header is reused so IDs survive across services.
+func RequestContext(logger *slog.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		requestID := c.GetHeader(RequestIDHeader)
+		if requestID == "" || len(requestID) > 64 {
+			requestID = logging.NewID()
+		}
+		c.Header(RequestIDHeader, requestID)
+
+		ctx := c.Request.Context()
+		ctx = logging.WithContext(ctx, logger.With("request_id", requestID))
+		ctx = context.WithValue(ctx, RequestIDKey, requestID)
+		c.Request = c.Request.WithContext(ctx)
+
+		c.Next()
+	}
+}
+
+// probeRoutes are polled by orchestrators; their successes are logged at
+// debug level to keep the access log readable.
+var probeRoutes = map[string]bool{"/healthz": true, "/readyz": true}
+
+// RequestLogger writes one structured access log line per request.
+func RequestLogger() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		level := slog.LevelInfo
+		if probeRoutes[c.FullPath()] && c.Writer.Status() < 400 {
+			level = slog.LevelDebug
+		}
+		if c.Writer.Status() >= 500 {
+			level = slog.LevelError
+		}
+		logging.FromContext(c.Request.Context()).Log(c.Request.Context(), level, "http request",
+			"method", c.Request.Method,
+			"path", c.Request.URL.Path,
+			"route", c.FullPath(),
+			"status", c.Writer.Status(),
+			"duration", time.Since(start),
+			"client_ip", c.ClientIP(),
+		)
+	}
+}
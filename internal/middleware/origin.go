@@ -0,0 +1,172 @@
+package middleware
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync/atomic"
+
+	"servit-go/internal/logging"
+	"servit-go/internal/metrics"
+
+	"github.com/gin-contrib/cors"
+	"github.com/gin-gonic/gin"
+)
+
+// OriginPolicy decides which browser origins may call the API and open
+// WebSockets. Entries are exact origins such as "https://app.example.com"
+// or wildcard subdomains such as "https://*.example.com", which match any
+// subdomain but not example.com itself.
+type OriginPolicy struct {
+	rules atomic.Pointer[originRules]
+}
+
+type originRules struct {
+	exact    map[string]bool
+	wildcard []originPattern
+	// dev additionally allows loopback origins on any port.
+	dev bool
+}
+
+type originPattern struct {
+	scheme string
+	suffix string // ".example.com"
+	port   string
+}
+
+// NewOriginPolicy builds a policy from allowlist entries. In dev mode any
+// http or https origin on localhost, 127.0.0.1 or [::1] is allowed as well.
+func NewOriginPolicy(allowed []string, dev bool) (*OriginPolicy, error) {
+	p := &OriginPolicy{}
+	if err := p.Update(allowed, dev); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Update replaces the allowlist, e.g. after a configuration reload. The
+// policy is left unchanged if an entry is invalid.
+func (p *OriginPolicy) Update(allowed []string, dev bool) error {
+	rules, err := parseOriginRules(allowed, dev)
+	if err != nil {
+		return err
+	}
+	p.rules.Store(rules)
+	return nil
+}
+
+func parseOriginRules(allowed []string, dev bool) (*originRules, error) {
+	p := &originRules{exact: make(map[string]bool), dev: dev}
+	for _, entry := range allowed {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if entry == "*" {
+			return nil, fmt.Errorf("origin %q is not allowed, enable dev mode to relax origin checks", entry)
+		}
+		u, err := url.Parse(entry)
+		if err != nil || u.Scheme == "" || u.Host == "" || (u.Path != "" && u.Path != "/") {
+			return nil, fmt.Errorf("invalid origin %q: expected scheme://host[:port]", entry)
+		}
+		host := strings.ToLower(u.Hostname())
+		if strings.HasPrefix(host, "*.") {
+			p.wildcard = append(p.wildcard, originPattern{
+				scheme: strings.ToLower(u.Scheme),
+				suffix: host[1:],
+				port:   u.Port(),
+			})
+			continue
+		}
+		if strings.Contains(host, "*") {
+			return nil, fmt.Errorf("invalid origin %q: wildcards are only allowed as the leftmost label", entry)
+		}
+		p.exact[normalizeOrigin(u)] = true
+	}
+	return p, nil
+}
+
+// ParseOrigins splits a comma separated list of origins.
+func ParseOrigins(s string) []string {
+	var origins []string
+	for _, o := range strings.Split(s, ",") {
+		if o = strings.TrimSpace(o); o != "" {
+			origins = append(origins, o)
+		}
+	}
+	return origins
+}
+
+// Allowed reports whether origin matches the policy.
+func (p *OriginPolicy) Allowed(origin string) bool {
+	u, err := url.Parse(origin)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return false
+	}
+	rules := p.rules.Load()
+	if rules.exact[normalizeOrigin(u)] {
+		return true
+	}
+	scheme, host, port := strings.ToLower(u.Scheme), strings.ToLower(u.Hostname()), u.Port()
+	for _, w := range rules.wildcard {
+		if scheme == w.scheme && port == w.port && strings.HasSuffix(host, w.suffix) {
+			return true
+		}
+	}
+	if rules.dev && (scheme == "http" || scheme == "https") {
+		if host == "localhost" {
+			return true
+		}
+		if ip := net.ParseIP(host); ip != nil && ip.IsLoopback() {
+			return true
+		}
+	}
+	return false
+}
+
+// CheckWebSocketOrigin is a websocket.Upgrader CheckOrigin function.
+// Requests without an Origin header come from non-browser clients, which
+// aren't exposed to cross-site WebSocket hijacking, and are accepted.
+func (p *OriginPolicy) CheckWebSocketOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" || p.Allowed(origin) {
+		return true
+	}
+	metrics.OriginRejections.WithLabelValues("websocket").Inc()
+	logging.FromContext(r.Context()).Warn("websocket origin rejected", "origin", origin, "path", r.URL.Path)
+	return false
+}
+
+// CORS returns the CORS middleware enforcing the policy.
+func (p *OriginPolicy) CORS() gin.HandlerFunc {
+	config := cors.DefaultConfig()
+	config.AllowOriginWithContextFunc = func(c *gin.Context, origin string) bool {
+		if p.Allowed(origin) {
+			return true
+		}
+		metrics.OriginRejections.WithLabelValues("cors").Inc()
+		logging.FromContext(c.Request.Context()).Warn("cors origin rejected", "origin", origin, "path", c.Request.URL.Path)
+		return false
+	}
+	config.AllowMethods = []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"}
+	config.AllowHeaders = []string{"Origin", "Content-Type", "Authorization", RequestIDHeader, "traceparent", "tracestate"}
+	config.ExposeHeaders = []string{RequestIDHeader}
+	return cors.New(config)
+}
+
+// normalizeOrigin lower-cases the scheme and host and drops default ports.
+func normalizeOrigin(u *url.URL) string {
+	scheme, host, port := strings.ToLower(u.Scheme), strings.ToLower(u.Hostname()), u.Port()
+	if (scheme == "http" && port == "80") || (scheme == "https" && port == "443") {
+		port = ""
+	}
+	if strings.Contains(host, ":") {
+		host = "[" + host + "]"
+	}
+	if port != "" {
+		host += ":" + port
+	}
+	return scheme + "://" + host
+}
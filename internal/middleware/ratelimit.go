@@ -0,0 +1,40 @@
+package middleware
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+
+	"servit-go/internal/logging"
+	"servit-go/internal/metrics"
+	"servit-go/internal/ratelimit"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RateLimitMiddleware limits requests per route and per user, falling back to
+// the client IP for unauthenticated requests. It must run after
+// JWTAuthMiddleware for the user ID to be available.
+func RateLimitMiddleware(limiter *ratelimit.Limiter) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key, ok := c.Request.Context().Value(UserIDKey).(string)
+		if !ok || key == "" {
+			key = c.ClientIP()
+		}
+		route := c.FullPath()
+
+		decision, err := limiter.Allow(c.Request.Context(), route, key)
+		if err != nil {
+			logging.FromContext(c.Request.Context()).Warn("rate limit check failed", "error", err)
+		}
+		if !decision.Allowed {
+			metrics.RateLimited.WithLabelValues("http", route).Inc()
+			retryAfter := int(math.Ceil(decision.RetryAfter.Seconds()))
+			c.Header("Retry-After", strconv.Itoa(retryAfter))
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": "Rate limit exceeded", "retry_after": retryAfter})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
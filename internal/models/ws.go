@@ -44,3 +44,11 @@ type TypingEvent struct {
 	ChatType     string `json:"chat_type,omitempty"` // "dm" or "channel"
 	ChatID       string `json:"chat_id,omitempty"`   // For channel type, the channel id
 }
+
+// ErrorEvent is sent to a client when one of its frames is rejected.
+type ErrorEvent struct {
+	Code         string `json:"code"`
+	Message      string `json:"message"`
+	Type         string `json:"type,omitempty"`           // type of the rejected frame
+	RetryAfterMs int64  `json:"retry_after_ms,omitempty"` // set when the client may retry later
+}
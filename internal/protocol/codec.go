@@ -0,0 +1,211 @@
+package protocol
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"servit-go/internal/models"
+
+	"github.com/gorilla/websocket"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// Codec converts frames to and from their wire representation.
+type Codec interface {
+	// Name identifies the codec in the negotiated subprotocol, e.g. "msgpack".
+	Name() string
+	// MessageType is the WebSocket message type frames are sent as.
+	MessageType() int
+	Encode(frame *Outbound) ([]byte, error)
+	// Raw encodes a payload so that it can be embedded unchanged as the Data
+	// of several envelopes, see Frame.Sequenced.
+	Raw(data any) (any, error)
+	// Decode parses an inbound frame. The Data of the returned message is
+	// always JSON so that frame handlers don't depend on the codec.
+	Decode(data []byte) (models.WSMessage, error)
+}
+
+var (
+	JSON    Codec = jsonCodec{}
+	Msgpack Codec = msgpackCodec{}
+)
+
+// codecs lists the available codecs by name; the first is the default.
+var codecs = []Codec{JSON, Msgpack}
+
+// CodecByName returns the codec with the given name.
+func CodecByName(name string) (Codec, bool) {
+	for _, codec := range codecs {
+		if codec.Name() == name {
+			return codec, true
+		}
+	}
+	return nil, false
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Name() string     { return "json" }
+func (jsonCodec) MessageType() int { return websocket.TextMessage }
+
+func (jsonCodec) Encode(frame *Outbound) ([]byte, error) {
+	return json.Marshal(frame)
+}
+
+func (jsonCodec) Raw(data any) (any, error) {
+	b, err := json.Marshal(data)
+	return json.RawMessage(b), err
+}
+
+func (jsonCodec) Decode(data []byte) (models.WSMessage, error) {
+	var msg models.WSMessage
+	err := json.Unmarshal(data, &msg)
+	return msg, err
+}
+
+// msgpackCodec encodes frames as MessagePack maps using the same field
+// names as the JSON protocol.
+type msgpackCodec struct{}
+
+func (msgpackCodec) Name() string     { return "msgpack" }
+func (msgpackCodec) MessageType() int { return websocket.BinaryMessage }
+
+func (msgpackCodec) Encode(frame *Outbound) ([]byte, error) {
+	return msgpackEncode(frame)
+}
+
+func (msgpackCodec) Raw(data any) (any, error) {
+	b, err := msgpackEncode(data)
+	return msgpack.RawMessage(b), err
+}
+
+func msgpackEncode(v any) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := msgpack.NewEncoder(&buf)
+	enc.SetCustomStructTag("json")
+	enc.UseCompactInts(true)
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (msgpackCodec) Decode(data []byte) (models.WSMessage, error) {
+	var envelope struct {
+		Type     string `msgpack:"type"`
+		ChatType string `msgpack:"chat_type"`
+		ChatID   string `msgpack:"chat_id"`
+		Data     any    `msgpack:"data"`
+	}
+	if err := msgpack.Unmarshal(data, &envelope); err != nil {
+		return models.WSMessage{}, err
+	}
+	payload, err := json.Marshal(envelope.Data)
+	if err != nil {
+		return models.WSMessage{}, fmt.Errorf("payload cannot be represented as JSON: %w", err)
+	}
+	return models.WSMessage{
+		Type:     envelope.Type,
+		ChatType: envelope.ChatType,
+		ChatID:   envelope.ChatID,
+		Data:     payload,
+	}, nil
+}
+
+// Frame is an outbound frame shared by every recipient of a fan-out. It is
+// encoded at most once per codec, however many clients it is queued for.
+type Frame struct {
+	Outbound
+	cache *frameCache // shared with the sequenced copies of the frame
+
+	closeCode   int // non-zero for close frames
+	closeReason string
+}
+
+type frameCache struct {
+	mu      sync.Mutex
+	encoded map[string][]byte // the unsequenced frame by codec
+	data    map[string]any    // the payload by codec, see Codec.Raw
+}
+
+// NewFrame builds an outbound frame.
+func NewFrame(frameType, chatType, chatID string, data any) *Frame {
+	return &Frame{
+		Outbound: Outbound{Type: frameType, ChatType: chatType, ChatID: chatID, Data: data},
+		cache:    &frameCache{},
+	}
+}
+
+// NewCloseFrame builds a frame asking the writer to close the connection
+// with code and reason once every frame queued before it has been written.
+func NewCloseFrame(code int, reason string) *Frame {
+	return &Frame{closeCode: code, closeReason: reason}
+}
+
+// CloseMessage returns the close code and reason of a close frame.
+func (f *Frame) CloseMessage() (code int, reason string, ok bool) {
+	return f.closeCode, f.closeReason, f.closeCode != 0
+}
+
+// Sequenced returns a copy of f numbered seq for one session. The copy
+// shares the encoded payload of f, so only its envelope is encoded per
+// session.
+func (f *Frame) Sequenced(seq uint64) *Frame {
+	s := *f
+	s.Seq = seq
+	return &s
+}
+
+// Bytes returns the frame encoded with codec. The encoding of unsequenced
+// frames and the payload of sequenced ones are cached.
+func (f *Frame) Bytes(codec Codec) ([]byte, error) {
+	if f.cache == nil {
+		return codec.Encode(&f.Outbound)
+	}
+	if f.Seq == 0 {
+		return f.cache.frame(codec, &f.Outbound)
+	}
+	data, err := f.cache.payload(codec, f.Data)
+	if err != nil {
+		return nil, err
+	}
+	envelope := f.Outbound
+	envelope.Data = data
+	return codec.Encode(&envelope)
+}
+
+func (c *frameCache) frame(codec Codec, frame *Outbound) ([]byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if b, ok := c.encoded[codec.Name()]; ok {
+		return b, nil
+	}
+	b, err := codec.Encode(frame)
+	if err != nil {
+		return nil, err
+	}
+	if c.encoded == nil {
+		c.encoded = make(map[string][]byte, 1)
+	}
+	c.encoded[codec.Name()] = b
+	return b, nil
+}
+
+func (c *frameCache) payload(codec Codec, data any) (any, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if raw, ok := c.data[codec.Name()]; ok {
+		return raw, nil
+	}
+	raw, err := codec.Raw(data)
+	if err != nil {
+		return nil, err
+	}
+	if c.data == nil {
+		c.data = make(map[string]any, 1)
+	}
+	c.data[codec.Name()] = raw
+	return raw, nil
+}
@@ -0,0 +1,201 @@
+package protocol
+
+import (
+	"reflect"
+	"time"
+
+	"servit-go/internal/models"
+)
+
+// Frame types sent by clients.
+const (
+	TypeSwitchChat     = "switch_chat"
+	TypeChannelMessage = "channel_message"
+	TypeDirectMessage  = "direct_message"
+	TypeTyping         = "typing"
+	TypeNotTyping      = "not_typing"
+	TypeReauth         = "reauth"
+	TypeResume         = "resume"
+	TypeSubscribe      = "subscribe"
+	TypeUnsubscribe    = "unsubscribe"
+)
+
+// Frame types sent by the server. Chat, DM and typing frames are echoed to
+// recipients using the same type the sender used.
+const (
+	TypeWelcome      = "welcome"
+	TypeNotification = "notification"
+	TypeError        = "error"
+
+	TypeReauthRequired  = "reauth_required"
+	TypeReauthenticated = "reauthenticated"
+
+	TypeResumed        = "resumed"
+	TypeResyncRequired = "resync_required"
+
+	TypeSubscriptions = "subscriptions"
+)
+
+// Close codes, in the range reserved for applications.
+const (
+	CloseTokenExpired   = 4001 // the session token expired without a reauth frame
+	CloseSessionRevoked = 4003 // the session token was revoked, see ReasonSessionRevoked
+	CloseSlowConsumer   = 4008 // the client fell behind, see ReasonResync
+	CloseSessionResumed = 4009 // another connection resumed the session
+)
+
+// Close reasons.
+const (
+	ReasonSessionRevoked = "session_revoked" // sent with CloseSessionRevoked
+	// ReasonResync is sent with CloseSlowConsumer: frames were lost, so the
+	// client should reconnect and fetch the history of its open chats.
+	ReasonResync = "resync"
+	// ReasonSessionResumed is sent with CloseSessionResumed.
+	ReasonSessionResumed = "session_resumed"
+)
+
+// Reasons carried by resync_required frames.
+const (
+	ResyncUnknownSession = "unknown_session" // the session expired or belongs to another user
+	ResyncGap            = "gap"             // frames after last_seq are no longer buffered
+)
+
+// Error codes carried by error frames.
+const (
+	ErrInvalidFrame   = "invalid_frame"     // the frame is not a valid envelope
+	ErrUnknownType    = "unknown_type"      // the frame type is not supported
+	ErrInvalidPayload = "invalid_payload"   // the data does not match the frame type
+	ErrRateLimited    = "rate_limited"      // too many frames, see retry_after_ms
+	ErrForbidden      = "forbidden"         // the user may not perform this action
+	ErrUnauthorized   = "unauthorized"      // the token in a reauth frame was rejected
+	ErrTooLarge       = "payload_too_large" // the frame exceeds the size limit for its type
+	ErrInternal       = "internal_error"    // the server failed to process the frame
+)
+
+// Outbound is the envelope of every frame sent by the server. It mirrors
+// models.WSMessage with a typed payload.
+type Outbound struct {
+	Type     string `json:"type"`
+	ChatType string `json:"chat_type,omitempty"`
+	ChatID   string `json:"chat_id,omitempty"`
+	Data     any    `json:"data"`
+	// Seq numbers the frames of a session from 1, see Resume. Ephemeral
+	// frames are not numbered, see Ephemeral.
+	Seq uint64 `json:"seq,omitempty"`
+}
+
+// Welcome is the first frame of every session.
+type Welcome struct {
+	Version   int    `json:"version"`
+	SessionID string `json:"session_id"`
+}
+
+// Notification tells a client about activity in a chat it isn't viewing.
+type Notification struct {
+	Unread  int    `json:"unread"`
+	Message string `json:"message"`
+}
+
+// Reauth carries a fresh token for the current session.
+type Reauth struct {
+	Token string `json:"token"`
+}
+
+// ReauthRequired asks the client to send a reauth frame before the session
+// token expires, after which the connection is closed with CloseTokenExpired.
+type ReauthRequired struct {
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// Reauthenticated confirms a reauth frame and carries the new expiry.
+type Reauthenticated struct {
+	ExpiresAt time.Time `json:"expires_at,omitempty"`
+}
+
+// Resume asks the server to replay the frames a previous session of the
+// same user missed after LastSeq.
+type Resume struct {
+	SessionID string `json:"session_id"`
+	LastSeq   uint64 `json:"last_seq"`
+}
+
+// Resumed confirms a resume frame once the missed frames have been queued.
+type Resumed struct {
+	SessionID string `json:"session_id"`
+	Replayed  int    `json:"replayed"`
+}
+
+// ResyncRequired rejects a resume frame: the client should fetch the history
+// of its open chats instead.
+type ResyncRequired struct {
+	SessionID string `json:"session_id"`
+	Reason    string `json:"reason"`
+}
+
+// ChatRef identifies a channel, or a DM by the ID of the other user.
+type ChatRef struct {
+	ChatType string `json:"chat_type"` // "channel" or "dm"
+	ChatID   string `json:"chat_id"`
+}
+
+// Subscriptions lists the chats of subscribe and unsubscribe frames, and
+// every chat a session is subscribed to in subscriptions frames.
+type Subscriptions struct {
+	Chats []ChatRef `json:"chats"`
+}
+
+// NewError builds the payload of an error frame.
+func NewError(code, message, frameType string) models.ErrorEvent {
+	return models.ErrorEvent{Code: code, Message: message, Type: frameType}
+}
+
+// ephemeralFrames are the outbound frames superseded by the next frame of
+// their kind. Notifications are not among them: they carry unread counts and
+// are the only frame sent for a direct message the receiver isn't viewing.
+var ephemeralFrames = map[string]bool{
+	TypeTyping:    true,
+	TypeNotTyping: true,
+}
+
+// Ephemeral reports whether outbound frames of frameType are ephemeral: they
+// may be dropped when the client reads slowly, carry no seq and are not
+// replayed on resume.
+func Ephemeral(frameType string) bool {
+	return ephemeralFrames[frameType]
+}
+
+// FrameSpec documents one frame type of the protocol.
+type FrameSpec struct {
+	Type        string
+	Description string
+	Payload     reflect.Type
+}
+
+// Inbound lists the frames accepted from clients.
+var Inbound = []FrameSpec{
+	{TypeSwitchChat, "Sets the chat the client is currently viewing.", reflect.TypeOf(models.ActiveChat{})},
+	{TypeChannelMessage, "Posts a message to a channel.", reflect.TypeOf(models.ChannelMessage{})},
+	{TypeDirectMessage, "Sends a direct message to another user.", reflect.TypeOf(models.DMMessage{})},
+	{TypeTyping, "Signals that the user started typing.", reflect.TypeOf(models.TypingEvent{})},
+	{TypeNotTyping, "Signals that the user stopped typing.", reflect.TypeOf(models.TypingEvent{})},
+	{TypeReauth, "Replaces the session token before it expires.", reflect.TypeOf(Reauth{})},
+	{TypeResume, "Replays the frames a previous session missed after last_seq.", reflect.TypeOf(Resume{})},
+	{TypeSubscribe, "Receives the messages of more chats than the one being viewed.", reflect.TypeOf(Subscriptions{})},
+	{TypeUnsubscribe, "Stops receiving the messages of subscribed chats.", reflect.TypeOf(Subscriptions{})},
+}
+
+// OutboundFrames lists the frames sent by the server.
+var OutboundFrames = []FrameSpec{
+	{TypeWelcome, "First frame of a session with the negotiated protocol version.", reflect.TypeOf(Welcome{})},
+	{TypeChannelMessage, "A message posted in a channel the client is viewing or subscribed to.", reflect.TypeOf(models.ChannelMessage{})},
+	{TypeDirectMessage, "A direct message from a user the client is viewing or subscribed to.", reflect.TypeOf(models.DMMessage{})},
+	{TypeTyping, "Another user started typing.", reflect.TypeOf(models.TypingEvent{})},
+	{TypeNotTyping, "Another user stopped typing.", reflect.TypeOf(models.TypingEvent{})},
+	{TypeNotification, "Unread activity in a chat the client is not viewing.", reflect.TypeOf(Notification{})},
+	{TypeError, "A frame sent by the client was rejected.", reflect.TypeOf(models.ErrorEvent{})},
+	{TypeReauthRequired, "The session token is about to expire.", reflect.TypeOf(ReauthRequired{})},
+	{TypeReauthenticated, "A reauth frame was accepted.", reflect.TypeOf(Reauthenticated{})},
+	{TypeResumed, "The missed frames of a resumed session were replayed.", reflect.TypeOf(Resumed{})},
+	{TypeResyncRequired, "A session can't be resumed; fetch the chat history instead.", reflect.TypeOf(ResyncRequired{})},
+	{TypeSubscriptions, "The chats the session is subscribed to, after a subscribe or unsubscribe frame.", reflect.TypeOf(Subscriptions{})},
+}
@@ -0,0 +1,127 @@
+package protocol
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+)
+
+var (
+	timeType       = reflect.TypeOf(time.Time{})
+	rawMessageType = reflect.TypeOf(json.RawMessage{})
+)
+
+// Schema returns a JSON Schema (draft 2020-12) describing every inbound and
+// outbound frame of the current protocol version, generated from the Go types.
+func Schema() ([]byte, error) {
+	g := &schemaGenerator{defs: make(map[string]any)}
+	g.defs["InboundFrame"] = map[string]any{"oneOf": g.frames(Inbound, false)}
+	g.defs["OutboundFrame"] = map[string]any{"oneOf": g.frames(OutboundFrames, true)}
+
+	schema := map[string]any{
+		"$schema": "https://json-schema.org/draft/2020-12/schema",
+		"title":   fmt.Sprintf("Servit WebSocket protocol v%d", CurrentVersion),
+		"version": CurrentVersion,
+		"$defs":   g.defs,
+		"anyOf": []any{
+			map[string]any{"$ref": "#/$defs/InboundFrame"},
+			map[string]any{"$ref": "#/$defs/OutboundFrame"},
+		},
+	}
+	return json.MarshalIndent(schema, "", "  ")
+}
+
+type schemaGenerator struct {
+	defs map[string]any
+}
+
+func (g *schemaGenerator) frames(specs []FrameSpec, outbound bool) []any {
+	frames := make([]any, 0, len(specs))
+	for _, spec := range specs {
+		properties := map[string]any{
+			"type":      map[string]any{"const": spec.Type},
+			"chat_type": map[string]any{"type": "string", "enum": []string{"channel", "dm"}},
+			"chat_id":   map[string]any{"type": "string"},
+			"data":      g.schemaFor(spec.Payload),
+		}
+		description := spec.Description
+		switch {
+		case outbound && Ephemeral(spec.Type):
+			description += " Ephemeral: may be dropped, has no seq and is not replayed."
+		case outbound:
+			properties["seq"] = map[string]any{"type": "integer", "minimum": 1}
+		}
+		frames = append(frames, map[string]any{
+			"type":        "object",
+			"description": description,
+			"properties":  properties,
+			"required":    []string{"type", "data"},
+		})
+	}
+	return frames
+}
+
+// schemaFor returns the schema of t, registering named structs in $defs.
+func (g *schemaGenerator) schemaFor(t reflect.Type) map[string]any {
+	switch t {
+	case timeType:
+		return map[string]any{"type": "string", "format": "date-time"}
+	case rawMessageType:
+		return map[string]any{}
+	}
+
+	switch t.Kind() {
+	case reflect.Pointer:
+		return g.schemaFor(t.Elem())
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.Slice:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return map[string]any{"type": "string", "contentEncoding": "base64"}
+		}
+		return map[string]any{"type": "array", "items": g.schemaFor(t.Elem())}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": g.schemaFor(t.Elem())}
+	case reflect.Struct:
+		name := t.Name()
+		if _, ok := g.defs[name]; !ok {
+			g.defs[name] = nil // guards against recursive types
+			g.defs[name] = g.structSchema(t)
+		}
+		return map[string]any{"$ref": "#/$defs/" + name}
+	}
+	return map[string]any{}
+}
+
+func (g *schemaGenerator) structSchema(t reflect.Type) map[string]any {
+	properties := make(map[string]any)
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		properties[name] = g.schemaFor(field.Type)
+	}
+	// Fields are not marked required: several are filled in by the server
+	// (timestamps, sender IDs) and are absent from client frames.
+	return map[string]any{
+		"type":       "object",
+		"properties": properties,
+	}
+}
@@ -0,0 +1,92 @@
+package protocol
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// CurrentVersion is the newest protocol version spoken by the server.
+const CurrentVersion = 1
+
+// supportedVersions lists every protocol version the server accepts.
+var supportedVersions = []int{1}
+
+const subprotocolPrefix = "servit.v"
+
+// Subprotocol returns the WebSocket subprotocol name for a version and
+// codec, e.g. "servit.v1" or "servit.v1.msgpack".
+func Subprotocol(version int, codec Codec) string {
+	name := subprotocolPrefix + strconv.Itoa(version)
+	if codec != nil && codec != JSON {
+		name += "." + codec.Name()
+	}
+	return name
+}
+
+// Negotiate picks the protocol version and codec for an upgrade request.
+// Clients may offer subprotocols such as "servit.v1" (JSON) or
+// "servit.v1.msgpack" in the Sec-WebSocket-Protocol header, or a version
+// through the "v" query parameter; without either the current version and
+// the JSON codec are used. The returned subprotocol is empty unless the
+// client offered one and must be echoed in the upgrade response.
+func Negotiate(r *http.Request) (version int, codec Codec, subprotocol string, err error) {
+	offered := false
+	for _, header := range r.Header.Values("Sec-WebSocket-Protocol") {
+		for _, p := range strings.Split(header, ",") {
+			p = strings.TrimSpace(p)
+			v, c, ok := parseSubprotocol(p)
+			if !ok {
+				continue
+			}
+			offered = true
+			// Prefer the newest version, then the client's order.
+			if c != nil && isSupported(v) && v > version {
+				version, codec, subprotocol = v, c, p
+			}
+		}
+	}
+	if version > 0 {
+		return version, codec, subprotocol, nil
+	}
+	if offered {
+		return 0, nil, "", fmt.Errorf("none of the offered subprotocols are supported, supported versions: %v", supportedVersions)
+	}
+
+	if q := r.URL.Query().Get("v"); q != "" {
+		v, err := strconv.Atoi(q)
+		if err != nil || !isSupported(v) {
+			return 0, nil, "", fmt.Errorf("unsupported protocol version %q, supported versions: %v", q, supportedVersions)
+		}
+		return v, JSON, "", nil
+	}
+	return CurrentVersion, JSON, "", nil
+}
+
+// parseSubprotocol splits "servit.v<version>[.<codec>]". The codec is nil
+// when the name is unknown.
+func parseSubprotocol(p string) (int, Codec, bool) {
+	if !strings.HasPrefix(p, subprotocolPrefix) {
+		return 0, nil, false
+	}
+	versionStr, codecName, hasCodec := strings.Cut(strings.TrimPrefix(p, subprotocolPrefix), ".")
+	v, err := strconv.Atoi(versionStr)
+	if err != nil {
+		return 0, nil, false
+	}
+	if !hasCodec {
+		return v, JSON, true
+	}
+	codec, _ := CodecByName(codecName)
+	return v, codec, true
+}
+
+func isSupported(v int) bool {
+	for _, s := range supportedVersions {
+		if s == v {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,54 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// idleBucketTTL is how long an untouched bucket is kept before being pruned.
+const idleBucketTTL = 10 * time.Minute
+
+type bucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+// MemoryStore keeps buckets in process memory. Limits are per node.
+type MemoryStore struct {
+	buckets   map[string]*bucket
+	lastPrune time.Time
+	mu        sync.Mutex
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		buckets:   make(map[string]*bucket),
+		lastPrune: time.Now(),
+	}
+}
+
+// Take implements Store.
+func (s *MemoryStore) Take(_ context.Context, key string, limit Limit, now time.Time) (Decision, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if now.Sub(s.lastPrune) > idleBucketTTL {
+		for k, b := range s.buckets {
+			if now.Sub(b.updated) > idleBucketTTL {
+				delete(s.buckets, k)
+			}
+		}
+		s.lastPrune = now
+	}
+
+	b, ok := s.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(limit.Burst), updated: now}
+		s.buckets[key] = b
+	}
+	tokens, decision := refill(b.tokens, now.Sub(b.updated), limit)
+	b.tokens = tokens
+	b.updated = now
+	return decision, nil
+}
@@ -0,0 +1,57 @@
+package ratelimit
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// takeQuery refills and takes from a bucket in a single statement. The
+// conflicting row is locked by the upsert, so concurrent nodes serialise on
+// the same key.
+const takeQuery = `
+INSERT INTO rate_limit_buckets AS b (key, tokens, updated_at, allowed)
+VALUES ($1, $2::float8 - 1, $4, true)
+ON CONFLICT (key) DO UPDATE SET
+	allowed = LEAST($2::float8, b.tokens + GREATEST(0, EXTRACT(EPOCH FROM ($4 - b.updated_at))) * $3::float8) >= 1,
+	tokens = LEAST($2::float8, b.tokens + GREATEST(0, EXTRACT(EPOCH FROM ($4 - b.updated_at))) * $3::float8)
+		- CASE WHEN LEAST($2::float8, b.tokens + GREATEST(0, EXTRACT(EPOCH FROM ($4 - b.updated_at))) * $3::float8) >= 1 THEN 1 ELSE 0 END,
+	updated_at = $4
+RETURNING tokens, allowed`
+
+// PostgresStore keeps buckets in PostgreSQL so that every node shares them.
+type PostgresStore struct {
+	DB *sql.DB
+}
+
+// NewPostgresStore creates the buckets table if needed and returns the store.
+func NewPostgresStore(db *sql.DB) (*PostgresStore, error) {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS rate_limit_buckets (
+		key        text PRIMARY KEY,
+		tokens     double precision NOT NULL,
+		updated_at timestamptz NOT NULL,
+		allowed    boolean NOT NULL
+	)`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create rate_limit_buckets table: %w", err)
+	}
+	return &PostgresStore{DB: db}, nil
+}
+
+// Take implements Store.
+func (s *PostgresStore) Take(ctx context.Context, key string, limit Limit, now time.Time) (Decision, error) {
+	var (
+		tokens  float64
+		allowed bool
+	)
+	err := s.DB.QueryRowContext(ctx, takeQuery, key, limit.Burst, limit.Rate, now).Scan(&tokens, &allowed)
+	if err != nil {
+		return Decision{}, fmt.Errorf("rate limit query failed: %w", err)
+	}
+	if allowed {
+		return Decision{Allowed: true}, nil
+	}
+	wait := time.Duration((1 - tokens) / limit.Rate * float64(time.Second))
+	return Decision{Allowed: false, RetryAfter: wait}, nil
+}
@@ -0,0 +1,118 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultPolicy is the policy name used when no specific limit is configured.
+const DefaultPolicy = "*"
+
+// Limit describes a token bucket: Rate tokens are added per second up to Burst.
+type Limit struct {
+	Rate  float64
+	Burst int
+}
+
+// Decision is the outcome of a rate limit check.
+type Decision struct {
+	Allowed    bool
+	RetryAfter time.Duration // how long until a token is available when not allowed
+}
+
+// Store keeps token buckets. Implementations shared between nodes make the
+// limits apply across the whole cluster.
+type Store interface {
+	Take(ctx context.Context, key string, limit Limit, now time.Time) (Decision, error)
+}
+
+// Limiter applies named limits to keys using a Store.
+type Limiter struct {
+	store  Store
+	mu     sync.RWMutex
+	limits map[string]Limit
+}
+
+// NewLimiter creates a Limiter. limits maps policy names (a frame type or a
+// route) to their limit; the DefaultPolicy entry, if present, applies to
+// every policy without its own entry.
+func NewLimiter(store Store, limits map[string]Limit) *Limiter {
+	return &Limiter{store: store, limits: limits}
+}
+
+// SetLimits replaces the limits, e.g. after a configuration reload. Buckets
+// already in the store keep their tokens.
+func (l *Limiter) SetLimits(limits map[string]Limit) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.limits = limits
+}
+
+// Allow takes a token for key under the named policy. Policies without a
+// configured limit are always allowed. Store errors fail open so that an
+// unavailable backend does not take the service down with it.
+func (l *Limiter) Allow(ctx context.Context, policy, key string) (Decision, error) {
+	if l == nil {
+		return Decision{Allowed: true}, nil
+	}
+	l.mu.RLock()
+	limit, ok := l.limits[policy]
+	if !ok {
+		limit, ok = l.limits[DefaultPolicy]
+	}
+	l.mu.RUnlock()
+	if !ok {
+		return Decision{Allowed: true}, nil
+	}
+	decision, err := l.store.Take(ctx, policy+":"+key, limit, time.Now())
+	if err != nil {
+		return Decision{Allowed: true}, err
+	}
+	return decision, nil
+}
+
+// ParseLimits parses a comma separated list of policy=rate:burst entries,
+// e.g. "channel_message=5:20,typing=2:5,*=20:50".
+func ParseLimits(s string) (map[string]Limit, error) {
+	limits := make(map[string]Limit)
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		name, spec, ok := strings.Cut(entry, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid rate limit %q: expected policy=rate:burst", entry)
+		}
+		rateStr, burstStr, ok := strings.Cut(spec, ":")
+		if !ok {
+			return nil, fmt.Errorf("invalid rate limit %q: expected policy=rate:burst", entry)
+		}
+		rate, err := strconv.ParseFloat(rateStr, 64)
+		if err != nil || rate <= 0 {
+			return nil, fmt.Errorf("invalid rate in %q", entry)
+		}
+		burst, err := strconv.Atoi(burstStr)
+		if err != nil || burst < 1 {
+			return nil, fmt.Errorf("invalid burst in %q", entry)
+		}
+		limits[strings.TrimSpace(name)] = Limit{Rate: rate, Burst: burst}
+	}
+	return limits, nil
+}
+
+// refill returns the bucket level after elapsed time and whether a token can
+// be taken from it, along with the wait until the next token otherwise.
+func refill(tokens float64, elapsed time.Duration, limit Limit) (float64, Decision) {
+	tokens = math.Min(float64(limit.Burst), tokens+elapsed.Seconds()*limit.Rate)
+	if tokens >= 1 {
+		return tokens - 1, Decision{Allowed: true}
+	}
+	wait := time.Duration((1 - tokens) / limit.Rate * float64(time.Second))
+	return tokens, Decision{Allowed: false, RetryAfter: wait}
+}
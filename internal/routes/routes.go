@@ -1,38 +1,400 @@
 package routes
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"servit-go/internal/auth"
+	"servit-go/internal/config"
 	"servit-go/internal/db"
 	"servit-go/internal/handlers"
+	"servit-go/internal/health"
+	"servit-go/internal/idempotency"
+	"servit-go/internal/metrics"
 	"servit-go/internal/middleware"
+	"servit-go/internal/ratelimit"
 	"servit-go/internal/services"
+	"servit-go/internal/webhooks"
 
 	"github.com/gin-gonic/gin"
 )
 
-func SetupRoutes(router *gin.Engine) {
+// SetupRoutes registers every route with the components they need and
+// returns the WebSocket hub. Settings tagged reload in config.Config follow
+// the watcher's reloads.
+func SetupRoutes(router *gin.Engine, watcher *config.Watcher, checker *health.Checker, logger *slog.Logger) (*services.Hub, error) {
+	cfg := watcher.Current()
+	wsLimiter, httpLimiter, err := newLimiters(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	origins, err := middleware.NewOriginPolicy(middleware.ParseOrigins(cfg.AllowedOrigins), cfg.DevMode)
+	if err != nil {
+		return nil, fmt.Errorf("ALLOWED_ORIGINS: %w", err)
+	}
+	if cfg.DevMode {
+		logger.Warn("dev mode enabled, accepting any loopback origin")
+	}
+	router.Use(origins.CORS())
+
+	sizeLimits, err := newSizeLimits(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if err := handlers.ConfigureUpgrader(upgraderOptions(cfg, origins)); err != nil {
+		return nil, fmt.Errorf("WS_COMPRESSION_LEVEL: %w", err)
+	}
+
+	handlers.ConfigurePagination(handlers.Pagination{Default: cfg.DefaultPageSize, Max: cfg.MaxPageSize})
+
+	tickets, err := newTicketStore(cfg)
+	if err != nil {
+		return nil, err
+	}
+	revocations, err := newRevocationStore(cfg)
+	if err != nil {
+		return nil, err
+	}
+	idempotencyKeys, err := newIdempotencyStore(cfg)
+	if err != nil {
+		return nil, err
+	}
+	webhookStore, err := newWebhookStore(cfg)
+	if err != nil {
+		return nil, err
+	}
+	authenticator, err := newAuthenticator(cfg, revocations)
+	if err != nil {
+		return nil, err
+	}
+	authOpts := middleware.AuthOptions{Authenticator: authenticator, CookieName: cfg.AuthCookie, Origins: origins}
+	requireAuth := middleware.JWTAuthMiddleware(authOpts)
+	authOpts.Tickets = tickets
+	requireWSAuth := middleware.JWTAuthMiddleware(authOpts)
+
+	// Webhook URLs are chosen by channel owners, so they may only point at
+	// public https endpoints, unless in development.
+	webhookPolicy := webhooks.Policy{Insecure: cfg.DevMode}
+	dispatcher := webhooks.NewDispatcher(webhookStore, logger, webhooks.Options{
+		Workers:     cfg.WebhookWorkers,
+		Timeout:     cfg.WebhookTimeout,
+		MaxAttempts: cfg.WebhookMaxAttempts,
+		Backoff:     cfg.WebhookBackoff,
+		MaxBackoff:  cfg.WebhookMaxBackoff,
+		Policy:      webhookPolicy,
+	})
+	go dispatcher.Run(context.Background())
+
 	chatService := services.NewChatService(db.DB)
-	onlineService := services.NewOnlineService()
-	hub := services.NewHub()
+	onlineService := services.NewOnlineService(logger)
+	hub := services.NewHub(logger, services.HubOptions{
+		Limiter:         wsLimiter,
+		MaxViolations:   cfg.MaxRateViolations,
+		SizeLimits:      sizeLimits,
+		Authenticator:   authenticator,
+		ReauthWindow:    cfg.ReauthWindow,
+		PingInterval:    cfg.WSPingInterval,
+		PongWait:        cfg.WSPongWait,
+		WriteWait:       cfg.WSWriteWait,
+		SendBuffer:      cfg.WSSendBuffer,
+		EphemeralBuffer: cfg.WSEphemeralBuf,
+		ReplayBuffer:    cfg.WSReplayBuffer,
+		ReplayTTL:       cfg.WSReplayTTL,
+		PollWait:        cfg.PollWait,
+		PollIdle:        cfg.PollIdleTimeout,
+		ChannelEvents:   dispatcher.PublishFrame,
+	})
+	go hub.WatchRevocations(context.Background(), cfg.RevocationInterval)
+	rateLimit := middleware.RateLimitMiddleware(httpLimiter)
+	idempotent := middleware.IdempotencyMiddleware(idempotencyKeys, cfg.IdempotencyTTL)
+
+	reload := &reloadable{wsLimiter: wsLimiter, httpLimiter: httpLimiter, origins: origins, hub: hub}
+	watcher.Check(CheckConfig)
+	watcher.Subscribe(func(cfg *config.Config) {
+		if err := reload.apply(cfg); err != nil {
+			logger.Error("failed to apply reloaded configuration", "error", err)
+		}
+	})
 
 	// Set up routes
 
-	router.GET("/fetch_paginated_messages", middleware.JWTAuthMiddleware(), func(c *gin.Context) {
+	router.GET("/metrics", gin.WrapH(metrics.Handler()))
+
+	router.GET("/healthz", func(c *gin.Context) {
+		handlers.HealthzHandler(c.Writer, c.Request)
+	})
+
+	router.GET("/readyz", func(c *gin.Context) {
+		handlers.ReadyzHandler(c.Writer, c.Request, checker)
+	})
+
+	router.GET("/fetch_paginated_messages", requireAuth, rateLimit, func(c *gin.Context) {
 		handlers.FetchPaginatedMessagesHandler(c.Writer, c.Request, chatService)
 	})
 
-	router.GET("/fetch_channel_paginated_messages", middleware.JWTAuthMiddleware(), func(c *gin.Context) {
+	router.GET("/fetch_channel_paginated_messages", requireAuth, rateLimit, func(c *gin.Context) {
 		handlers.FetchPaginatedChannelMessagesHandler(c.Writer, c.Request, chatService)
 	})
 
-	router.GET("/ws/online", middleware.JWTAuthMiddleware(), func(c *gin.Context) {
+	router.POST("/ws/ticket", requireAuth, rateLimit, func(c *gin.Context) {
+		handlers.IssueTicketHandler(c.Writer, c.Request, tickets)
+	})
+
+	router.GET("/ws/online", requireWSAuth, rateLimit, func(c *gin.Context) {
 		handlers.OnlineHandler(c.Writer, c.Request, onlineService)
 	})
 
-	router.GET("/friends/online", middleware.JWTAuthMiddleware(), func(c *gin.Context) {
+	router.GET("/friends/online", requireAuth, rateLimit, func(c *gin.Context) {
 		handlers.GetFriendsOnlineStatus(c.Writer, c.Request, onlineService)
 	})
 
-	router.GET("/ws", middleware.JWTAuthMiddleware(), func(c *gin.Context) {
+	router.GET("/ws", requireWSAuth, rateLimit, func(c *gin.Context) {
 		handlers.WsHandler(c, c.Request, hub)
 	})
+
+	// Fallback transports for clients that can't open WebSockets.
+	router.GET("/events", requireWSAuth, rateLimit, func(c *gin.Context) {
+		handlers.EventsHandler(c, hub)
+	})
+
+	router.GET("/poll", requireAuth, rateLimit, func(c *gin.Context) {
+		handlers.PollHandler(c, hub)
+	})
+
+	router.POST("/sessions/:session_id/frames", requireAuth, rateLimit, func(c *gin.Context) {
+		handlers.SessionFrameHandler(c, hub)
+	})
+
+	router.POST("/channels/:channel_id/messages", requireAuth, rateLimit, idempotent, func(c *gin.Context) {
+		handlers.PostChannelMessageHandler(c, hub, false)
+	})
+
+	router.POST("/dms/:user_id/messages", requireAuth, rateLimit, idempotent, func(c *gin.Context) {
+		handlers.PostDirectMessageHandler(c, hub, false)
+	})
+
+	router.POST("/channels/:channel_id/webhooks", requireAuth, rateLimit, func(c *gin.Context) {
+		handlers.CreateWebhookHandler(c, webhookStore, webhookPolicy, false)
+	})
+
+	router.GET("/webhooks", requireAuth, rateLimit, func(c *gin.Context) {
+		handlers.OwnWebhooksHandler(c, webhookStore)
+	})
+
+	router.GET("/webhooks/:webhook_id", requireAuth, rateLimit, func(c *gin.Context) {
+		handlers.WebhookHandler(c, webhookStore)
+	})
+
+	router.DELETE("/webhooks/:webhook_id", requireAuth, rateLimit, func(c *gin.Context) {
+		handlers.DeleteWebhookHandler(c, webhookStore)
+	})
+
+	router.GET("/webhooks/:webhook_id/deliveries", requireAuth, rateLimit, func(c *gin.Context) {
+		handlers.DeliveriesHandler(c, webhookStore)
+	})
+
+	router.POST("/webhooks/:webhook_id/deliveries/:delivery_id/redeliver", requireAuth, rateLimit, func(c *gin.Context) {
+		handlers.RedeliverHandler(c, webhookStore, dispatcher)
+	})
+
+	if cfg.AdminToken != "" {
+		admin := router.Group("/admin", middleware.AdminAuthMiddleware(cfg.AdminToken))
+		admin.POST("/revocations", func(c *gin.Context) {
+			handlers.RevokeHandler(c.Writer, c.Request, revocations, hub, cfg.RevocationTTL)
+		})
+		// Messages posted on behalf of the sender_id of the body, e.g. by
+		// other services.
+		admin.POST("/channels/:channel_id/messages", idempotent, func(c *gin.Context) {
+			handlers.PostChannelMessageHandler(c, hub, true)
+		})
+		admin.POST("/dms/:user_id/messages", idempotent, func(c *gin.Context) {
+			handlers.PostDirectMessageHandler(c, hub, true)
+		})
+		admin.PUT("/channels/:channel_id/owner", func(c *gin.Context) {
+			handlers.SetChannelOwnerHandler(c, webhookStore)
+		})
+		// Webhooks registered on behalf of the owner_id of the body.
+		admin.POST("/channels/:channel_id/webhooks", func(c *gin.Context) {
+			handlers.CreateWebhookHandler(c, webhookStore, webhookPolicy, true)
+		})
+		admin.GET("/channels/:channel_id/webhooks", func(c *gin.Context) {
+			handlers.ChannelWebhooksHandler(c, webhookStore)
+		})
+		router.GET("/debug/status", middleware.AdminAuthMiddleware(cfg.AdminToken), func(c *gin.Context) {
+			handlers.DebugStatusHandler(c.Writer, c.Request, checker, hub)
+		})
+	}
+	return hub, nil
+}
+
+// CheckConfig parses the settings interpreted by SetupRoutes without
+// connecting to any store, so that they can be checked ahead of a deploy.
+func CheckConfig(cfg *config.Config) error {
+	var errs []error
+	if _, _, err := parseLimits(cfg); err != nil {
+		errs = append(errs, err)
+	}
+	if _, err := middleware.NewOriginPolicy(middleware.ParseOrigins(cfg.AllowedOrigins), cfg.DevMode); err != nil {
+		errs = append(errs, fmt.Errorf("ALLOWED_ORIGINS: %w", err))
+	}
+	if _, err := newSizeLimits(cfg); err != nil {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+// reloadable holds the components whose settings follow configuration reloads.
+type reloadable struct {
+	wsLimiter   *ratelimit.Limiter
+	httpLimiter *ratelimit.Limiter
+	origins     *middleware.OriginPolicy
+	hub         *services.Hub
+}
+
+// apply hands the reloadable settings of cfg, already checked by
+// CheckConfig, to the components.
+func (r *reloadable) apply(cfg *config.Config) error {
+	wsLimits, httpLimits, err := parseLimits(cfg)
+	if err != nil {
+		return err
+	}
+	sizeLimits, err := newSizeLimits(cfg)
+	if err != nil {
+		return err
+	}
+	if err := r.origins.Update(middleware.ParseOrigins(cfg.AllowedOrigins), cfg.DevMode); err != nil {
+		return fmt.Errorf("ALLOWED_ORIGINS: %w", err)
+	}
+	if err := handlers.ConfigureUpgrader(upgraderOptions(cfg, r.origins)); err != nil {
+		return fmt.Errorf("WS_COMPRESSION_LEVEL: %w", err)
+	}
+	r.wsLimiter.SetLimits(wsLimits)
+	r.httpLimiter.SetLimits(httpLimits)
+	r.hub.Reconfigure(cfg.MaxRateViolations, sizeLimits)
+	handlers.ConfigurePagination(handlers.Pagination{Default: cfg.DefaultPageSize, Max: cfg.MaxPageSize})
+	return nil
+}
+
+func upgraderOptions(cfg *config.Config, origins *middleware.OriginPolicy) handlers.UpgraderOptions {
+	return handlers.UpgraderOptions{
+		ReadBufferSize:    cfg.WSReadBuffer,
+		WriteBufferSize:   cfg.WSWriteBuffer,
+		EnableCompression: cfg.WSCompression,
+		CompressionLevel:  cfg.WSCompressionLvl,
+		CheckOrigin:       origins.CheckWebSocketOrigin,
+	}
+}
+
+// parseLimits parses the WebSocket and HTTP rate limits.
+func parseLimits(cfg *config.Config) (map[string]ratelimit.Limit, map[string]ratelimit.Limit, error) {
+	wsLimits, err := ratelimit.ParseLimits(cfg.WSRateLimits)
+	if err != nil {
+		return nil, nil, fmt.Errorf("WS_RATE_LIMITS: %w", err)
+	}
+	httpLimits, err := ratelimit.ParseLimits(cfg.HTTPRateLimits)
+	if err != nil {
+		return nil, nil, fmt.Errorf("HTTP_RATE_LIMITS: %w", err)
+	}
+	return wsLimits, httpLimits, nil
+}
+
+// newLimiters builds the WebSocket and HTTP rate limiters on the configured store.
+func newLimiters(cfg *config.Config) (*ratelimit.Limiter, *ratelimit.Limiter, error) {
+	wsLimits, httpLimits, err := parseLimits(cfg)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var store ratelimit.Store
+	switch cfg.RateLimitStore {
+	case "memory", "":
+		store = ratelimit.NewMemoryStore()
+	case "postgres":
+		store, err = ratelimit.NewPostgresStore(db.DB)
+		if err != nil {
+			return nil, nil, err
+		}
+	default:
+		return nil, nil, fmt.Errorf("unknown rate limit store %q", cfg.RateLimitStore)
+	}
+	return ratelimit.NewLimiter(store, wsLimits), ratelimit.NewLimiter(store, httpLimits), nil
+}
+
+// newAuthenticator builds the JWT validator from the configured keys.
+func newAuthenticator(cfg *config.Config, revocations auth.RevocationStore) (*auth.Authenticator, error) {
+	opts := auth.JWTOptions{
+		Revocations:   revocations,
+		SecretKey:     []byte(cfg.SecretKey),
+		Issuer:        cfg.JWTIssuer,
+		Audience:      cfg.JWTAudience,
+		Leeway:        cfg.JWTLeeway,
+		RequireExpiry: cfg.JWTRequireExpiry,
+	}
+	if cfg.JWKSURL != "" {
+		opts.JWKS = auth.NewJWKS(cfg.JWKSURL, cfg.JWKSRefresh)
+	}
+	return auth.NewAuthenticator(opts)
+}
+
+// newRevocationStore builds the token denylist.
+func newRevocationStore(cfg *config.Config) (auth.RevocationStore, error) {
+	switch cfg.RevocationStore {
+	case "memory", "":
+		return auth.NewMemoryRevocationStore(), nil
+	case "postgres":
+		return auth.NewPostgresRevocationStore(db.DB)
+	default:
+		return nil, fmt.Errorf("unknown revocation store %q", cfg.RevocationStore)
+	}
+}
+
+// newIdempotencyStore builds the store of idempotency keys.
+func newIdempotencyStore(cfg *config.Config) (idempotency.Store, error) {
+	switch cfg.IdempotencyStore {
+	case "memory", "":
+		return idempotency.NewMemoryStore(), nil
+	case "postgres":
+		return idempotency.NewPostgresStore(db.DB)
+	default:
+		return nil, fmt.Errorf("unknown idempotency store %q", cfg.IdempotencyStore)
+	}
+}
+
+// newWebhookStore builds the store of webhooks and their deliveries.
+func newWebhookStore(cfg *config.Config) (webhooks.Store, error) {
+	switch cfg.WebhookStore {
+	case "memory", "":
+		return webhooks.NewMemoryStore(), nil
+	case "postgres":
+		return webhooks.NewPostgresStore(db.DB)
+	default:
+		return nil, fmt.Errorf("unknown webhook store %q", cfg.WebhookStore)
+	}
+}
+
+// newTicketStore builds the connect ticket store.
+func newTicketStore(cfg *config.Config) (auth.TicketStore, error) {
+	switch cfg.TicketStore {
+	case "memory", "":
+		return auth.NewMemoryTicketStore(), nil
+	case "postgres":
+		return auth.NewPostgresTicketStore(db.DB)
+	default:
+		return nil, fmt.Errorf("unknown ticket store %q", cfg.TicketStore)
+	}
+}
+
+// newSizeLimits builds the WebSocket frame size limits.
+func newSizeLimits(cfg *config.Config) (services.SizeLimits, error) {
+	inbound, err := services.ParseSizeLimits(cfg.WSFrameLimits)
+	if err != nil {
+		return services.SizeLimits{}, fmt.Errorf("WS_FRAME_LIMITS: %w", err)
+	}
+	outbound, err := services.ParseSizeLimits(cfg.WSWriteLimits)
+	if err != nil {
+		return services.SizeLimits{}, fmt.Errorf("WS_WRITE_LIMITS: %w", err)
+	}
+	return services.SizeLimits{Read: cfg.WSReadLimit, Inbound: inbound, Outbound: outbound}, nil
 }
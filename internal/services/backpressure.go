@@ -0,0 +1,84 @@
+package services
+
+import (
+	"servit-go/internal/metrics"
+	"servit-go/internal/protocol"
+)
+
+// Frames are queued for a client according to their delivery class, which
+// decides what happens when the client reads slower than frames arrive.
+// Queueing never blocks, so it is safe while holding Hub or client locks.
+//
+//   - Ephemeral frames (typing indicators, see protocol.Ephemeral) are
+//     superseded by the next one. They have their own small queue, and when
+//     it is full the oldest frame is discarded. They are not numbered, so
+//     they are not replayed either.
+//   - Every other frame, chat messages and notifications in particular, is
+//     never dropped. When the queue is full the session is closed with
+//     CloseSlowConsumer so that the client reconnects and resumes the
+//     session, see replay.go.
+
+// DefaultEphemeralBuffer is the default size of the ephemeral frame queue.
+const DefaultEphemeralBuffer = 64
+
+// enqueue queues frame for the client according to its delivery class and
+// reports whether it was queued.
+func (c *Client) enqueue(frame *protocol.Frame) bool {
+	if protocol.Ephemeral(frame.Type) && c.Ephemeral != nil {
+		if dropped := pushDropOldest(c.Ephemeral, frame); dropped > 0 {
+			c.shed.Add(int64(dropped))
+			metrics.FramesShed.Add(float64(dropped))
+		}
+		metrics.MessagesOut.WithLabelValues(frame.Type).Inc()
+		metrics.SendQueueDepth.WithLabelValues("ephemeral").Observe(float64(len(c.Ephemeral)))
+		return true
+	}
+
+	// Numbering and queueing happen under seqMu so that frames are written
+	// in sequence order.
+	c.seqMu.Lock()
+	defer c.seqMu.Unlock()
+	seq := c.seq + 1
+	select {
+	case c.Send <- frame.Sequenced(seq):
+		c.seq = seq
+		if c.replay != nil {
+			c.replay.record(seq, frame)
+		}
+		metrics.MessagesOut.WithLabelValues(frame.Type).Inc()
+		metrics.SendQueueDepth.WithLabelValues("reliable").Observe(float64(len(c.Send)))
+		return true
+	default:
+		metrics.MessagesDropped.WithLabelValues(frame.Type).Inc()
+		c.disconnectSlow(frame.Type)
+		return false
+	}
+}
+
+// disconnectSlow closes a session whose queue is full. The close frame can't
+// be queued behind the backlog, so it is written directly.
+func (c *Client) disconnectSlow(frameType string) {
+	if !c.closing.CompareAndSwap(false, true) {
+		return
+	}
+	c.Logger.Warn("disconnecting slow consumer", "type", frameType, "queued", len(c.Send))
+	metrics.SlowConsumerDisconnects.Inc()
+	go c.closeNow(protocol.CloseSlowConsumer, protocol.ReasonResync)
+}
+
+// pushDropOldest queues v on ch, discarding the oldest queued values until it
+// fits. It never blocks and returns how many values were discarded.
+func pushDropOldest[T any](ch chan T, v T) (dropped int) {
+	for {
+		select {
+		case ch <- v:
+			return dropped
+		default:
+		}
+		select {
+		case <-ch:
+			dropped++
+		default:
+		}
+	}
+}
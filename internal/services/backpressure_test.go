@@ -0,0 +1,34 @@
+package services
+
+import (
+	"testing"
+
+	"servit-go/internal/models"
+	"servit-go/internal/protocol"
+)
+
+func TestNotificationsAreNumberedAndNeverShed(t *testing.T) {
+	c := &Client{
+		Send:      make(chan *protocol.Frame, 8),
+		Ephemeral: make(chan *protocol.Frame, 1),
+	}
+	for i := range 3 {
+		c.enqueue(protocol.NewFrame(protocol.TypeTyping, "dm", "alice", models.TypingEvent{}))
+		c.enqueue(protocol.NewFrame(protocol.TypeNotification, "dm", "alice", protocol.Notification{Unread: i + 1}))
+	}
+
+	if len(c.Send) != 3 {
+		t.Fatalf("%d notifications queued, want 3", len(c.Send))
+	}
+	for want := uint64(1); want <= 3; want++ {
+		if frame := <-c.Send; frame.Type != protocol.TypeNotification || frame.Seq != want {
+			t.Errorf("got %s with seq %d, want notification %d", frame.Type, frame.Seq, want)
+		}
+	}
+	if len(c.Ephemeral) != 1 || c.shed.Load() != 2 {
+		t.Errorf("typing indicators: %d queued, %d shed; want 1 and 2", len(c.Ephemeral), c.shed.Load())
+	}
+	if frame := <-c.Ephemeral; frame.Seq != 0 {
+		t.Errorf("typing indicator numbered %d", frame.Seq)
+	}
+}
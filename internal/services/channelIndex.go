@@ -0,0 +1,114 @@
+package services
+
+import (
+	"sync"
+)
+
+// channelRole is how a session takes part in a channel, as a bit set.
+type channelRole uint8
+
+const (
+	// roleViewing sessions have the channel as their ActiveChat and are sent
+	// its messages and typing indicators.
+	roleViewing channelRole = 1 << iota
+	// roleSubscribed sessions are sent the messages and typing indicators of
+	// the channel, and are notified too when they aren't viewing it.
+	roleSubscribed
+)
+
+// channelIndex maps channel IDs to the sessions viewing or subscribed to
+// them, so that sending a channel's messages and typing indicators only
+// touches those sessions instead of every client.
+type channelIndex struct {
+	mu       sync.RWMutex
+	channels map[string]map[*Client]channelRole
+	sessions map[*Client]map[string]struct{} // channels of each session, for removal
+}
+
+// channelSubscriber is a session of a channel and its role at the time the
+// index was read.
+type channelSubscriber struct {
+	client *Client
+	role   channelRole
+}
+
+func newChannelIndex() *channelIndex {
+	return &channelIndex{
+		channels: make(map[string]map[*Client]channelRole),
+		sessions: make(map[*Client]map[string]struct{}),
+	}
+}
+
+// add gives the session role in the channel, in addition to its other roles.
+func (x *channelIndex) add(c *Client, channelID string, role channelRole) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	clients, ok := x.channels[channelID]
+	if !ok {
+		clients = make(map[*Client]channelRole)
+		x.channels[channelID] = clients
+	}
+	clients[c] |= role
+	channels, ok := x.sessions[c]
+	if !ok {
+		channels = make(map[string]struct{})
+		x.sessions[c] = channels
+	}
+	channels[channelID] = struct{}{}
+}
+
+// clear takes role away from the session in the channel. The session stays
+// indexed as long as it has another role.
+func (x *channelIndex) clear(c *Client, channelID string, role channelRole) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	clients := x.channels[channelID]
+	remaining, ok := clients[c]
+	if !ok {
+		return
+	}
+	if remaining &^= role; remaining != 0 {
+		clients[c] = remaining
+		return
+	}
+	x.removeLocked(c, channelID)
+}
+
+// remove drops the session from every channel.
+func (x *channelIndex) remove(c *Client) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	for channelID := range x.sessions[c] {
+		x.removeLocked(c, channelID)
+	}
+}
+
+func (x *channelIndex) removeLocked(c *Client, channelID string) {
+	delete(x.channels[channelID], c)
+	if len(x.channels[channelID]) == 0 {
+		delete(x.channels, channelID)
+	}
+	delete(x.sessions[c], channelID)
+	if len(x.sessions[c]) == 0 {
+		delete(x.sessions, c)
+	}
+}
+
+// subscribers returns the sessions of a channel.
+func (x *channelIndex) subscribers(channelID string) []channelSubscriber {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	clients := x.channels[channelID]
+	subs := make([]channelSubscriber, 0, len(clients))
+	for c, role := range clients {
+		subs = append(subs, channelSubscriber{client: c, role: role})
+	}
+	return subs
+}
+
+// count returns the number of indexed channels.
+func (x *channelIndex) count() int {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	return len(x.channels)
+}
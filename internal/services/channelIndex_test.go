@@ -0,0 +1,162 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"slices"
+	"testing"
+
+	"servit-go/internal/models"
+	"servit-go/internal/protocol"
+)
+
+// testClient returns a registered-looking session of userID, without a
+// connection.
+func testClient(hub *Hub, userID, sessionID string) *Client {
+	c := &Client{
+		ID:        userID,
+		SessionID: sessionID,
+		Send:      make(chan *protocol.Frame, hub.SendBuffer),
+		Ephemeral: make(chan *protocol.Frame, hub.EphemeralBuffer),
+		Hub:       hub,
+		Logger:    hub.Logger,
+		Unread:    make(map[string]int),
+	}
+	if hub.Clients[userID] == nil {
+		hub.Clients[userID] = make(map[string]*Client)
+	}
+	hub.Clients[userID][sessionID] = c
+	hub.sessions++
+	return c
+}
+
+// queued returns the types of the frames queued for c.
+func queued(c *Client) []string {
+	var types []string
+	for len(c.Send) > 0 {
+		types = append(types, (<-c.Send).Type)
+	}
+	return types
+}
+
+func TestBroadcastChannelMessageNotifiesEveryOtherSession(t *testing.T) {
+	hub := NewHub(slog.New(slog.NewTextHandler(io.Discard, nil)), HubOptions{})
+	ctx := context.Background()
+	const channel = "9f1c2a64-5b1e-4c47-8d7e-3a2f6b0c9d11"
+	viewer := testClient(hub, "viewer", "s1")
+	subscriber := testClient(hub, "subscriber", "s1")
+	newTab := testClient(hub, "viewer", "s2") // hasn't touched the channel
+	stranger := testClient(hub, "stranger", "s1")
+	sender := testClient(hub, "sender", "s1")
+	if err := handleSwitchChat(ctx, viewer, &models.ActiveChat{ChatType: "channel", ChatID: channel}); err != nil {
+		t.Fatal(err)
+	}
+	subscription := &protocol.Subscriptions{Chats: []protocol.ChatRef{{ChatType: "channel", ChatID: channel}}}
+	if err := handleSubscribe(ctx, subscriber, subscription); err != nil {
+		t.Fatal(err)
+	}
+	queued(subscriber) // the subscriptions frame
+
+	BroadcastChannelMessage(ctx, models.ChannelMessage{ChannelID: channel, SenderID: "sender", Content: "hi"}, hub)
+
+	for _, tc := range []struct {
+		name   string
+		client *Client
+		want   []string
+	}{
+		{"viewer", viewer, []string{protocol.TypeChannelMessage}},
+		{"subscriber", subscriber, []string{protocol.TypeChannelMessage, protocol.TypeNotification}},
+		{"new tab", newTab, []string{protocol.TypeNotification}},
+		{"stranger", stranger, []string{protocol.TypeNotification}},
+		{"sender", sender, nil},
+	} {
+		if got := queued(tc.client); !slices.Equal(got, tc.want) {
+			t.Errorf("%s got %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
+
+const (
+	benchSessions = 10000
+	benchChannels = 500
+)
+
+// benchHub returns a Hub with benchSessions sessions, each viewing one of
+// benchChannels channels.
+func benchHub(b *testing.B) *Hub {
+	b.Helper()
+	hub := NewHub(slog.New(slog.NewTextHandler(io.Discard, nil)), HubOptions{})
+	for i := range benchSessions {
+		c := testClient(hub, fmt.Sprintf("user-%d", i), fmt.Sprintf("session-%d", i))
+		active := &models.ActiveChat{ChatType: "channel", ChatID: fmt.Sprintf("channel-%d", i%benchChannels)}
+		if err := handleSwitchChat(context.Background(), c, active); err != nil {
+			b.Fatal(err)
+		}
+	}
+	return hub
+}
+
+// broadcastFullScan is BroadcastChannelMessage as it was before the channel
+// index: every session is locked to check whether it views the channel, and
+// every other session is notified.
+func broadcastFullScan(msg models.ChannelMessage, hub *Hub) {
+	frame := protocol.NewFrame(protocol.TypeChannelMessage, "channel", msg.ChannelID, msg)
+	for _, client := range hub.allClients() {
+		if client.ID == msg.SenderID {
+			continue
+		}
+		client.mu.Lock()
+		viewing := client.ActiveChat != nil &&
+			client.ActiveChat.ChatType == "channel" &&
+			client.ActiveChat.ChatID == msg.ChannelID
+		var unread int
+		if !viewing {
+			client.Unread[msg.ChannelID]++
+			unread = client.Unread[msg.ChannelID]
+		}
+		client.mu.Unlock()
+
+		if viewing {
+			client.enqueue(frame)
+			continue
+		}
+		client.enqueue(protocol.NewFrame(protocol.TypeNotification, "channel", msg.ChannelID, protocol.Notification{
+			Unread:  unread,
+			Message: "New message in channel " + msg.ChannelID,
+		}))
+	}
+}
+
+func BenchmarkBroadcastChannelMessage(b *testing.B) {
+	msg := models.ChannelMessage{ChannelID: "channel-0", SenderID: "user-0", Content: "hello"}
+	for _, bench := range []struct {
+		name      string
+		broadcast func(models.ChannelMessage, *Hub)
+	}{
+		{"full_scan", broadcastFullScan},
+		{"indexed", func(msg models.ChannelMessage, hub *Hub) {
+			BroadcastChannelMessage(context.Background(), msg, hub)
+		}},
+	} {
+		b.Run(fmt.Sprintf("%s/sessions=%d", bench.name, benchSessions), func(b *testing.B) {
+			hub := benchHub(b)
+			clients := hub.allClients()
+			b.ReportAllocs()
+			b.ResetTimer()
+			for range b.N {
+				bench.broadcast(msg, hub)
+				// Drains the queues so that no session is disconnected as a
+				// slow consumer.
+				b.StopTimer()
+				for _, c := range clients {
+					for len(c.Send) > 0 {
+						<-c.Send
+					}
+				}
+				b.StartTimer()
+			}
+		})
+	}
+}
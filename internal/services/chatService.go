@@ -1,10 +1,13 @@
 package services
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"servit-go/internal/db"
+	"servit-go/internal/metrics"
 	"servit-go/internal/models"
+	"servit-go/internal/tracing"
 	"time"
 
 	"github.com/gocql/gocql"
@@ -12,8 +15,8 @@ import (
 
 // ChatServiceInterface defines the methods to be mocked
 type ChatServiceInterface interface {
-	QueryMessages(fromUserID, toUserID string, pageSize int, pagingState []byte) ([]models.DMMessage, []byte, error)
-	QueryChannelMessages(channelID string, pageSize int, pagingState []byte) ([]models.ChannelMessage, []byte, error)
+	QueryMessages(ctx context.Context, fromUserID, toUserID string, pageSize int, pagingState []byte) ([]models.DMMessage, []byte, error)
+	QueryChannelMessages(ctx context.Context, channelID string, pageSize int, pagingState []byte) ([]models.ChannelMessage, []byte, error)
 }
 
 type ChatService struct {
@@ -36,7 +39,7 @@ func createConversationID(userA, userB string) string {
 }
 
 // SaveDMMessage saves a direct message to ScyllaDB using conversation_id.
-func SaveDMMessage(msg models.DMMessage) error {
+func SaveDMMessage(ctx context.Context, msg models.DMMessage) error {
 	query := `INSERT INTO direct_messages 
 		(conversation_id, timestamp, message_id, sender_id, receiver_id, content) 
 		VALUES (?, ?, ?, ?, ?, ?)`
@@ -54,18 +57,23 @@ func SaveDMMessage(msg models.DMMessage) error {
 	messageUUID := gocql.TimeUUID()
 	conversationID := createConversationID(msg.SenderID, msg.ReceiverID)
 
-	return db.ScyllaSession.Query(query,
+	ctx, span := tracing.StartScyllaSpan(ctx, "save_dm_message", "direct_messages")
+	start := time.Now()
+	err = db.ScyllaSession.Query(query,
 		conversationID,
 		msg.Timestamp,
 		messageUUID,
 		senderUUID,
 		receiverUUID,
 		msg.Content,
-	).Exec()
+	).WithContext(ctx).Consistency(db.WriteConsistency).Exec()
+	metrics.ObserveQuery("save_dm_message", start, err)
+	tracing.End(span, err)
+	return err
 }
 
 // QueryMessages retrieves messages between two users by using conversation_id.
-func (c *ChatService) QueryMessages(userA, userB string, pageSize int, pagingState []byte) ([]models.DMMessage, []byte, error) {
+func (c *ChatService) QueryMessages(ctx context.Context, userA, userB string, pageSize int, pagingState []byte) ([]models.DMMessage, []byte, error) {
 	conversationID := createConversationID(userA, userB)
 
 	query := `SELECT sender_id, receiver_id, timestamp, message_id, content 
@@ -74,7 +82,9 @@ func (c *ChatService) QueryMessages(userA, userB string, pageSize int, pagingSta
 		ORDER BY timestamp DESC`
 
 	// Use PageSize to set the maximum number of rows per page.
-	q := db.ScyllaSession.Query(query, conversationID).PageSize(pageSize)
+	ctx, span := tracing.StartScyllaSpan(ctx, "query_messages", "direct_messages")
+	start := time.Now()
+	q := db.ScyllaSession.Query(query, conversationID).WithContext(ctx).Consistency(db.ReadConsistency).PageSize(pageSize)
 	if pagingState != nil {
 		q = q.PageState(pagingState)
 	}
@@ -104,14 +114,17 @@ func (c *ChatService) QueryMessages(userA, userB string, pageSize int, pagingSta
 
 	// Capture the paging state for subsequent queries.
 	newPagingState := iter.PageState()
-	if err := iter.Close(); err != nil {
+	err := iter.Close()
+	metrics.ObserveQuery("query_messages", start, err)
+	tracing.End(span, err)
+	if err != nil {
 		return nil, nil, fmt.Errorf("query failed: %w", err)
 	}
 
 	return messages, newPagingState, nil
 }
 
-func SaveChannelMessage(msg models.ChannelMessage) error {
+func SaveChannelMessage(ctx context.Context, msg models.ChannelMessage) error {
 	// Parse channel and sender IDs as UUIDs.
 	channelUUID, err := gocql.ParseUUID(msg.ChannelID)
 	if err != nil {
@@ -132,7 +145,9 @@ func SaveChannelMessage(msg models.ChannelMessage) error {
 	query := `INSERT INTO channel_messages 
 		(channel_id, message_date, timestamp, message_id, sender_id, sender_username, content) 
 		VALUES (?, ?, ?, ?, ?, ?, ?)`
-	return db.ScyllaSession.Query(query,
+	ctx, span := tracing.StartScyllaSpan(ctx, "save_channel_message", "channel_messages")
+	start := time.Now()
+	err = db.ScyllaSession.Query(query,
 		channelUUID,
 		dateBucket,
 		msg.Timestamp,
@@ -140,11 +155,14 @@ func SaveChannelMessage(msg models.ChannelMessage) error {
 		senderUUID,
 		msg.SenderUsername,
 		msg.Content,
-	).Exec()
+	).WithContext(ctx).Consistency(db.WriteConsistency).Exec()
+	metrics.ObserveQuery("save_channel_message", start, err)
+	tracing.End(span, err)
+	return err
 }
 
 // QueryChannelMessages retrieves paginated messages for a given channel and date bucket.
-func (c *ChatService) QueryChannelMessages(channelID string, pageSize int, pagingState []byte) ([]models.ChannelMessage, []byte, error) {
+func (c *ChatService) QueryChannelMessages(ctx context.Context, channelID string, pageSize int, pagingState []byte) ([]models.ChannelMessage, []byte, error) {
 	// Parse channel ID to UUID.
 	channelUUID, err := gocql.ParseUUID(channelID)
 	if err != nil {
@@ -157,7 +175,9 @@ func (c *ChatService) QueryChannelMessages(channelID string, pageSize int, pagin
 		ORDER BY timestamp DESC`
 
 	// Use PageSize to limit the number of rows per page.
-	q := db.ScyllaSession.Query(query, channelUUID, time.Now().Format("2006-01-02")).PageSize(pageSize)
+	ctx, span := tracing.StartScyllaSpan(ctx, "query_channel_messages", "channel_messages")
+	start := time.Now()
+	q := db.ScyllaSession.Query(query, channelUUID, time.Now().Format("2006-01-02")).WithContext(ctx).Consistency(db.ReadConsistency).PageSize(pageSize)
 	if pagingState != nil {
 		q = q.PageState(pagingState)
 	}
@@ -188,7 +208,10 @@ func (c *ChatService) QueryChannelMessages(channelID string, pageSize int, pagin
 	}
 
 	newPagingState := iter.PageState()
-	if err := iter.Close(); err != nil {
+	err = iter.Close()
+	metrics.ObserveQuery("query_channel_messages", start, err)
+	tracing.End(span, err)
+	if err != nil {
 		return nil, nil, fmt.Errorf("query failed: %w", err)
 	}
 
@@ -0,0 +1,257 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"servit-go/internal/models"
+	"servit-go/internal/protocol"
+
+	"github.com/gocql/gocql"
+)
+
+// maxContentLength bounds the content of chat and direct messages, in bytes.
+const maxContentLength = 4000
+
+// NewDefaultFrameRegistry returns a registry with the built-in frame handlers
+// and the standard middleware chain.
+func NewDefaultFrameRegistry() *FrameRegistry {
+	r := NewFrameRegistry()
+	r.Use(RecoverFrames, TraceFrames, MeasureFrames, LogFrames)
+
+	Register(r, protocol.TypeSwitchChat, FrameRoute[models.ActiveChat]{
+		Validate:  validateActiveChat,
+		Authorize: authorizeActiveChat,
+		Handle:    handleSwitchChat,
+	})
+	Register(r, protocol.TypeChannelMessage, FrameRoute[models.ChannelMessage]{
+		Validate:  validateChannelMessage,
+		Authorize: authorizeChannelMessage,
+		Handle:    handleChannelMessage,
+	})
+	Register(r, protocol.TypeDirectMessage, FrameRoute[models.DMMessage]{
+		Validate:  validateDMMessage,
+		Authorize: authorizeDMMessage,
+		Handle:    handleDirectMessage,
+	})
+	Register(r, protocol.TypeReauth, FrameRoute[protocol.Reauth]{
+		Validate: validateReauth,
+		Handle:   handleReauth,
+	})
+	Register(r, protocol.TypeResume, FrameRoute[protocol.Resume]{
+		Validate: validateResume,
+		Handle:   handleResume,
+	})
+	Register(r, protocol.TypeSubscribe, FrameRoute[protocol.Subscriptions]{
+		Validate:  validateSubscriptions,
+		Authorize: authorizeSubscriptions,
+		Handle:    handleSubscribe,
+	})
+	Register(r, protocol.TypeUnsubscribe, FrameRoute[protocol.Subscriptions]{
+		Validate: validateSubscriptions,
+		Handle:   handleUnsubscribe,
+	})
+	for _, frameType := range []string{protocol.TypeTyping, protocol.TypeNotTyping} {
+		frameType := frameType
+		Register(r, frameType, FrameRoute[models.TypingEvent]{
+			Validate:  validateTypingEvent,
+			Authorize: authorizeTypingEvent,
+			Handle: func(ctx context.Context, c *Client, te *models.TypingEvent) error {
+				relayTyping(c, frameType, *te)
+				return nil
+			},
+		})
+	}
+	return r
+}
+
+func validateActiveChat(active *models.ActiveChat) error {
+	if active.ChatType != "channel" && active.ChatType != "dm" {
+		return errors.New("chat_type must be channel or dm")
+	}
+	if active.ChatID == "" {
+		return errors.New("chat_id is required")
+	}
+	return validateUUID("chat_id", active.ChatID)
+}
+
+func authorizeActiveChat(ctx context.Context, c *Client, active *models.ActiveChat) error {
+	return authorizeChat(ctx, c, protocol.ChatRef{ChatType: active.ChatType, ChatID: active.ChatID})
+}
+
+func handleSwitchChat(_ context.Context, c *Client, active *models.ActiveChat) error {
+	c.mu.Lock()
+	previous := c.ActiveChat
+	c.ActiveChat = active
+	// Reset unread count for the newly active chat.
+	c.Unread[active.ChatID] = 0
+	c.mu.Unlock()
+
+	if previous != nil && previous.ChatType == "channel" {
+		c.Hub.channels.clear(c, previous.ChatID, roleViewing)
+	}
+	if active.ChatType == "channel" {
+		c.Hub.channels.add(c, active.ChatID, roleViewing)
+	}
+	return nil
+}
+
+func validateChannelMessage(msg *models.ChannelMessage) error {
+	if msg.ChannelID == "" {
+		return errors.New("channel_id is required")
+	}
+	if err := validateUUID("channel_id", msg.ChannelID); err != nil {
+		return err
+	}
+	if err := validateSenderID(msg.SenderID); err != nil {
+		return err
+	}
+	return validateContent(msg.Content)
+}
+
+// validateUUID rejects IDs the chat tables can't store, so that they are
+// reported as invalid payloads rather than failing the insert.
+func validateUUID(field, id string) error {
+	if _, err := gocql.ParseUUID(id); err != nil {
+		return errors.New(field + " must be a UUID")
+	}
+	return nil
+}
+
+// validateSenderID checks the sender_id of a message, which is optional as
+// it defaults to the authenticated user.
+func validateSenderID(senderID string) error {
+	if senderID == "" {
+		return nil
+	}
+	return validateUUID("sender_id", senderID)
+}
+
+// authorizeChannelMessage rejects messages sent on behalf of another user and
+// stamps the sender from the authenticated session.
+func authorizeChannelMessage(_ context.Context, c *Client, msg *models.ChannelMessage) error {
+	return stampChannelSender(c.ID, c.Username, msg)
+}
+
+func stampChannelSender(userID, username string, msg *models.ChannelMessage) error {
+	if msg.SenderID != "" && msg.SenderID != userID {
+		return errors.New("sender_id does not match the authenticated user")
+	}
+	if err := validateUUID("the authenticated user ID", userID); err != nil {
+		return err
+	}
+	msg.SenderID = userID
+	msg.SenderUsername = username
+	return nil
+}
+
+func handleChannelMessage(ctx context.Context, c *Client, msg *models.ChannelMessage) error {
+	return deliverChannelMessage(ctx, c.Hub, msg)
+}
+
+// deliverChannelMessage stores a validated channel message and fans it out.
+func deliverChannelMessage(ctx context.Context, hub *Hub, msg *models.ChannelMessage) error {
+	msg.Timestamp = time.Now()
+	if err := SaveChannelMessage(ctx, *msg); err != nil {
+		return err
+	}
+	BroadcastChannelMessage(ctx, *msg, hub)
+	return nil
+}
+
+func validateDMMessage(msg *models.DMMessage) error {
+	if msg.ReceiverID == "" {
+		return errors.New("receiver_id is required")
+	}
+	if err := validateUUID("receiver_id", msg.ReceiverID); err != nil {
+		return err
+	}
+	if err := validateSenderID(msg.SenderID); err != nil {
+		return err
+	}
+	return validateContent(msg.Content)
+}
+
+func authorizeDMMessage(_ context.Context, c *Client, msg *models.DMMessage) error {
+	return stampDMSender(c.ID, msg)
+}
+
+func stampDMSender(userID string, msg *models.DMMessage) error {
+	if msg.SenderID != "" && msg.SenderID != userID {
+		return errors.New("sender_id does not match the authenticated user")
+	}
+	if err := validateUUID("the authenticated user ID", userID); err != nil {
+		return err
+	}
+	msg.SenderID = userID
+	return nil
+}
+
+func handleDirectMessage(ctx context.Context, c *Client, msg *models.DMMessage) error {
+	return deliverDirectMessage(ctx, c.Hub, msg)
+}
+
+// deliverDirectMessage stores a validated direct message and delivers it.
+func deliverDirectMessage(ctx context.Context, hub *Hub, msg *models.DMMessage) error {
+	msg.Timestamp = time.Now()
+	if err := SaveDMMessage(ctx, *msg); err != nil {
+		return err
+	}
+	SendDirectMessage(ctx, *msg, hub)
+	return nil
+}
+
+func validateContent(content string) error {
+	if content == "" {
+		return errors.New("content is required")
+	}
+	if len(content) > maxContentLength {
+		return errors.New("content is too long")
+	}
+	return nil
+}
+
+func validateTypingEvent(te *models.TypingEvent) error {
+	switch te.ChatType {
+	case "dm":
+		if te.ToUserID == "" {
+			return errors.New("to_user_id is required for dm typing events")
+		}
+	case "channel":
+		if te.ChatID == "" {
+			return errors.New("chat_id is required for channel typing events")
+		}
+	default:
+		return errors.New("chat_type must be channel or dm")
+	}
+	return nil
+}
+
+func authorizeTypingEvent(_ context.Context, c *Client, te *models.TypingEvent) error {
+	if te.FromUserID != "" && te.FromUserID != c.ID {
+		return errors.New("from_user_id does not match the authenticated user")
+	}
+	te.FromUserID = c.ID
+	te.FromUserName = c.Username
+	return nil
+}
+
+// relayTyping forwards a typing indicator to the DM partner, or to every
+// client viewing or subscribed to the channel except the sender.
+func relayTyping(c *Client, frameType string, te models.TypingEvent) {
+	frame := protocol.NewFrame(frameType, "", "", te)
+
+	if te.ChatType == "dm" {
+		for _, target := range c.Hub.Sessions(te.ToUserID) {
+			target.enqueue(frame)
+		}
+		return
+	}
+
+	for _, sub := range c.Hub.channels.subscribers(te.ChatID) {
+		if sub.client.ID != te.FromUserID {
+			sub.client.enqueue(frame)
+		}
+	}
+}
@@ -0,0 +1,221 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"runtime/debug"
+	"time"
+
+	"servit-go/internal/metrics"
+	"servit-go/internal/models"
+	"servit-go/internal/protocol"
+	"servit-go/internal/tracing"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// unknownFrameType labels frames whose type has no registered handler.
+const unknownFrameType = "unknown"
+
+// FrameHandlerFunc processes one inbound frame for a client. A returned
+// *FrameError is reported to the client as is; any other error is reported
+// as an internal error.
+type FrameHandlerFunc func(ctx context.Context, c *Client, msg models.WSMessage) error
+
+// FrameMiddleware wraps the handler of every frame. frameType is the
+// registered type of the frame, or "unknown".
+type FrameMiddleware func(frameType string, next FrameHandlerFunc) FrameHandlerFunc
+
+// FrameError is a client facing error reported through an error frame.
+type FrameError struct {
+	Code       string
+	Message    string
+	RetryAfter time.Duration
+}
+
+func (e *FrameError) Error() string {
+	return e.Code + ": " + e.Message
+}
+
+// FrameRoute describes how a frame type carrying a T payload is handled.
+// Validate and Authorize are optional and run in that order before Handle.
+type FrameRoute[T any] struct {
+	RateLimit string // rate limit policy, defaults to the frame type
+	Validate  func(payload *T) error
+	Authorize func(ctx context.Context, c *Client, payload *T) error
+	Handle    func(ctx context.Context, c *Client, payload *T) error
+}
+
+// FrameRegistry dispatches inbound frames to the handler registered for their type.
+type FrameRegistry struct {
+	routes     map[string]FrameHandlerFunc
+	middleware []FrameMiddleware
+}
+
+func NewFrameRegistry() *FrameRegistry {
+	return &FrameRegistry{routes: make(map[string]FrameHandlerFunc)}
+}
+
+// Use appends middleware wrapping every handler. The first middleware added
+// is the outermost.
+func (r *FrameRegistry) Use(middleware ...FrameMiddleware) {
+	r.middleware = append(r.middleware, middleware...)
+}
+
+// Handle registers a raw handler for a frame type, replacing any existing one.
+func (r *FrameRegistry) Handle(frameType string, handler FrameHandlerFunc) {
+	r.routes[frameType] = handler
+}
+
+// Register registers a typed route for a frame type. The frame data is
+// decoded into a T, validated and authorized before the handler runs.
+func Register[T any](r *FrameRegistry, frameType string, route FrameRoute[T]) {
+	policy := route.RateLimit
+	if policy == "" {
+		policy = frameType
+	}
+	r.Handle(frameType, func(ctx context.Context, c *Client, msg models.WSMessage) error {
+		if err := c.checkRateLimit(ctx, policy); err != nil {
+			return err
+		}
+		var payload T
+		if err := json.Unmarshal(msg.Data, &payload); err != nil {
+			return &FrameError{Code: protocol.ErrInvalidPayload, Message: "Invalid " + frameType + " data"}
+		}
+		if route.Validate != nil {
+			if err := route.Validate(&payload); err != nil {
+				return &FrameError{Code: protocol.ErrInvalidPayload, Message: err.Error()}
+			}
+		}
+		if route.Authorize != nil {
+			if err := route.Authorize(ctx, c, &payload); err != nil {
+				return &FrameError{Code: protocol.ErrForbidden, Message: err.Error()}
+			}
+		}
+		return route.Handle(ctx, c, &payload)
+	})
+}
+
+// Types returns the registered frame types.
+func (r *FrameRegistry) Types() []string {
+	types := make([]string, 0, len(r.routes))
+	for t := range r.routes {
+		types = append(types, t)
+	}
+	return types
+}
+
+// Dispatch runs the handler registered for msg.Type through the middleware
+// chain and reports any error to the client as an error frame.
+func (r *FrameRegistry) Dispatch(ctx context.Context, c *Client, msg models.WSMessage) {
+	if event := r.Process(ctx, c, msg); event != nil {
+		c.sendError(*event)
+	}
+}
+
+// Process runs the handler registered for msg.Type through the middleware
+// chain and returns the error to report to the client, if any.
+func (r *FrameRegistry) Process(ctx context.Context, c *Client, msg models.WSMessage) *models.ErrorEvent {
+	frameType := msg.Type
+	handler, ok := r.routes[frameType]
+	if !ok {
+		frameType = unknownFrameType
+		handler = func(ctx context.Context, c *Client, msg models.WSMessage) error {
+			if err := c.checkRateLimit(ctx, unknownFrameType); err != nil {
+				return err
+			}
+			return &FrameError{Code: protocol.ErrUnknownType, Message: "Unknown frame type " + msg.Type}
+		}
+	}
+	for i := len(r.middleware) - 1; i >= 0; i-- {
+		handler = r.middleware[i](frameType, handler)
+	}
+
+	err := handler(ctx, c, msg)
+	if err == nil {
+		return nil
+	}
+	var frameErr *FrameError
+	if !errors.As(err, &frameErr) {
+		c.Logger.Error("frame handler failed", "type", msg.Type, "error", err)
+		frameErr = &FrameError{Code: protocol.ErrInternal, Message: "Failed to process " + msg.Type}
+	}
+	return &models.ErrorEvent{
+		Code:         frameErr.Code,
+		Message:      frameErr.Message,
+		Type:         msg.Type,
+		RetryAfterMs: frameErr.RetryAfter.Milliseconds(),
+	}
+}
+
+// RecoverFrames turns a panicking handler into an internal error instead of
+// tearing down the connection.
+func RecoverFrames(frameType string, next FrameHandlerFunc) FrameHandlerFunc {
+	return func(ctx context.Context, c *Client, msg models.WSMessage) (err error) {
+		defer func() {
+			if p := recover(); p != nil {
+				c.Logger.Error("frame handler panicked", "type", frameType, "panic", p, "stack", string(debug.Stack()))
+				err = fmt.Errorf("panic: %v", p)
+			}
+		}()
+		return next(ctx, c, msg)
+	}
+}
+
+// LogFrames logs every frame at debug level and failures at warn level.
+func LogFrames(frameType string, next FrameHandlerFunc) FrameHandlerFunc {
+	return func(ctx context.Context, c *Client, msg models.WSMessage) error {
+		start := time.Now()
+		err := next(ctx, c, msg)
+		if err != nil {
+			c.Logger.Warn("frame rejected", "type", msg.Type, "duration", time.Since(start), "error", err)
+		} else {
+			c.Logger.Debug("frame handled", "type", msg.Type, "duration", time.Since(start))
+		}
+		return err
+	}
+}
+
+// MeasureFrames records inbound frame counts and handler latency.
+func MeasureFrames(frameType string, next FrameHandlerFunc) FrameHandlerFunc {
+	return func(ctx context.Context, c *Client, msg models.WSMessage) error {
+		metrics.MessagesIn.WithLabelValues(frameType).Inc()
+		start := time.Now()
+		err := next(ctx, c, msg)
+		outcome := "ok"
+		var frameErr *FrameError
+		if errors.As(err, &frameErr) {
+			outcome = frameErr.Code
+		} else if err != nil {
+			outcome = protocol.ErrInternal
+		}
+		metrics.FrameDuration.WithLabelValues(frameType, outcome).Observe(time.Since(start).Seconds())
+		return err
+	}
+}
+
+// TraceFrames gives each frame its own trace, linked to the upgrade request
+// so that long-lived sessions don't accumulate one unbounded trace.
+func TraceFrames(frameType string, next FrameHandlerFunc) FrameHandlerFunc {
+	return func(ctx context.Context, c *Client, msg models.WSMessage) error {
+		opts := []trace.SpanStartOption{
+			trace.WithNewRoot(),
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				attribute.String("ws.message.type", msg.Type),
+				attribute.String("user.id", c.ID),
+				attribute.String("session.id", c.SessionID),
+			),
+		}
+		if c.Context != nil {
+			opts = append(opts, trace.WithLinks(trace.LinkFromContext(c.Context)))
+		}
+		ctx, span := tracing.Tracer().Start(ctx, "ws.frame "+frameType, opts...)
+		err := next(ctx, c, msg)
+		tracing.End(span, err)
+		return err
+	}
+}
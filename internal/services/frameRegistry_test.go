@@ -0,0 +1,96 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"slices"
+	"testing"
+
+	"servit-go/internal/models"
+	"servit-go/internal/protocol"
+	"servit-go/internal/ratelimit"
+)
+
+type countPayload struct {
+	N int `json:"n"`
+}
+
+func TestRegisteredRoutesRunEveryStage(t *testing.T) {
+	var stages []string
+	r := NewFrameRegistry()
+	r.Use(RecoverFrames, MeasureFrames, LogFrames)
+	Register(r, "count", FrameRoute[countPayload]{
+		RateLimit: "counting",
+		Validate: func(p *countPayload) error {
+			stages = append(stages, "validate")
+			if p.N < 0 {
+				return errors.New("n must not be negative")
+			}
+			return nil
+		},
+		Authorize: func(_ context.Context, c *Client, p *countPayload) error {
+			stages = append(stages, "authorize")
+			if p.N == 13 {
+				return errors.New("unlucky number")
+			}
+			return nil
+		},
+		Handle: func(_ context.Context, c *Client, p *countPayload) error {
+			stages = append(stages, "handle")
+			switch p.N {
+			case 7:
+				return errors.New("database unavailable")
+			case 9:
+				return &FrameError{Code: protocol.ErrTooLarge, Message: "Count too large"}
+			case 99:
+				panic("handler bug")
+			}
+			return nil
+		},
+	})
+	limiter := ratelimit.NewLimiter(ratelimit.NewMemoryStore(), map[string]ratelimit.Limit{
+		"counting": {Rate: 0.001, Burst: 7},
+	})
+	hub := NewHub(slog.New(slog.NewTextHandler(io.Discard, nil)), HubOptions{Limiter: limiter})
+	c := testClient(hub, "user", "s1")
+
+	for _, tc := range []struct {
+		frameType, data string
+		code            string // "" when handled
+		stages          []string
+	}{
+		{"count", `{"n": 1}`, "", []string{"validate", "authorize", "handle"}},
+		{"count", `{"n": "one"}`, protocol.ErrInvalidPayload, nil},
+		{"count", `{"n": -1}`, protocol.ErrInvalidPayload, []string{"validate"}},
+		{"count", `{"n": 13}`, protocol.ErrForbidden, []string{"validate", "authorize"}},
+		{"count", `{"n": 7}`, protocol.ErrInternal, []string{"validate", "authorize", "handle"}},
+		{"count", `{"n": 9}`, protocol.ErrTooLarge, []string{"validate", "authorize", "handle"}},
+		{"count", `{"n": 99}`, protocol.ErrInternal, []string{"validate", "authorize", "handle"}},
+		{"count", `{"n": 1}`, protocol.ErrRateLimited, nil}, // the burst of 7 is spent
+		{"tally", `{}`, protocol.ErrUnknownType, nil},
+	} {
+		stages = nil
+		event := r.Process(context.Background(), c, models.WSMessage{Type: tc.frameType, Data: []byte(tc.data)})
+		var code string
+		if event != nil {
+			code = event.Code
+			if event.Type != tc.frameType {
+				t.Errorf("%s: error reported for %q", tc.data, event.Type)
+			}
+		}
+		if code != tc.code || !slices.Equal(stages, tc.stages) {
+			t.Errorf("%s %s: got %q after %v, want %q after %v", tc.frameType, tc.data, code, stages, tc.code, tc.stages)
+		}
+	}
+}
+
+func TestDispatchReportsErrorsToTheClient(t *testing.T) {
+	hub := NewHub(slog.New(slog.NewTextHandler(io.Discard, nil)), HubOptions{})
+	c := testClient(hub, "user", "s1")
+	hub.Frames.Dispatch(context.Background(), c, models.WSMessage{Type: protocol.TypeSubscribe, Data: []byte(`{"chats": []}`)})
+	if types := queued(c); !slices.Equal(types, []string{protocol.TypeError}) {
+		t.Errorf("queued %v, want an error frame", types)
+	}
+}
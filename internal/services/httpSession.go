@@ -0,0 +1,190 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"servit-go/internal/metrics"
+	"servit-go/internal/models"
+	"servit-go/internal/protocol"
+
+	"github.com/gorilla/websocket"
+)
+
+// Sessions can also be served over plain HTTP, for clients behind proxies
+// that block WebSockets. A Server-Sent Events session lasts as long as its
+// request; a long-poll session spans many requests and ends once it hasn't
+// been polled for PollIdle. Either way the session is registered with the
+// Hub like a WebSocket session, so fan-out doesn't depend on the transport.
+// Clients send frames with HTTP requests, see HandleFrame.
+
+// Long-poll defaults of HubOptions.
+const (
+	DefaultPollWait = 25 * time.Second
+	DefaultPollIdle = time.Minute
+)
+
+// ErrPollInProgress is returned by Poll while another request polls the
+// same session.
+var ErrPollInProgress = errors.New("session is already being polled")
+
+// httpSession is the state of a session served over HTTP.
+type httpSession struct {
+	done      chan struct{} // closed once the session is closed out of band
+	closeOnce sync.Once
+	code      int // close code and reason, set before done is closed
+	reason    string
+
+	ended   atomic.Bool
+	polling sync.Mutex // held by the poll in progress
+	idleMu  sync.Mutex
+	idle    *time.Timer // ends a long-poll session that isn't polled
+}
+
+// OpenSession registers an HTTP session built by the caller, which sets its
+// identity, Logger, Context and Transport. Sessions with an idle timeout are
+// long-poll sessions; they end once they haven't been polled for that long.
+// Every HTTP session uses the JSON codec and must be ended with End.
+func (h *Hub) OpenSession(c *Client, idle time.Duration) {
+	c.Version = protocol.CurrentVersion
+	c.Codec = protocol.JSON
+	c.Send = make(chan *protocol.Frame, h.SendBuffer)
+	c.Ephemeral = make(chan *protocol.Frame, h.EphemeralBuffer)
+	c.Hub = h
+	c.Unread = make(map[string]int)
+	c.http = &httpSession{done: make(chan struct{})}
+	metrics.Sessions.WithLabelValues(c.Transport).Inc()
+
+	c.Welcome()
+	h.Register(c)
+	if idle > 0 {
+		c.startIdle(idle)
+	}
+}
+
+// End unregisters an HTTP session. It is safe to call more than once.
+func (c *Client) End() {
+	if !c.http.ended.CompareAndSwap(false, true) {
+		return
+	}
+	c.Hub.Unregister(c)
+	c.stopExpiry()
+	c.stopIdle()
+	c.halt(websocket.CloseNormalClosure, "")
+	metrics.Sessions.WithLabelValues(c.Transport).Dec()
+}
+
+// halt closes an HTTP session out of band, dropping the frames still queued.
+func (c *Client) halt(code int, reason string) {
+	if c.http == nil {
+		return
+	}
+	c.http.closeOnce.Do(func() {
+		c.http.code, c.http.reason = code, reason
+		close(c.http.done)
+	})
+}
+
+// NextFrame waits for the next frame of an HTTP session, reliable frames
+// first. Once the session is closed it returns a close frame.
+func (c *Client) NextFrame(ctx context.Context) (*protocol.Frame, error) {
+	if frame := c.tryNextFrame(); frame != nil {
+		return frame, nil
+	}
+	select {
+	case frame := <-c.Send:
+		return frame, nil
+	case frame := <-c.Ephemeral:
+		return frame, nil
+	case <-c.http.done:
+		return protocol.NewCloseFrame(c.http.code, c.http.reason), nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// tryNextFrame returns a queued frame without waiting, or nil.
+func (c *Client) tryNextFrame() *protocol.Frame {
+	select {
+	case frame := <-c.Send:
+		return frame
+	default:
+	}
+	select {
+	case frame := <-c.Ephemeral:
+		return frame
+	default:
+		return nil
+	}
+}
+
+// Poll waits up to PollWait for the frames of a long-poll session and
+// returns at most max of them, or none if the wait ran out. A close frame is
+// always last, and the session has then ended.
+func (c *Client) Poll(ctx context.Context, max int) ([]*protocol.Frame, error) {
+	if !c.http.polling.TryLock() {
+		return nil, ErrPollInProgress
+	}
+	defer c.http.polling.Unlock()
+	c.stopIdle()
+	defer c.startIdle(c.Hub.PollIdle)
+
+	waitCtx, cancel := context.WithTimeout(ctx, c.Hub.PollWait)
+	defer cancel()
+	frame, err := c.NextFrame(waitCtx)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, nil
+	}
+	frames := []*protocol.Frame{frame}
+	for len(frames) < max {
+		if _, _, closed := frame.CloseMessage(); closed {
+			break
+		}
+		if frame = c.tryNextFrame(); frame == nil {
+			break
+		}
+		frames = append(frames, frame)
+	}
+	if _, _, closed := frames[len(frames)-1].CloseMessage(); closed {
+		c.End()
+	}
+	return frames, nil
+}
+
+func (c *Client) startIdle(idle time.Duration) {
+	c.http.idleMu.Lock()
+	defer c.http.idleMu.Unlock()
+	if c.http.ended.Load() {
+		return
+	}
+	c.http.idle = time.AfterFunc(idle, func() {
+		c.Logger.Info("ending idle long-poll session", "idle", idle)
+		c.End()
+	})
+}
+
+func (c *Client) stopIdle() {
+	c.http.idleMu.Lock()
+	defer c.http.idleMu.Unlock()
+	if c.http.idle != nil {
+		c.http.idle.Stop()
+		c.http.idle = nil
+	}
+}
+
+// HandleFrame handles a JSON frame sent over HTTP for a session of any
+// transport. Errors are returned instead of being queued as error frames.
+func (c *Client) HandleFrame(ctx context.Context, message []byte) *models.ErrorEvent {
+	return c.handleMessage(ctx, protocol.JSON, message, c.Hub.sizeLimits())
+}
+
+// ReadLimit returns the size limit of inbound frames, 0 if unlimited.
+func (h *Hub) ReadLimit() int {
+	return h.sizeLimits().Read
+}
@@ -1,383 +1,631 @@
 package services
 
 import (
-	"encoding/json"
-	"log"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"slices"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"servit-go/internal/auth"
+	"servit-go/internal/metrics"
 	"servit-go/internal/models"
+	"servit-go/internal/protocol"
+	"servit-go/internal/ratelimit"
+	"servit-go/internal/tracing"
 
 	"github.com/gorilla/websocket"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
-// Hub maintains the set of active clients.
+// violationWindow is the period over which rate limit violations are counted.
+const violationWindow = time.Minute
+
+// Keepalive and queueing defaults of HubOptions.
+const (
+	DefaultPingInterval = 54 * time.Second
+	DefaultPongWait     = 60 * time.Second
+	DefaultWriteWait    = 10 * time.Second
+	DefaultSendBuffer   = 1024
+)
+
+// HubOptions configures the policies the Hub applies to its clients.
+type HubOptions struct {
+	Limiter       *ratelimit.Limiter // per-user limits keyed by frame type
+	MaxViolations int                // rate limit violations per window before disconnecting, 0 disables
+	SizeLimits    SizeLimits
+
+	// Authenticator validates the tokens of reauth frames; nil rejects them.
+	Authenticator *auth.Authenticator
+	// ReauthWindow is how long before its token expires a session is sent a
+	// reauth_required frame, defaults to DefaultReauthWindow.
+	ReauthWindow time.Duration
+
+	// WritePump pings every PingInterval and ReadPump drops sessions that
+	// haven't answered within PongWait.
+	PingInterval time.Duration
+	PongWait     time.Duration
+	WriteWait    time.Duration // deadline of each write
+	SendBuffer   int           // frames queued per session
+	// EphemeralBuffer is the size of the drop-oldest queue of typing
+	// indicators, see enqueue.
+	EphemeralBuffer int
+
+	// ReplayBuffer is how many frames of each session are retained for
+	// resume frames, 0 disables resuming. The frames of closed sessions are
+	// retained for ReplayTTL, defaults to DefaultReplayTTL.
+	ReplayBuffer int
+	ReplayTTL    time.Duration
+
+	// Long-poll requests wait up to PollWait for frames, and long-poll
+	// sessions end when they haven't been polled for PollIdle.
+	PollWait time.Duration
+	PollIdle time.Duration
+
+	// ChannelEvents, when set, is called with the frames broadcast to a
+	// channel once they are queued, e.g. to send webhooks. It must not block.
+	ChannelEvents func(ctx context.Context, frameType, channelID string, data any)
+
+	// ChannelAccess, when set, decides whether a user may read a channel;
+	// sessions can only view or subscribe to the channels it allows. Without
+	// it every user may read every channel, as through the history routes.
+	ChannelAccess func(ctx context.Context, userID, channelID string) error
+}
+
+// Hub maintains the set of active clients. A user may have several
+// sessions, e.g. one per device.
 type Hub struct {
-	Clients map[string]*Client
-	mu      sync.RWMutex
+	HubOptions
+	Clients  map[string]map[string]*Client // user ID -> session ID -> client
+	sessions int
+	Frames   *FrameRegistry // handlers for inbound frames
+	replay   *replayStore   // nil when resuming is disabled
+	channels *channelIndex  // sessions by channel, for channel fan-out
+	Logger   *slog.Logger
+	mu       sync.RWMutex
+	policyMu sync.RWMutex // guards MaxViolations and SizeLimits once serving
 }
 
-func NewHub() *Hub {
-	return &Hub{
-		Clients: make(map[string]*Client),
+func NewHub(logger *slog.Logger, opts HubOptions) *Hub {
+	if opts.PingInterval <= 0 {
+		opts.PingInterval = DefaultPingInterval
+	}
+	if opts.PongWait <= 0 {
+		opts.PongWait = DefaultPongWait
+	}
+	if opts.WriteWait <= 0 {
+		opts.WriteWait = DefaultWriteWait
+	}
+	if opts.SendBuffer <= 0 {
+		opts.SendBuffer = DefaultSendBuffer
+	}
+	if opts.EphemeralBuffer <= 0 {
+		opts.EphemeralBuffer = DefaultEphemeralBuffer
+	}
+	if opts.ReplayTTL <= 0 {
+		opts.ReplayTTL = DefaultReplayTTL
+	}
+	if opts.PollWait <= 0 {
+		opts.PollWait = DefaultPollWait
+	}
+	if opts.PollIdle <= 0 {
+		opts.PollIdle = DefaultPollIdle
+	}
+	h := &Hub{
+		HubOptions: opts,
+		Clients:    make(map[string]map[string]*Client),
+		Frames:     NewDefaultFrameRegistry(),
+		Logger:     logger,
+		channels:   newChannelIndex(),
+	}
+	if opts.ReplayBuffer > 0 {
+		h.replay = newReplayStore(opts.ReplayBuffer, opts.ReplayTTL)
 	}
+	return h
 }
 
-// Register adds a client to the Hub.
+// Reconfigure replaces the rate limit violation threshold and the frame size
+// limits of every session, e.g. after a configuration reload.
+func (h *Hub) Reconfigure(maxViolations int, limits SizeLimits) {
+	h.policyMu.Lock()
+	defer h.policyMu.Unlock()
+	h.MaxViolations = maxViolations
+	h.SizeLimits = limits
+}
+
+func (h *Hub) sizeLimits() SizeLimits {
+	h.policyMu.RLock()
+	defer h.policyMu.RUnlock()
+	return h.SizeLimits
+}
+
+func (h *Hub) maxViolations() int {
+	h.policyMu.RLock()
+	defer h.policyMu.RUnlock()
+	return h.MaxViolations
+}
+
+// Register adds a client to the Hub and starts tracking its token expiry.
 func (h *Hub) Register(client *Client) {
+	// The replay log is set before the client is published, so that every
+	// numbered frame queued for it is recorded.
+	replay := h.replay.open(client)
+	client.seqMu.Lock()
+	client.replay = replay
+	client.seqMu.Unlock()
+
 	h.mu.Lock()
-	defer h.mu.Unlock()
-	h.Clients[client.ID] = client
+	sessions, ok := h.Clients[client.ID]
+	if !ok {
+		sessions = make(map[string]*Client)
+		h.Clients[client.ID] = sessions
+	}
+	if _, ok := sessions[client.SessionID]; !ok {
+		h.sessions++
+	}
+	sessions[client.SessionID] = client
+	metrics.ConnectedClients.Set(float64(len(h.Clients)))
+	h.mu.Unlock()
+	client.setToken(client.Token)
 }
 
-// Unregister removes a client from the Hub.
+// Unregister removes a client from the Hub. Its replay log is retained so
+// that the session can be resumed.
 func (h *Hub) Unregister(client *Client) {
+	h.replay.close(client)
+	h.channels.remove(client)
 	h.mu.Lock()
 	defer h.mu.Unlock()
-	delete(h.Clients, client.ID)
+	sessions := h.Clients[client.ID]
+	if current, ok := sessions[client.SessionID]; ok && current == client {
+		delete(sessions, client.SessionID)
+		h.sessions--
+		if len(sessions) == 0 {
+			delete(h.Clients, client.ID)
+		}
+	}
+	metrics.ConnectedClients.Set(float64(len(h.Clients)))
+}
+
+// Session returns a connected session of a user, or nil.
+func (h *Hub) Session(userID, sessionID string) *Client {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.Clients[userID][sessionID]
+}
+
+// Sessions returns the connected sessions of a user.
+func (h *Hub) Sessions(userID string) []*Client {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	clients := make([]*Client, 0, len(h.Clients[userID]))
+	for _, c := range h.Clients[userID] {
+		clients = append(clients, c)
+	}
+	return clients
+}
+
+// HubStats is a snapshot of the Hub for the status endpoint.
+type HubStats struct {
+	Users         int            `json:"users"`
+	Sessions      int            `json:"sessions"`
+	Channels      int            `json:"channels"` // channels with indexed sessions
+	QueuedFrames  int            `json:"queued_frames"`
+	SendBuffer    int            `json:"send_buffer"`
+	DeepestQueues []SessionQueue `json:"deepest_queues"`
+}
+
+// SessionQueue is the number of frames waiting to be written to a session.
+type SessionQueue struct {
+	UserID    string `json:"user_id"`
+	SessionID string `json:"session_id"`
+	Queued    int    `json:"queued"`
+	Ephemeral int    `json:"ephemeral"`
+	Shed      int64  `json:"shed"` // ephemeral frames discarded so far
+}
+
+// Stats returns session counts and the top send queue depths.
+func (h *Hub) Stats(top int) HubStats {
+	h.mu.RLock()
+	stats := HubStats{Users: len(h.Clients), Sessions: h.sessions, SendBuffer: h.SendBuffer}
+	h.mu.RUnlock()
+	stats.Channels = h.channels.count()
 
+	clients := h.allClients()
+	queues := make([]SessionQueue, 0, len(clients))
+	for _, c := range clients {
+		q := SessionQueue{
+			UserID:    c.ID,
+			SessionID: c.SessionID,
+			Queued:    len(c.Send),
+			Ephemeral: len(c.Ephemeral),
+			Shed:      c.shed.Load(),
+		}
+		stats.QueuedFrames += q.Queued
+		queues = append(queues, q)
+	}
+	slices.SortFunc(queues, func(a, b SessionQueue) int { return b.Queued - a.Queued })
+	stats.DeepestQueues = queues[:min(top, len(queues))]
+	return stats
+}
+
+// Shutdown closes every session with close code 1001 so that clients
+// reconnect to another node.
+func (h *Hub) Shutdown() {
+	for _, c := range h.allClients() {
+		c.Close(websocket.CloseGoingAway, "server shutting down")
+	}
 }
 
-// GetClient returns a client by user ID.
-func (h *Hub) GetClient(userID string) (*Client, bool) {
+// allClients returns every connected session.
+func (h *Hub) allClients() []*Client {
 	h.mu.RLock()
 	defer h.mu.RUnlock()
-	client, ok := h.Clients[userID]
-	return client, ok
+	clients := make([]*Client, 0, h.sessions)
+	for _, sessions := range h.Clients {
+		for _, c := range sessions {
+			clients = append(clients, c)
+		}
+	}
+	return clients
 }
 
 // Client represents a connected user.
 type Client struct {
 	ID         string
 	Username   string
-	Conn       *websocket.Conn
-	Send       chan []byte
+	SessionID  string
+	Token      auth.Identity        // claims of the token the session was authenticated with
+	Version    int                  // negotiated protocol version
+	Codec      protocol.Codec       // negotiated wire format
+	Logger     *slog.Logger         // carries user_id, session_id and request_id
+	Context    context.Context      // trace context of the authenticated upgrade request
+	Conn       *websocket.Conn      // nil for HTTP sessions, see OpenSession
+	Transport  string               // route of an HTTP session, e.g. "/events"
+	Send       chan *protocol.Frame // frames that are never dropped
+	Ephemeral  chan *protocol.Frame // frames superseded by newer ones, see enqueue
 	Hub        *Hub
 	ActiveChat *models.ActiveChat // current active chat window
 	Unread     map[string]int     // key: chat id, value: unread count
-	mu         sync.Mutex         // protects ActiveChat and Unread
+	mu         sync.Mutex         // protects ActiveChat, Unread and subscriptions
+
+	subscriptions map[protocol.ChatRef]struct{} // chats received besides ActiveChat
+
+	expiry  sessionExpiry
+	tokenMu sync.Mutex // protects Token and expiry once registered
+
+	shed    atomic.Int64 // ephemeral frames discarded by enqueue
+	closing atomic.Bool  // set once the connection is closed out of band
+
+	seqMu  sync.Mutex // serializes numbering and queueing of Send frames
+	seq    uint64     // last number assigned
+	replay *replayLog // frames retained for resume, set by Register
+
+	http *httpSession // nil for WebSocket sessions
+
+	stopReading atomic.Bool // set once the connection is being closed
+
+	// Frames of a session are handled one at a time, whichever transport
+	// they arrive on.
+	frameMu         sync.Mutex
+	violations      int       // rate limit violations in the current window
+	violationsSince time.Time // start of the current violation window
 }
 
 // ReadPump reads messages from the WebSocket connection.
 func (c *Client) ReadPump() {
 	defer func() {
 		c.Hub.Unregister(c)
-		c.Conn.Close()
+		c.stopExpiry()
+		// When a close was requested WritePump closes the connection once the
+		// queued frames, including the close frame, have been written.
+		if !c.stopReading.Load() {
+			c.Conn.Close()
+		}
 	}()
-	c.Conn.SetReadLimit(512)
-	c.Conn.SetReadDeadline(time.Now().Add(60 * time.Second))
+	c.Conn.SetReadDeadline(time.Now().Add(c.Hub.PongWait))
 	c.Conn.SetPongHandler(func(string) error {
-		c.Conn.SetReadDeadline(time.Now().Add(60 * time.Second))
+		c.Conn.SetReadDeadline(time.Now().Add(c.Hub.PongWait))
 		return nil
 	})
-	for {
-		_, message, err := c.Conn.ReadMessage()
+	for !c.stopReading.Load() {
+		limits := c.Hub.sizeLimits()
+		message, err := c.readMessage(limits.Read)
+		if err == errFrameTooLarge {
+			metrics.OversizedFrames.WithLabelValues("in", unknownFrameType).Inc()
+			c.Logger.Warn("closing connection after oversized frame", "limit", limits.Read)
+			c.sendError(protocol.NewError(protocol.ErrTooLarge,
+				fmt.Sprintf("Frames may not exceed %d bytes", limits.Read), ""))
+			c.Close(websocket.CloseMessageTooBig, "message too big")
+			c.stopReading.Store(true)
+			break
+		}
 		if err != nil {
-			log.Println("Read error:", err)
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseNormalClosure) {
+				c.Logger.Warn("websocket read failed", "error", err)
+			} else {
+				c.Logger.Debug("websocket closed", "error", err)
+			}
 			break
 		}
-		var wsMsg models.WSMessage
-		if err := json.Unmarshal(message, &wsMsg); err != nil {
-			log.Println("Invalid message format:", err)
-			continue
+		if event := c.handleMessage(context.Background(), c.Codec, message, limits); event != nil {
+			c.sendError(*event)
 		}
-		switch wsMsg.Type {
-		case "switch_chat":
-			// Client is switching chats (channel or DM).
-			var active models.ActiveChat
-			if err := json.Unmarshal(wsMsg.Data, &active); err != nil {
-				log.Println("Invalid switch_chat data:", err)
-				continue
-			}
-			c.mu.Lock()
-			c.ActiveChat = &active
-			// Reset unread count for the newly active chat.
-			c.Unread[active.ChatID] = 0
-			c.mu.Unlock()
-			log.Printf("User %s switched to %s chat: %s", c.Username, active.ChatType, active.ChatID)
-		case "channel_message":
-			// Process a channel message.
-			var msg models.ChannelMessage
-			if err := json.Unmarshal(wsMsg.Data, &msg); err != nil {
-				log.Println("Invalid channel_message data:", err)
-				continue
-			}
-			msg.Timestamp = time.Now()
-			SaveChannelMessage(msg)
-			BroadcastChannelMessage(msg, c.Hub)
-		case "direct_message":
-			// Process a direct message.
-			var msg models.DMMessage
-			if err := json.Unmarshal(wsMsg.Data, &msg); err != nil {
-				log.Println("Invalid direct_message data:", err)
-				continue
-			}
-			msg.Timestamp = time.Now()
-			SaveDMMessage(msg)
-			SendDirectMessage(msg, c.Hub)
-		case "typing":
-			// Process a typing indicator.
-			var te models.TypingEvent
-			if err := json.Unmarshal(wsMsg.Data, &te); err != nil {
-				log.Println("Invalid typing data:", err)
-				continue
-			}
-			if te.ChatType == "dm" {
-				if target, ok := c.Hub.GetClient(te.ToUserID); ok {
-					data, err := json.Marshal(te)
-					if err != nil {
-						log.Println("Error marshalling typing event:", err)
-						continue
-					}
-					wrapped := models.WSMessage{
-						Type: "typing",
-						Data: data,
-					}
-					wsData, err := json.Marshal(wrapped)
-					if err != nil {
-						log.Println("Error wrapping typing event:", err)
-						continue
-					}
-					target.Send <- wsData
-				}
-			} else if te.ChatType == "channel" {
-				// Broadcast to all clients in the channel except the sender.
-				c.Hub.mu.RLock()
-				for _, client := range c.Hub.Clients {
-					client.mu.Lock()
-					if client.ActiveChat != nil &&
-						client.ActiveChat.ChatType == "channel" &&
-						client.ActiveChat.ChatID == te.ChatID &&
-						client.ID != te.FromUserID {
-						data, err := json.Marshal(te)
-						if err != nil {
-							client.mu.Unlock()
-							continue
-						}
-						wrapped := models.WSMessage{
-							Type: "typing",
-							Data: data,
-						}
-						wsData, err := json.Marshal(wrapped)
-						if err != nil {
-							client.mu.Unlock()
-							continue
-						}
-						client.Send <- wsData
-					}
-					client.mu.Unlock()
-				}
-				c.Hub.mu.RUnlock()
-			}
-		case "not_typing":
-			// Process a "not_typing" indicator.
-			var te models.TypingEvent
-			if err := json.Unmarshal(wsMsg.Data, &te); err != nil {
-				log.Println("Invalid not_typing data:", err)
-				continue
-			}
-			if te.ChatType == "dm" {
-				if target, ok := c.Hub.GetClient(te.ToUserID); ok {
-					data, err := json.Marshal(te)
-					if err != nil {
-						log.Println("Error marshalling not_typing event:", err)
-						continue
-					}
-					wrapped := models.WSMessage{
-						Type: "not_typing",
-						Data: data,
-					}
-					wsData, err := json.Marshal(wrapped)
-					if err != nil {
-						log.Println("Error wrapping not_typing event:", err)
-						continue
-					}
-					target.Send <- wsData
-				}
-			} else if te.ChatType == "channel" {
-				c.Hub.mu.RLock()
-				for _, client := range c.Hub.Clients {
-					client.mu.Lock()
-					if client.ActiveChat != nil &&
-						client.ActiveChat.ChatType == "channel" &&
-						client.ActiveChat.ChatID == te.ChatID &&
-						client.ID != te.FromUserID {
-						data, err := json.Marshal(te)
-						if err != nil {
-							client.mu.Unlock()
-							continue
-						}
-						wrapped := models.WSMessage{
-							Type: "not_typing",
-							Data: data,
-						}
-						wsData, err := json.Marshal(wrapped)
-						if err != nil {
-							client.mu.Unlock()
-							continue
-						}
-						client.Send <- wsData
-					}
-					client.mu.Unlock()
-				}
-				c.Hub.mu.RUnlock()
-			}
-		default:
-			log.Println("Unknown message type:", wsMsg.Type)
+	}
+}
+
+// handleMessage decodes an inbound frame and runs its handler. It returns
+// the error to report to the client, if any.
+func (c *Client) handleMessage(ctx context.Context, codec protocol.Codec, message []byte, limits SizeLimits) *models.ErrorEvent {
+	wsMsg, err := codec.Decode(message)
+	if err != nil {
+		c.Logger.Warn("invalid message format", "codec", codec.Name(), "error", err)
+		event := protocol.NewError(protocol.ErrInvalidFrame, "Frame is not a valid "+codec.Name()+" envelope", "")
+		return &event
+	}
+	if limit := limits.inbound(wsMsg.Type); limit > 0 && len(message) > limit {
+		metrics.OversizedFrames.WithLabelValues("in", wsMsg.Type).Inc()
+		event := protocol.NewError(protocol.ErrTooLarge,
+			fmt.Sprintf("%s frames may not exceed %d bytes", wsMsg.Type, limit), wsMsg.Type)
+		return &event
+	}
+	c.frameMu.Lock()
+	defer c.frameMu.Unlock()
+	return c.Hub.Frames.Process(ctx, c, wsMsg)
+}
+
+var errFrameTooLarge = errors.New("frame exceeds read limit")
+
+// readMessage reads the next data message, stopping after limit bytes so
+// that an oversized frame can be answered instead of just dropping the
+// connection.
+func (c *Client) readMessage(limit int) ([]byte, error) {
+	_, r, err := c.Conn.NextReader()
+	if err != nil {
+		return nil, err
+	}
+	if limit <= 0 {
+		return io.ReadAll(r)
+	}
+	message, err := io.ReadAll(io.LimitReader(r, int64(limit)+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(message) > limit {
+		return nil, errFrameTooLarge
+	}
+	return message, nil
+}
+
+// checkRateLimit applies the per-user rate limit policy of a frame. Clients
+// that keep exceeding their limits are disconnected.
+func (c *Client) checkRateLimit(ctx context.Context, policy string) error {
+	decision, err := c.Hub.Limiter.Allow(ctx, policy, c.ID)
+	if err != nil {
+		c.Logger.Warn("rate limit check failed", "error", err)
+	}
+	if decision.Allowed {
+		return nil
+	}
+
+	metrics.RateLimited.WithLabelValues("ws", policy).Inc()
+	now := time.Now()
+	if now.Sub(c.violationsSince) > violationWindow {
+		c.violations = 0
+		c.violationsSince = now
+	}
+	c.violations++
+	if maxViolations := c.Hub.maxViolations(); maxViolations > 0 && c.violations >= maxViolations {
+		c.Logger.Warn("disconnecting client after repeated rate limit violations", "violations", c.violations)
+		metrics.RateLimitDisconnects.Inc()
+		c.Close(websocket.ClosePolicyViolation, "rate limit exceeded")
+		c.stopReading.Store(true)
+	}
+	return &FrameError{Code: protocol.ErrRateLimited, Message: "Rate limit exceeded", RetryAfter: decision.RetryAfter}
+}
+
+// Welcome queues the first frame of a session, announcing the negotiated
+// protocol version and the session ID.
+func (c *Client) Welcome() {
+	c.sendFrame(protocol.TypeWelcome, protocol.Welcome{Version: c.Version, SessionID: c.SessionID})
+}
+
+// sendError queues an error frame for the client without blocking.
+func (c *Client) sendError(event models.ErrorEvent) {
+	c.sendFrame(protocol.TypeError, event)
+}
+
+// sendFrame queues a frame for the client without blocking.
+func (c *Client) sendFrame(frameType string, data any) {
+	c.enqueue(protocol.NewFrame(frameType, "", "", data))
+}
+
+// Close asks WritePump to flush the frames already queued for the client and
+// then close the connection with code and reason. If the queue is full the
+// connection is closed right away, without waiting for the write.
+func (c *Client) Close(code int, reason string) {
+	select {
+	case c.Send <- protocol.NewCloseFrame(code, reason):
+	default:
+		if c.closing.CompareAndSwap(false, true) {
+			go c.closeNow(code, reason)
 		}
 	}
 }
 
+// closeNow sends a close frame with the given code and reason and closes the
+// connection, which terminates both pumps.
+func (c *Client) closeNow(code int, reason string) {
+	if c.Conn == nil {
+		c.halt(code, reason)
+		return
+	}
+	msg := websocket.FormatCloseMessage(code, reason)
+	if err := c.Conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(time.Second)); err != nil {
+		c.Logger.Debug("failed to write close frame", "error", err)
+	}
+	c.Conn.Close()
+}
+
 func (c *Client) WritePump() {
-	ticker := time.NewTicker(54 * time.Second)
+	ticker := time.NewTicker(c.Hub.PingInterval)
 	defer func() {
 		ticker.Stop()
 		c.Conn.Close()
 	}()
 	for {
 		select {
-		case message, ok := <-c.Send:
-			c.Conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+		case frame, ok := <-c.Send:
+			c.Conn.SetWriteDeadline(time.Now().Add(c.Hub.WriteWait))
 			if !ok {
 				// The channel is closed.
 				c.Conn.WriteMessage(websocket.CloseMessage, []byte{})
 				return
 			}
-			if err := c.Conn.WriteMessage(websocket.TextMessage, message); err != nil {
-				log.Printf("Write error for client %s: %v", c.ID, err)
+			if code, reason, ok := frame.CloseMessage(); ok {
+				c.Conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason))
+				return
+			}
+			if err := c.writeFrame(frame); err != nil {
+				return
+			}
+		case frame := <-c.Ephemeral:
+			c.Conn.SetWriteDeadline(time.Now().Add(c.Hub.WriteWait))
+			if err := c.writeFrame(frame); err != nil {
 				return
-			} else {
 			}
 		case <-ticker.C:
-			c.Conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+			c.Conn.SetWriteDeadline(time.Now().Add(c.Hub.WriteWait))
 			if err := c.Conn.WriteMessage(websocket.PingMessage, nil); err != nil {
-				log.Printf("Ping error for client %s: %v", c.ID, err)
+				c.Logger.Warn("websocket ping failed", "error", err)
 				return
 			}
 		}
 	}
 }
 
-// BroadcastChannelMessage sends a channel message to all connected clients.
-// If a client isn’t actively viewing that channel, it sends a notification with an unread count.
-func BroadcastChannelMessage(msg models.ChannelMessage, hub *Hub) {
-	hub.mu.RLock()
-	defer hub.mu.RUnlock()
-
-	data, err := json.Marshal(msg)
-	if err != nil {
-		log.Println("Error marshalling channel message:", err)
-		return
+// writeFrame encodes and writes a data frame. Frames that can't be encoded
+// or exceed the outbound size limit are skipped; only write errors, which
+// end the session, are returned.
+func (c *Client) writeFrame(frame *protocol.Frame) error {
+	message, ok := c.EncodeFrame(frame)
+	if !ok {
+		return nil
 	}
-
-	// Create a WSMessage wrapper
-	wsMsg := models.WSMessage{
-		Type: "channel_message",
-		Data: data,
+	if err := c.Conn.WriteMessage(c.Codec.MessageType(), message); err != nil {
+		c.Logger.Warn("websocket write failed", "error", err)
+		return err
 	}
+	return nil
+}
 
-	// Marshal the entire WSMessage
-	wrappedData, err := json.Marshal(wsMsg)
+// EncodeFrame encodes a data frame with the session's codec. It reports false
+// for frames that can't be encoded or exceed the outbound size limit, which
+// are skipped.
+func (c *Client) EncodeFrame(frame *protocol.Frame) ([]byte, bool) {
+	message, err := frame.Bytes(c.Codec)
 	if err != nil {
-		log.Println("Error marshalling WSMessage:", err)
-		return
+		c.Logger.Error("failed to encode frame", "type", frame.Type, "codec", c.Codec.Name(), "error", err)
+		return nil, false
 	}
+	if limit := c.Hub.sizeLimits().outbound(frame.Type); limit > 0 && len(message) > limit {
+		metrics.OversizedFrames.WithLabelValues("out", frame.Type).Inc()
+		c.Logger.Warn("dropping oversized frame", "type", frame.Type, "size", len(message), "limit", limit)
+		return nil, false
+	}
+	return message, true
+}
 
-	clients := make([]*Client, 0, len(hub.Clients))
-	for _, c := range hub.Clients {
-		clients = append(clients, c)
+// BroadcastChannelMessage sends a channel message to the sessions viewing or
+// subscribed to the channel, and a notification with an unread count to every
+// other session. Channels have no member list yet, so every connected user is
+// notified.
+func BroadcastChannelMessage(ctx context.Context, msg models.ChannelMessage, hub *Hub) {
+	_, span := tracing.Tracer().Start(ctx, "hub.broadcast_channel_message",
+		trace.WithAttributes(attribute.String("channel.id", msg.ChannelID)))
+	defer span.End()
+
+	// The same frame is queued for every viewer so it is encoded once per codec.
+	frame := protocol.NewFrame(protocol.TypeChannelMessage, "channel", msg.ChannelID, msg)
+
+	// The indexed sessions receive the message; whether they are viewing
+	// the channel decides if they are notified too.
+	subs := hub.channels.subscribers(msg.ChannelID)
+	receivers := make(map[*Client]bool, len(subs))
+	for _, sub := range subs {
+		receivers[sub.client] = sub.role&roleViewing != 0
 	}
+	clients := hub.allClients()
+	span.SetAttributes(attribute.Int("channel.sessions", len(subs)), attribute.Int("hub.clients", len(clients)))
 
 	for _, client := range clients {
-		client.mu.Lock()
-
-		if client.ActiveChat != nil &&
-			client.ActiveChat.ChatType == "channel" &&
-			client.ActiveChat.ChatID == msg.ChannelID &&
-			client.ID != msg.SenderID {
-			select {
-			case client.Send <- wrappedData:
-			default:
-				log.Printf("Send channel full for client %s", client.ID)
-			}
-		} else if client.ID != msg.SenderID {
-			// The client is not active in the channel—send a notification.
-			client.Unread[msg.ChannelID]++
-			notif := map[string]interface{}{
-				"type":      "notification",
-				"chat_type": "channel",
-				"chat_id":   msg.ChannelID,
-				"unread":    client.Unread[msg.ChannelID],
-				"message":   "New message in channel " + msg.ChannelID,
-			}
-			notifData, _ := json.Marshal(notif)
-			select {
-			case client.Send <- notifData:
-			default:
-				log.Printf("Send channel full for client %s, skipping notification", client.ID)
-			}
+		if client.ID == msg.SenderID {
+			continue
+		}
+		viewing, receiving := receivers[client]
+		if receiving {
+			client.enqueue(frame)
 		}
+		if viewing {
+			continue
+		}
+		// The client is not active in the channel—send a notification.
+		client.mu.Lock()
+		client.Unread[msg.ChannelID]++
+		unread := client.Unread[msg.ChannelID]
 		client.mu.Unlock()
+		client.enqueue(protocol.NewFrame(protocol.TypeNotification, "channel", msg.ChannelID, protocol.Notification{
+			Unread:  unread,
+			Message: "New message in channel " + msg.ChannelID,
+		}))
+	}
+	if hub.ChannelEvents != nil {
+		hub.ChannelEvents(ctx, protocol.TypeChannelMessage, msg.ChannelID, msg)
 	}
 }
 
 // SendDirectMessage delivers a direct message to the recipient.
-func SendDirectMessage(msg models.DMMessage, hub *Hub) {
-	hub.mu.RLock()
-	receiver, ok := hub.Clients[msg.ReceiverID]
-	hub.mu.RUnlock()
+func SendDirectMessage(ctx context.Context, msg models.DMMessage, hub *Hub) {
+	_, span := tracing.Tracer().Start(ctx, "hub.send_direct_message",
+		trace.WithAttributes(attribute.String("receiver.id", msg.ReceiverID)))
+	defer span.End()
 
-	// Marshal the DMMessage into the Data field of a WSMessage
-	msgData, err := json.Marshal(msg)
-	if err != nil {
-		log.Println("Error marshalling direct message:", err)
+	receivers := hub.Sessions(msg.ReceiverID)
+	span.SetAttributes(attribute.Int("receiver.sessions", len(receivers)))
+	if len(receivers) == 0 {
+		hub.Logger.Debug("direct message receiver not connected", "receiver_id", msg.ReceiverID)
 		return
 	}
 
-	// Create a WSMessage wrapper
-	wsMsg := models.WSMessage{
-		Type: "direct_message",
-		Data: msgData,
-	}
+	frame := protocol.NewFrame(protocol.TypeDirectMessage, "dm", msg.SenderID, msg)
 
-	// Marshal the entire WSMessage
-	wrappedData, err := json.Marshal(wsMsg)
-	if err != nil {
-		log.Println("Error marshalling WSMessage:", err)
-		return
-	}
-
-	if ok {
+	for _, receiver := range receivers {
 		receiver.mu.Lock()
-		defer receiver.mu.Unlock()
-		log.Print("Active chat, reciever id", receiver.ActiveChat, msg.ReceiverID, msg.SenderID)
-		if receiver.ActiveChat != nil &&
+		viewing := receiver.ActiveChat != nil &&
 			receiver.ActiveChat.ChatType == "dm" &&
-			receiver.ActiveChat.ChatID == msg.SenderID {
-			// Send the wrapped WSMessage
-			select {
-			case receiver.Send <- wrappedData:
-			default:
-				log.Printf("Send channel full for client %s", receiver.ID)
-			}
-		} else {
-			// Send notification (already correctly formatted)
+			receiver.ActiveChat.ChatID == msg.SenderID
+		subscribed := receiver.subscribed("dm", msg.SenderID)
+		var unread int
+		if !viewing {
 			receiver.Unread[msg.SenderID]++
-			notif := map[string]interface{}{
-				"type":      "notification",
-				"chat_type": "dm",
-				"chat_id":   msg.SenderID,
-				"unread":    receiver.Unread[msg.SenderID],
-				"message":   "New direct message from " + msg.SenderID,
-			}
-			notifData, _ := json.Marshal(notif)
-			select {
-			case receiver.Send <- notifData:
-			default:
-				log.Printf("Send channel full for client %s", receiver.ID)
-			}
+			unread = receiver.Unread[msg.SenderID]
+		}
+		receiver.mu.Unlock()
+
+		if viewing || subscribed {
+			receiver.enqueue(frame)
+		}
+		if viewing {
+			continue
 		}
-	} else {
-		log.Printf("User %s not connected. Storing DM notification.", msg.ReceiverID)
+		receiver.enqueue(protocol.NewFrame(protocol.TypeNotification, "dm", msg.SenderID, protocol.Notification{
+			Unread:  unread,
+			Message: "New direct message from " + msg.SenderID,
+		}))
 	}
 }
@@ -0,0 +1,57 @@
+package services
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// SizeLimits bounds the size, in bytes, of WebSocket frames.
+type SizeLimits struct {
+	// Read is the largest frame accepted from a client. Larger frames are
+	// answered with an error frame and the connection is closed.
+	Read int
+	// Inbound and Outbound limit frames by type; the "*" entry applies to
+	// types without their own entry. Oversized inbound frames are rejected
+	// with an error frame, oversized outbound frames are dropped.
+	Inbound  map[string]int
+	Outbound map[string]int
+}
+
+func (l SizeLimits) inbound(frameType string) int {
+	return lookupSize(l.Inbound, frameType)
+}
+
+func (l SizeLimits) outbound(frameType string) int {
+	return lookupSize(l.Outbound, frameType)
+}
+
+// lookupSize returns the limit for frameType, or 0 when it is unlimited.
+func lookupSize(limits map[string]int, frameType string) int {
+	if size, ok := limits[frameType]; ok {
+		return size
+	}
+	return limits["*"]
+}
+
+// ParseSizeLimits parses a comma separated list of type=bytes entries,
+// e.g. "typing=1024,*=16384".
+func ParseSizeLimits(s string) (map[string]int, error) {
+	limits := make(map[string]int)
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		name, sizeStr, ok := strings.Cut(entry, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid size limit %q: expected type=bytes", entry)
+		}
+		size, err := strconv.Atoi(sizeStr)
+		if err != nil || size < 1 {
+			return nil, fmt.Errorf("invalid size in %q", entry)
+		}
+		limits[strings.TrimSpace(name)] = size
+	}
+	return limits, nil
+}
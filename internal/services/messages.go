@@ -0,0 +1,35 @@
+package services
+
+import (
+	"context"
+
+	"servit-go/internal/auth"
+	"servit-go/internal/models"
+	"servit-go/internal/protocol"
+)
+
+// PostChannelMessage sends a channel message outside of a session, e.g.
+// through the REST API, with the validation, authorization, persistence and
+// fan-out of a channel_message frame. Rejected messages return a
+// *FrameError; other errors mean the message couldn't be stored.
+func PostChannelMessage(ctx context.Context, hub *Hub, sender auth.Identity, msg *models.ChannelMessage) error {
+	if err := validateChannelMessage(msg); err != nil {
+		return &FrameError{Code: protocol.ErrInvalidPayload, Message: err.Error()}
+	}
+	if err := stampChannelSender(sender.UserID, sender.UserName, msg); err != nil {
+		return &FrameError{Code: protocol.ErrForbidden, Message: err.Error()}
+	}
+	return deliverChannelMessage(ctx, hub, msg)
+}
+
+// PostDirectMessage sends a direct message outside of a session, like
+// PostChannelMessage.
+func PostDirectMessage(ctx context.Context, hub *Hub, sender auth.Identity, msg *models.DMMessage) error {
+	if err := validateDMMessage(msg); err != nil {
+		return &FrameError{Code: protocol.ErrInvalidPayload, Message: err.Error()}
+	}
+	if err := stampDMSender(sender.UserID, msg); err != nil {
+		return &FrameError{Code: protocol.ErrForbidden, Message: err.Error()}
+	}
+	return deliverDirectMessage(ctx, hub, msg)
+}
@@ -1,54 +1,97 @@
 package services
 
 import (
-	"log"
+	"log/slog"
 	"sync"
+	"time"
+
+	"servit-go/internal/metrics"
 
 	"github.com/gorilla/websocket"
 )
 
+// presenceBuffer is how many status updates are queued per connection.
+// Updates are ephemeral: the oldest is dropped when the queue is full.
+const presenceBuffer = 64
+
 type OnlineService struct {
-	clients map[string]*websocket.Conn
+	clients map[string]*presenceConn
+	logger  *slog.Logger
 	mu      sync.Mutex
 }
 
-func NewOnlineService() *OnlineService {
+// presenceConn is an /ws/online connection with its own writer, so that a
+// slow client never stalls the broadcast.
+type presenceConn struct {
+	conn    *websocket.Conn
+	updates chan map[string]string
+}
+
+func NewOnlineService(logger *slog.Logger) *OnlineService {
 	return &OnlineService{
-		clients: make(map[string]*websocket.Conn),
+		clients: make(map[string]*presenceConn),
+		logger:  logger,
 	}
 }
 
 // Register adds a new user to the online users list
 func (s *OnlineService) Register(userId string, conn *websocket.Conn) {
+	pc := &presenceConn{conn: conn, updates: make(chan map[string]string, presenceBuffer)}
+	go s.writePump(userId, pc)
+
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	s.clients[userId] = conn
+	if old, ok := s.clients[userId]; ok {
+		close(old.updates)
+	}
+	s.clients[userId] = pc
 	s.broadcastStatus(userId, "online")
 }
 
-// Unregister removes a user from the online users list
-func (s *OnlineService) Unregister(userId string) {
+// Unregister removes a user from the online users list, unless conn was
+// already replaced by a newer connection of the same user.
+func (s *OnlineService) Unregister(userId string, conn *websocket.Conn) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	pc, ok := s.clients[userId]
+	if !ok || pc.conn != conn {
+		return
+	}
+	close(pc.updates)
 	delete(s.clients, userId)
 	s.broadcastStatus(userId, "offline")
 }
 
-// broadcastStatus sends an online/offline status update to all clients
+// broadcastStatus queues an online/offline status update for all clients
+// without blocking.
 func (s *OnlineService) broadcastStatus(userId, status string) {
-	for uid, conn := range s.clients {
+	update := map[string]string{
+		"userId": userId,
+		"status": status,
+	}
+	for uid, pc := range s.clients {
 		if uid != userId {
-			err := conn.WriteJSON(map[string]string{
-				"userId": userId,
-				"status": status,
-			})
-			if err != nil {
-				log.Printf("Error broadcasting status to %s: %v", uid, err)
+			if dropped := pushDropOldest(pc.updates, update); dropped > 0 {
+				metrics.FramesShed.Add(float64(dropped))
 			}
 		}
 	}
 }
 
+// writePump writes the queued updates of a connection until it is
+// unregistered. A failed write closes the connection, which ends the
+// handler's read loop and unregisters it.
+func (s *OnlineService) writePump(userId string, pc *presenceConn) {
+	for update := range pc.updates {
+		pc.conn.SetWriteDeadline(time.Now().Add(DefaultWriteWait))
+		if err := pc.conn.WriteJSON(update); err != nil {
+			s.logger.Warn("failed to broadcast online status", "to_user_id", userId, "user_id", update["userId"], "error", err)
+			pc.conn.Close()
+			return
+		}
+	}
+}
+
 func (s *OnlineService) GetStatus(userId string) []map[string]string {
 	var statuses []map[string]string
 	s.mu.Lock()
@@ -0,0 +1,102 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"servit-go/internal/auth"
+	"servit-go/internal/protocol"
+)
+
+// DefaultReauthWindow is how long before the token expires a session is
+// asked to re-authenticate.
+const DefaultReauthWindow = 2 * time.Minute
+
+// sessionExpiry tracks when the token a session was authenticated with
+// expires, and the timers that warn about and enforce it.
+type sessionExpiry struct {
+	expiresAt   time.Time
+	reauthTimer *time.Timer // sends reauth_required
+	closeTimer  *time.Timer // closes the session
+}
+
+// setToken replaces the token of a session and (re)arms the expiry timers.
+// Tokens without an expiry never expire the session.
+func (c *Client) setToken(token auth.Identity) {
+	c.tokenMu.Lock()
+	defer c.tokenMu.Unlock()
+	c.Token = token
+	c.expiry.stop()
+	expiresAt := token.ExpiresAt
+	c.expiry.expiresAt = expiresAt
+	if expiresAt.IsZero() {
+		return
+	}
+
+	window := c.Hub.ReauthWindow
+	if window <= 0 {
+		window = DefaultReauthWindow
+	}
+	remaining := time.Until(expiresAt)
+	c.expiry.reauthTimer = time.AfterFunc(max(remaining-window, 0), func() {
+		c.sendFrame(protocol.TypeReauthRequired, protocol.ReauthRequired{ExpiresAt: expiresAt})
+	})
+	c.expiry.closeTimer = time.AfterFunc(max(remaining, 0), func() {
+		c.Logger.Info("closing session after token expiry", "expires_at", expiresAt)
+		c.Close(protocol.CloseTokenExpired, "token expired")
+	})
+}
+
+// token returns the current token of a session.
+func (c *Client) token() auth.Identity {
+	c.tokenMu.Lock()
+	defer c.tokenMu.Unlock()
+	return c.Token
+}
+
+// stopExpiry stops the expiry timers once the session has ended.
+func (c *Client) stopExpiry() {
+	c.tokenMu.Lock()
+	defer c.tokenMu.Unlock()
+	c.expiry.stop()
+}
+
+func (e *sessionExpiry) stop() {
+	if e.reauthTimer != nil {
+		e.reauthTimer.Stop()
+		e.reauthTimer = nil
+	}
+	if e.closeTimer != nil {
+		e.closeTimer.Stop()
+		e.closeTimer = nil
+	}
+}
+
+func validateReauth(r *protocol.Reauth) error {
+	if r.Token == "" {
+		return errors.New("token is required")
+	}
+	return nil
+}
+
+// handleReauth validates a fresh token for the session's user and moves the
+// session expiry to the expiry of the new token.
+func handleReauth(ctx context.Context, c *Client, r *protocol.Reauth) error {
+	if c.Hub.Authenticator == nil {
+		return &FrameError{Code: protocol.ErrUnauthorized, Message: "Re-authentication is not supported"}
+	}
+	id, err := c.Hub.Authenticator.Authenticate(ctx, r.Token)
+	if err != nil {
+		c.Logger.Info("reauth token rejected", "error", err)
+		return &FrameError{Code: protocol.ErrUnauthorized, Message: "Invalid token"}
+	}
+	if id.UserID != c.ID {
+		return &FrameError{Code: protocol.ErrForbidden, Message: "Token belongs to another user"}
+	}
+
+	c.setToken(id)
+	c.Logger.Debug("session re-authenticated", "expires_at", id.ExpiresAt)
+	c.sendFrame(protocol.TypeReauthenticated, protocol.Reauthenticated{ExpiresAt: id.ExpiresAt})
+	return nil
+}
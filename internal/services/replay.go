@@ -0,0 +1,263 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"servit-go/internal/metrics"
+	"servit-go/internal/protocol"
+)
+
+// Every frame queued on a session's Send channel is numbered, and the
+// numbered frames are kept in a bounded per-session log. A client that
+// reconnects sends a resume frame with the ID of its previous session and the
+// last sequence number it received; the frames it missed are queued again on
+// the new session, renumbered, or it is told to resync from the history
+// endpoints when they are no longer buffered.
+//
+// Logs of closed sessions are retained for ReplayTTL, and for at most
+// replaySessionsPerUser sessions per user.
+
+// DefaultReplayTTL is how long the log of a closed session is retained.
+const DefaultReplayTTL = 2 * time.Minute
+
+// replaySessionsPerUser bounds the closed sessions retained per user.
+const replaySessionsPerUser = 8
+
+// sessionFrames are not replayed: they describe the session they were sent
+// on rather than the chats of the user.
+var sessionFrames = map[string]bool{
+	protocol.TypeWelcome:         true,
+	protocol.TypeError:           true,
+	protocol.TypeReauthRequired:  true,
+	protocol.TypeReauthenticated: true,
+	protocol.TypeResumed:         true,
+	protocol.TypeResyncRequired:  true,
+	protocol.TypeSubscriptions:   true,
+}
+
+type replayEntry struct {
+	seq   uint64
+	frame *protocol.Frame // unsequenced, shared with the other recipients
+}
+
+// replayLog is a ring buffer of the latest replayable frames of a session.
+type replayLog struct {
+	mu       sync.Mutex
+	entries  []replayEntry
+	next     int       // where the next entry is written once full
+	floor    uint64    // frames up to floor are no longer buffered
+	client   *Client   // nil once the session is closed
+	closedAt time.Time // zero while the session is connected
+}
+
+func (l *replayLog) record(seq uint64, frame *protocol.Frame) {
+	if sessionFrames[frame.Type] {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.entries) < cap(l.entries) {
+		l.entries = append(l.entries, replayEntry{seq, frame})
+		return
+	}
+	l.floor = l.entries[l.next].seq
+	l.entries[l.next] = replayEntry{seq, frame}
+	l.next = (l.next + 1) % len(l.entries)
+}
+
+// since returns the buffered frames numbered after seq, oldest first. It
+// fails if some of them were already evicted.
+func (l *replayLog) since(seq uint64) ([]*protocol.Frame, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if seq < l.floor {
+		return nil, false
+	}
+	var frames []*protocol.Frame
+	for i := range l.entries {
+		e := l.entries[(l.next+i)%len(l.entries)]
+		if e.seq > seq {
+			frames = append(frames, e.frame)
+		}
+	}
+	return frames, true
+}
+
+// contains returns the set of frames buffered in the log.
+func (l *replayLog) contains() map[*protocol.Frame]bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	set := make(map[*protocol.Frame]bool, len(l.entries))
+	for _, e := range l.entries {
+		set[e.frame] = true
+	}
+	return set
+}
+
+// replayStore holds the logs of connected and recently closed sessions.
+type replayStore struct {
+	capacity int
+	ttl      time.Duration
+
+	mu     sync.Mutex
+	logs   map[string]map[string]*replayLog // user ID -> session ID -> log
+	pruned time.Time
+}
+
+func newReplayStore(capacity int, ttl time.Duration) *replayStore {
+	return &replayStore{capacity: capacity, ttl: ttl, logs: make(map[string]map[string]*replayLog)}
+}
+
+// open starts the log of a new session. A nil store keeps no logs.
+func (s *replayStore) open(c *Client) *replayLog {
+	if s == nil {
+		return nil
+	}
+	log := &replayLog{entries: make([]replayEntry, 0, s.capacity), client: c}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.prune(time.Now())
+	sessions, ok := s.logs[c.ID]
+	if !ok {
+		sessions = make(map[string]*replayLog)
+		s.logs[c.ID] = sessions
+	}
+	sessions[c.SessionID] = log
+	return log
+}
+
+// close retains the log of a closed session for the TTL, evicting the
+// oldest closed sessions of the user beyond replaySessionsPerUser.
+func (s *replayStore) close(c *Client) {
+	if s == nil {
+		return
+	}
+	now := time.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sessions := s.logs[c.ID]
+	log, ok := sessions[c.SessionID]
+	if !ok || log.client != c {
+		return
+	}
+	log.client = nil
+	log.closedAt = now
+
+	for {
+		var oldestID string
+		var oldest *replayLog
+		closed := 0
+		for id, l := range sessions {
+			if l.client != nil {
+				continue
+			}
+			closed++
+			if oldest == nil || l.closedAt.Before(oldest.closedAt) {
+				oldestID, oldest = id, l
+			}
+		}
+		if closed <= replaySessionsPerUser {
+			return
+		}
+		delete(sessions, oldestID)
+	}
+}
+
+// take removes and returns the log of a session of userID, along with the
+// session's client if it is still connected.
+func (s *replayStore) take(userID, sessionID string) (*replayLog, *Client) {
+	if s == nil {
+		return nil, nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.prune(time.Now())
+	log, ok := s.logs[userID][sessionID]
+	if !ok {
+		return nil, nil
+	}
+	s.removeLocked(userID, sessionID)
+	return log, log.client
+}
+
+// prune drops the logs of sessions closed for longer than the TTL. It
+// scans the store at most once per half TTL.
+func (s *replayStore) prune(now time.Time) {
+	if now.Sub(s.pruned) < s.ttl/2 {
+		return
+	}
+	s.pruned = now
+	for userID, sessions := range s.logs {
+		for sessionID, l := range sessions {
+			if l.client == nil && now.Sub(l.closedAt) > s.ttl {
+				s.removeLocked(userID, sessionID)
+			}
+		}
+	}
+}
+
+func (s *replayStore) removeLocked(userID, sessionID string) {
+	delete(s.logs[userID], sessionID)
+	if len(s.logs[userID]) == 0 {
+		delete(s.logs, userID)
+	}
+}
+
+func validateResume(r *protocol.Resume) error {
+	if r.SessionID == "" {
+		return errors.New("session_id is required")
+	}
+	return nil
+}
+
+// handleResume replays the frames a previous session of the user missed.
+// A previous session that is still connected, e.g. because its network
+// dropped without a close, is closed first.
+func handleResume(_ context.Context, c *Client, r *protocol.Resume) error {
+	if r.SessionID == c.SessionID {
+		return &FrameError{Code: protocol.ErrInvalidPayload, Message: "Cannot resume the current session"}
+	}
+	log, previous := c.Hub.replay.take(c.ID, r.SessionID)
+	if log == nil {
+		return resyncRequired(c, r.SessionID, protocol.ResyncUnknownSession)
+	}
+	if previous != nil {
+		previous.Close(protocol.CloseSessionResumed, protocol.ReasonSessionResumed)
+	}
+	frames, ok := log.since(r.LastSeq)
+	if !ok {
+		return resyncRequired(c, r.SessionID, protocol.ResyncGap)
+	}
+
+	// Frames broadcast while both sessions were connected were already
+	// queued on this one.
+	var delivered map[*protocol.Frame]bool
+	if c.replay != nil {
+		delivered = c.replay.contains()
+	}
+	replayed := 0
+	for _, frame := range frames {
+		if delivered[frame] {
+			continue
+		}
+		if !c.enqueue(frame) {
+			return nil
+		}
+		replayed++
+	}
+	metrics.SessionResumes.WithLabelValues("resumed").Inc()
+	metrics.FramesReplayed.Add(float64(replayed))
+	c.Logger.Info("session resumed", "previous_session_id", r.SessionID, "last_seq", r.LastSeq, "replayed", replayed)
+	c.sendFrame(protocol.TypeResumed, protocol.Resumed{SessionID: r.SessionID, Replayed: replayed})
+	return nil
+}
+
+func resyncRequired(c *Client, sessionID, reason string) error {
+	metrics.SessionResumes.WithLabelValues(reason).Inc()
+	c.Logger.Info("session cannot be resumed", "previous_session_id", sessionID, "reason", reason)
+	c.sendFrame(protocol.TypeResyncRequired, protocol.ResyncRequired{SessionID: sessionID, Reason: reason})
+	return nil
+}
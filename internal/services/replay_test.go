@@ -0,0 +1,140 @@
+package services
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"slices"
+	"testing"
+
+	"servit-go/internal/protocol"
+)
+
+// connect registers a session of userID without a connection.
+func connect(hub *Hub, userID, sessionID string) *Client {
+	c := &Client{
+		ID:        userID,
+		SessionID: sessionID,
+		Send:      make(chan *protocol.Frame, hub.SendBuffer),
+		Ephemeral: make(chan *protocol.Frame, hub.EphemeralBuffer),
+		Hub:       hub,
+		Logger:    hub.Logger,
+		Unread:    make(map[string]int),
+	}
+	hub.Register(c)
+	return c
+}
+
+// drain returns the frames queued for c.
+func drain(c *Client) []*protocol.Frame {
+	var frames []*protocol.Frame
+	for len(c.Send) > 0 {
+		frames = append(frames, <-c.Send)
+	}
+	return frames
+}
+
+// messages queues n numbered messages for c and returns them.
+func messages(c *Client, n int) []*protocol.Frame {
+	frames := make([]*protocol.Frame, n)
+	for i := range frames {
+		frames[i] = protocol.NewFrame(protocol.TypeChannelMessage, "channel", "general", i)
+		c.enqueue(frames[i])
+	}
+	return frames
+}
+
+func TestResume(t *testing.T) {
+	newHub := func() *Hub {
+		return NewHub(slog.New(slog.NewTextHandler(io.Discard, nil)), HubOptions{ReplayBuffer: 4})
+	}
+	resume := func(c *Client, sessionID string, lastSeq uint64) []*protocol.Frame {
+		t.Helper()
+		if err := handleResume(context.Background(), c, &protocol.Resume{SessionID: sessionID, LastSeq: lastSeq}); err != nil {
+			t.Fatal(err)
+		}
+		return drain(c)
+	}
+	payloads := func(frames []*protocol.Frame) []any {
+		var data []any
+		for _, f := range frames {
+			data = append(data, f.Data)
+		}
+		return data
+	}
+
+	t.Run("within the buffer", func(t *testing.T) {
+		hub := newHub()
+		old := connect(hub, "alice", "old")
+		messages(old, 5)
+		drain(old) // seen up to seq 2 only
+		hub.Unregister(old)
+
+		c := connect(hub, "alice", "new")
+		frames := resume(c, "old", 2)
+		if got := payloads(frames); !slices.Equal(got, []any{2, 3, 4, protocol.Resumed{SessionID: "old", Replayed: 3}}) {
+			t.Fatalf("got %v", got)
+		}
+		for i, f := range frames {
+			if f.Seq != uint64(i+1) {
+				t.Errorf("frame %d renumbered %d", i, f.Seq)
+			}
+		}
+	})
+
+	t.Run("beyond the buffer", func(t *testing.T) {
+		hub := newHub()
+		old := connect(hub, "alice", "old")
+		messages(old, 6) // seqs 1 and 2 are evicted
+		hub.Unregister(old)
+
+		c := connect(hub, "alice", "new")
+		want := []any{protocol.ResyncRequired{SessionID: "old", Reason: protocol.ResyncGap}}
+		if got := payloads(resume(c, "old", 1)); !slices.Equal(got, want) {
+			t.Errorf("got %v, want %v", got, want)
+		}
+	})
+
+	t.Run("stale session", func(t *testing.T) {
+		hub := newHub()
+		old := connect(hub, "alice", "old")
+		messages(old, 1)
+		hub.Unregister(old)
+		resume(connect(hub, "alice", "resumed"), "old", 0)
+
+		for _, tc := range []struct{ userID, sessionID string }{
+			{"alice", "old"},     // already resumed
+			{"alice", "unknown"}, // expired or never existed
+			{"mallory", "old"},   // belongs to another user
+		} {
+			c := connect(hub, tc.userID, "new-"+tc.userID)
+			want := []any{protocol.ResyncRequired{SessionID: tc.sessionID, Reason: protocol.ResyncUnknownSession}}
+			if got := payloads(resume(c, tc.sessionID, 0)); !slices.Equal(got, want) {
+				t.Errorf("%s %s: got %v, want %v", tc.userID, tc.sessionID, got, want)
+			}
+		}
+	})
+
+	t.Run("no duplicates", func(t *testing.T) {
+		hub := newHub()
+		old := connect(hub, "alice", "old")
+		messages(old, 2)
+		c := connect(hub, "alice", "new")
+		// Broadcast while both sessions are connected.
+		for _, frame := range messages(old, 2) {
+			c.enqueue(frame)
+		}
+		drain(c)
+
+		if got := payloads(resume(c, "old", 0)); !slices.Equal(got, []any{0, 1, protocol.Resumed{SessionID: "old", Replayed: 2}}) {
+			t.Errorf("got %v", got)
+		}
+		closed := slices.ContainsFunc(drain(old), func(f *protocol.Frame) bool {
+			code, _, ok := f.CloseMessage()
+			return ok && code == protocol.CloseSessionResumed
+		})
+		if !closed {
+			t.Error("previous session not closed")
+		}
+	})
+}
@@ -0,0 +1,69 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"servit-go/internal/auth"
+	"servit-go/internal/metrics"
+	"servit-go/internal/protocol"
+)
+
+// DisconnectUser closes every session of a user on this node and returns the
+// number of sessions closed.
+func (h *Hub) DisconnectUser(userID string) int {
+	return h.revokeSessions(h.Sessions(userID))
+}
+
+// DisconnectToken closes every session authenticated with the token with the
+// given jti on this node and returns the number of sessions closed.
+func (h *Hub) DisconnectToken(jti string) int {
+	var matched []*Client
+	for _, c := range h.allClients() {
+		if c.token().TokenID == jti {
+			matched = append(matched, c)
+		}
+	}
+	return h.revokeSessions(matched)
+}
+
+func (h *Hub) revokeSessions(clients []*Client) int {
+	for _, c := range clients {
+		c.Logger.Info("closing revoked session")
+		c.Close(protocol.CloseSessionRevoked, protocol.ReasonSessionRevoked)
+	}
+	metrics.RevokedSessions.Add(float64(len(clients)))
+	return len(clients)
+}
+
+// WatchRevocations periodically checks the tokens of every session against
+// the revocation store until ctx is done. It picks up revocations made
+// through other nodes, which can't disconnect sessions on this one directly.
+func (h *Hub) WatchRevocations(ctx context.Context, interval time.Duration) {
+	if h.Authenticator == nil || interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		var revoked []*Client
+		for _, c := range h.allClients() {
+			err := h.Authenticator.CheckRevoked(ctx, c.token())
+			if errors.Is(err, auth.ErrRevoked) {
+				revoked = append(revoked, c)
+			} else if err != nil {
+				// Keep sessions open while the store is unavailable.
+				h.Logger.Warn("revocation check failed", "error", err)
+				break
+			}
+		}
+		h.revokeSessions(revoked)
+	}
+}
@@ -0,0 +1,143 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"servit-go/internal/protocol"
+)
+
+// maxSubscriptions bounds the chats a session may be subscribed to.
+const maxSubscriptions = 100
+
+// A session receives the full messages of the chat it is viewing and of the
+// chats it subscribed to. The viewed chat, ActiveChat, is also its focus:
+// messages there don't count as unread and aren't notified.
+
+func validateSubscriptions(s *protocol.Subscriptions) error {
+	if len(s.Chats) == 0 {
+		return errors.New("chats is required")
+	}
+	if len(s.Chats) > maxSubscriptions {
+		return fmt.Errorf("at most %d chats may be listed", maxSubscriptions)
+	}
+	for _, chat := range s.Chats {
+		if chat.ChatType != "channel" && chat.ChatType != "dm" {
+			return errors.New("chat_type must be channel or dm")
+		}
+		if chat.ChatID == "" {
+			return errors.New("chat_id is required")
+		}
+		if err := validateUUID("chat_id", chat.ChatID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func authorizeSubscriptions(ctx context.Context, c *Client, s *protocol.Subscriptions) error {
+	for _, chat := range s.Chats {
+		if err := authorizeChat(ctx, c, chat); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// authorizeChat reports whether the user of a session may view or subscribe
+// to a chat. The chat ID of a DM is the other user, and channels are checked
+// with HubOptions.ChannelAccess.
+func authorizeChat(ctx context.Context, c *Client, chat protocol.ChatRef) error {
+	if chat.ChatType == "dm" {
+		if chat.ChatID == c.ID {
+			return errors.New("chat_id of a DM must be another user")
+		}
+		return nil
+	}
+	if c.Hub.ChannelAccess == nil {
+		return nil
+	}
+	if err := c.Hub.ChannelAccess(ctx, c.ID, chat.ChatID); err != nil {
+		return fmt.Errorf("channel %s is not readable: %w", chat.ChatID, err)
+	}
+	return nil
+}
+
+func handleSubscribe(_ context.Context, c *Client, s *protocol.Subscriptions) error {
+	c.mu.Lock()
+	if c.subscriptions == nil {
+		c.subscriptions = make(map[protocol.ChatRef]struct{})
+	}
+	var added []protocol.ChatRef
+	for _, chat := range s.Chats {
+		if _, ok := c.subscriptions[chat]; !ok {
+			added = append(added, chat)
+			c.subscriptions[chat] = struct{}{}
+		}
+	}
+	if len(c.subscriptions) > maxSubscriptions {
+		for _, chat := range added {
+			delete(c.subscriptions, chat)
+		}
+		c.mu.Unlock()
+		return &FrameError{Code: protocol.ErrInvalidPayload,
+			Message: fmt.Sprintf("Sessions may subscribe to at most %d chats", maxSubscriptions)}
+	}
+	chats := c.subscriptionList()
+	c.mu.Unlock()
+
+	for _, chat := range added {
+		if chat.ChatType == "channel" {
+			c.Hub.channels.add(c, chat.ChatID, roleSubscribed)
+		}
+	}
+	c.sendFrame(protocol.TypeSubscriptions, protocol.Subscriptions{Chats: chats})
+	return nil
+}
+
+func handleUnsubscribe(_ context.Context, c *Client, s *protocol.Subscriptions) error {
+	c.mu.Lock()
+	var removed []protocol.ChatRef
+	for _, chat := range s.Chats {
+		if _, ok := c.subscriptions[chat]; ok {
+			removed = append(removed, chat)
+			delete(c.subscriptions, chat)
+		}
+	}
+	chats := c.subscriptionList()
+	c.mu.Unlock()
+
+	for _, chat := range removed {
+		if chat.ChatType == "channel" {
+			c.Hub.channels.clear(c, chat.ChatID, roleSubscribed)
+		}
+	}
+	c.sendFrame(protocol.TypeSubscriptions, protocol.Subscriptions{Chats: chats})
+	return nil
+}
+
+// subscribed reports whether the session subscribed to a chat. The caller
+// holds c.mu.
+func (c *Client) subscribed(chatType, chatID string) bool {
+	_, ok := c.subscriptions[protocol.ChatRef{ChatType: chatType, ChatID: chatID}]
+	return ok
+}
+
+// subscriptionList returns the subscriptions of the session in a stable
+// order. The caller holds c.mu.
+func (c *Client) subscriptionList() []protocol.ChatRef {
+	chats := make([]protocol.ChatRef, 0, len(c.subscriptions))
+	for chat := range c.subscriptions {
+		chats = append(chats, chat)
+	}
+	slices.SortFunc(chats, func(a, b protocol.ChatRef) int {
+		if n := strings.Compare(a.ChatType, b.ChatType); n != 0 {
+			return n
+		}
+		return strings.Compare(a.ChatID, b.ChatID)
+	})
+	return chats
+}
@@ -0,0 +1,75 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"testing"
+
+	"servit-go/internal/models"
+	"servit-go/internal/protocol"
+)
+
+func TestSessionsOnlyReceiveChatsTheyMayRead(t *testing.T) {
+	const (
+		user    = "3b0e6f7a-2c1d-4e5f-9a8b-7c6d5e4f3a2b"
+		open    = "9f1c2a64-5b1e-4c47-8d7e-3a2f6b0c9d11"
+		private = "5d2e8b1c-7a3f-4b6e-8c9d-0e1f2a3b4c5d"
+	)
+	hub := NewHub(slog.New(slog.NewTextHandler(io.Discard, nil)), HubOptions{
+		ChannelAccess: func(_ context.Context, userID, channelID string) error {
+			if channelID == private {
+				return errors.New("not a member")
+			}
+			return nil
+		},
+	})
+	c := testClient(hub, user, "s1")
+	send := func(frameType, chatType, chatID string) *string {
+		ref := protocol.ChatRef{ChatType: chatType, ChatID: chatID}
+		var data any = protocol.Subscriptions{Chats: []protocol.ChatRef{ref}}
+		if frameType == protocol.TypeSwitchChat {
+			data = ref
+		}
+		raw, err := json.Marshal(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if event := hub.Frames.Process(context.Background(), c, models.WSMessage{Type: frameType, Data: raw}); event != nil {
+			return &event.Code
+		}
+		return nil
+	}
+
+	for _, tc := range []struct {
+		frameType, chatType, chatID string
+		code                        string // "" when accepted
+	}{
+		{protocol.TypeSubscribe, "channel", open, ""},
+		{protocol.TypeSubscribe, "channel", private, protocol.ErrForbidden},
+		{protocol.TypeSubscribe, "channel", "general", protocol.ErrInvalidPayload},
+		{protocol.TypeSubscribe, "dm", user, protocol.ErrForbidden},
+		{protocol.TypeSwitchChat, "channel", private, protocol.ErrForbidden},
+		{protocol.TypeSwitchChat, "dm", user, protocol.ErrForbidden},
+		{protocol.TypeSwitchChat, "channel", open, ""},
+	} {
+		code := send(tc.frameType, tc.chatType, tc.chatID)
+		if (code == nil) != (tc.code == "") || code != nil && *code != tc.code {
+			t.Errorf("%s %s %s: got error %v, want %q", tc.frameType, tc.chatType, tc.chatID, code, tc.code)
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.subscribed("channel", private) || c.subscribed("dm", user) || len(c.subscriptions) != 1 {
+		t.Errorf("unexpected subscriptions %v", c.subscriptionList())
+	}
+	if c.ActiveChat.ChatID != open {
+		t.Errorf("viewing %s, want %s", c.ActiveChat.ChatID, open)
+	}
+	if sessions := hub.channels.subscribers(private); len(sessions) != 0 {
+		t.Errorf("%d sessions indexed for the private channel", len(sessions))
+	}
+}
@@ -0,0 +1,307 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"net/http"
+	"sync"
+	"time"
+
+	"servit-go/internal/logging"
+	"servit-go/internal/metrics"
+)
+
+// Defaults of Options.
+const (
+	DefaultWorkers     = 4
+	DefaultTimeout     = 10 * time.Second
+	DefaultMaxAttempts = 8
+	DefaultBackoff     = 10 * time.Second
+	DefaultMaxBackoff  = time.Hour
+)
+
+// eventBuffer bounds the events waiting to be matched with webhooks. Events
+// published while it is full are dropped.
+const eventBuffer = 1024
+
+// ErrPending is returned by Redeliver for deliveries still being attempted.
+var ErrPending = errors.New("delivery is still pending")
+
+// Options configures the delivery of webhooks.
+type Options struct {
+	Workers     int           // concurrent deliveries
+	Timeout     time.Duration // of each attempt
+	MaxAttempts int           // before a delivery is dead
+	// Backoff is the delay before the first retry, doubled after each
+	// failed attempt up to MaxBackoff.
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	Policy     Policy
+	Client     *http.Client // defaults to Policy.Client()
+}
+
+// Dispatcher delivers the events published to it to the webhooks of their
+// channel.
+type Dispatcher struct {
+	Options
+	store        Store
+	logger       *slog.Logger
+	events       chan Event
+	redeliveries chan Delivery
+	queue        chan Delivery
+}
+
+func NewDispatcher(store Store, logger *slog.Logger, opts Options) *Dispatcher {
+	if opts.Workers <= 0 {
+		opts.Workers = DefaultWorkers
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = DefaultTimeout
+	}
+	if opts.MaxAttempts <= 0 {
+		opts.MaxAttempts = DefaultMaxAttempts
+	}
+	if opts.Backoff <= 0 {
+		opts.Backoff = DefaultBackoff
+	}
+	if opts.MaxBackoff < opts.Backoff {
+		opts.MaxBackoff = max(DefaultMaxBackoff, opts.Backoff)
+	}
+	if opts.Client == nil {
+		opts.Client = opts.Policy.Client()
+	}
+	return &Dispatcher{
+		Options:      opts,
+		store:        store,
+		logger:       logger,
+		events:       make(chan Event, eventBuffer),
+		redeliveries: make(chan Delivery),
+		queue:        make(chan Delivery, opts.Workers),
+	}
+}
+
+// Run delivers events until ctx is done. Deliveries left pending by a
+// previous run are resumed first, so with a shared store every node
+// resumes them and receivers may get a delivery twice; they can tell by
+// the X-Servit-Delivery header.
+func (d *Dispatcher) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for range d.Workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case del := <-d.queue:
+					d.attempt(ctx, del)
+				}
+			}
+		}()
+	}
+
+	pending, err := d.store.PendingDeliveries(ctx)
+	if err != nil {
+		d.logger.Error("failed to load pending webhook deliveries", "error", err)
+	}
+	for _, del := range pending {
+		d.schedule(ctx, del)
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			wg.Wait()
+			return
+		case event := <-d.events:
+			d.fanOut(ctx, event)
+		case del := <-d.redeliveries:
+			d.schedule(ctx, del)
+		}
+	}
+}
+
+// Publish queues an event for the webhooks of its channel without waiting.
+func (d *Dispatcher) Publish(event Event) {
+	if event.ID == "" {
+		event.ID = logging.NewID()
+	}
+	if event.OccurredAt.IsZero() {
+		event.OccurredAt = time.Now()
+	}
+	select {
+	case d.events <- event:
+	default:
+		metrics.WebhookDeliveries.WithLabelValues("dropped").Inc()
+		d.logger.Warn("webhook event dropped, too many events queued", "event", event.Type, "channel_id", event.ChannelID)
+	}
+}
+
+// PublishFrame publishes the event of a frame broadcast to a channel, if
+// webhooks are sent for it. It has the signature of
+// services.HubOptions.ChannelEvents.
+func (d *Dispatcher) PublishFrame(_ context.Context, frameType, channelID string, data any) {
+	if event, ok := FrameEvent(frameType); ok {
+		d.Publish(Event{Type: event, ChannelID: channelID, Data: data})
+	}
+}
+
+// fanOut creates the deliveries of an event.
+func (d *Dispatcher) fanOut(ctx context.Context, event Event) {
+	webhooks, err := d.store.ChannelWebhooks(ctx, event.ChannelID)
+	if err != nil {
+		d.logger.Error("failed to look up webhooks", "channel_id", event.ChannelID, "error", err)
+		return
+	}
+	var payload []byte
+	for _, w := range webhooks {
+		if !w.Subscribes(event.Type) {
+			continue
+		}
+		if payload == nil {
+			if payload, err = json.Marshal(event); err != nil {
+				d.logger.Error("failed to encode webhook event", "event", event.Type, "error", err)
+				return
+			}
+		}
+		now := time.Now()
+		del := Delivery{
+			ID:            logging.NewID(),
+			WebhookID:     w.ID,
+			Event:         event.Type,
+			Payload:       payload,
+			Status:        StatusPending,
+			NextAttemptAt: now,
+			CreatedAt:     now,
+			UpdatedAt:     now,
+		}
+		if err := d.store.SaveDelivery(ctx, &del); err != nil {
+			d.logger.Error("failed to save webhook delivery", "webhook_id", w.ID, "error", err)
+			continue
+		}
+		d.schedule(ctx, del)
+	}
+}
+
+// schedule queues a delivery for its next attempt. It never blocks: the
+// delivery is already saved, and it waits for a worker in a goroutine of its
+// own so that Run keeps taking events while every worker is busy.
+func (d *Dispatcher) schedule(ctx context.Context, del Delivery) {
+	time.AfterFunc(time.Until(del.NextAttemptAt), func() {
+		select {
+		case d.queue <- del:
+		case <-ctx.Done():
+		}
+	})
+}
+
+// attempt makes one attempt at a delivery and records its outcome.
+func (d *Dispatcher) attempt(ctx context.Context, del Delivery) {
+	w, err := d.store.Webhook(ctx, del.WebhookID)
+	if errors.Is(err, ErrNotFound) {
+		return // deleted since
+	}
+	logger := d.logger.With("webhook_id", del.WebhookID, "delivery_id", del.ID)
+	if err == nil {
+		del.ResponseStatus, err = d.send(ctx, w, &del)
+	}
+	if ctx.Err() != nil {
+		return // left pending for the next run
+	}
+
+	now := time.Now()
+	del.Attempts++
+	del.UpdatedAt = now
+	del.LastError = ""
+	result := StatusDelivered
+	switch {
+	case err == nil:
+		del.Status = StatusDelivered
+	case del.Attempts >= d.MaxAttempts:
+		del.Status = StatusDead
+		del.LastError = errorClass(err)
+		result = StatusDead
+		logger.Warn("webhook delivery failed for good", "attempts", del.Attempts, "error", err)
+	default:
+		del.LastError = errorClass(err)
+		del.NextAttemptAt = now.Add(d.backoff(del.Attempts))
+		result = "retried"
+		logger.Info("webhook delivery failed, retrying", "attempts", del.Attempts, "retry_at", del.NextAttemptAt, "error", err)
+	}
+	metrics.WebhookDeliveries.WithLabelValues(result).Inc()
+	if err := d.store.SaveDelivery(ctx, &del); err != nil {
+		logger.Error("failed to save webhook delivery", "error", err)
+	}
+	if del.Status == StatusPending {
+		d.schedule(ctx, del)
+	}
+}
+
+// send posts the payload of a delivery to the webhook and returns the
+// response status. Statuses other than 2xx are errors.
+func (d *Dispatcher) send(ctx context.Context, w *Webhook, del *Delivery) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, d.Timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.URL, bytes.NewReader(del.Payload))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "servit-webhooks")
+	req.Header.Set(SignatureHeader, Sign(w.Secret, time.Now(), del.Payload))
+	req.Header.Set(EventHeader, del.Event)
+	req.Header.Set(DeliveryHeader, del.ID)
+
+	resp, err := d.Client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10)) // lets the connection be reused
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, &statusError{code: resp.StatusCode}
+	}
+	return resp.StatusCode, nil
+}
+
+// backoff returns the delay after a failed attempt, with up to 10% jitter
+// so that the retries of an outage are spread out.
+func (d *Dispatcher) backoff(attempts int) time.Duration {
+	delay := d.MaxBackoff
+	if shift := attempts - 1; shift < 32 && d.Backoff<<shift < d.MaxBackoff {
+		delay = d.Backoff << shift
+	}
+	return delay + rand.N(delay/10+1)
+}
+
+// Redeliver attempts a delivered or dead delivery again, with a fresh set of
+// attempts.
+func (d *Dispatcher) Redeliver(ctx context.Context, webhookID, id string) (*Delivery, error) {
+	del, err := d.store.Delivery(ctx, webhookID, id)
+	if err != nil {
+		return nil, err
+	}
+	if del.Status == StatusPending {
+		return nil, ErrPending
+	}
+	now := time.Now()
+	del.Status = StatusPending
+	del.Attempts = 0
+	del.NextAttemptAt = now
+	del.UpdatedAt = now
+	if err := d.store.SaveDelivery(ctx, del); err != nil {
+		return nil, err
+	}
+	// If the request goes away first, the delivery is resumed by the next
+	// run.
+	select {
+	case d.redeliveries <- *del:
+	case <-ctx.Done():
+	}
+	return del, nil
+}
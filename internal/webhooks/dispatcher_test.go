@@ -0,0 +1,300 @@
+package webhooks
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"servit-go/internal/protocol"
+)
+
+const testBackoff = 20 * time.Millisecond
+
+// request is a delivery request received by a receiver.
+type request struct {
+	header http.Header
+	body   []byte
+	at     time.Time
+}
+
+// receiver is a webhook endpoint answering with a settable status.
+type receiver struct {
+	*httptest.Server
+	mu       sync.Mutex
+	status   int
+	requests []request
+}
+
+func newReceiver(t *testing.T, status int) *receiver {
+	r := &receiver{status: status}
+	r.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := io.ReadAll(req.Body)
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		r.requests = append(r.requests, request{header: req.Header.Clone(), body: body, at: time.Now()})
+		w.WriteHeader(r.status)
+	}))
+	t.Cleanup(r.Close)
+	return r
+}
+
+func (r *receiver) setStatus(status int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.status = status
+}
+
+func (r *receiver) received() []request {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]request(nil), r.requests...)
+}
+
+// startDispatcher runs a dispatcher with a webhook of the general channel
+// pointing at r, retrying after testBackoff.
+func startDispatcher(t *testing.T, r *receiver, opts Options) (*Dispatcher, *MemoryStore, *Webhook) {
+	t.Helper()
+	store := NewMemoryStore()
+	w := &Webhook{ID: "hook", ChannelID: "general", OwnerID: "owner", URL: r.URL, CreatedAt: time.Now()}
+	if err := w.Validate(Policy{Insecure: true}); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.CreateWebhook(context.Background(), w); err != nil {
+		t.Fatal(err)
+	}
+	// httptest receivers listen on loopback.
+	opts.Policy = Policy{Insecure: true}
+	opts.Backoff = testBackoff
+	opts.MaxBackoff = 4 * testBackoff
+	d := NewDispatcher(store, slog.New(slog.NewTextHandler(io.Discard, nil)), opts)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		d.Run(ctx)
+		close(done)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+	return d, store, w
+}
+
+// waitForDelivery waits until the only delivery of the webhook has status.
+func waitForDelivery(t *testing.T, store *MemoryStore, webhookID, status string) Delivery {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		deliveries, _ := store.Deliveries(context.Background(), webhookID, "", 10)
+		if len(deliveries) > 1 {
+			t.Fatalf("expected one delivery, got %d", len(deliveries))
+		}
+		if len(deliveries) == 1 && deliveries[0].Status == status {
+			return deliveries[0]
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("delivery never became %s: %+v", status, deliveries)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestDeliveryIsSigned(t *testing.T) {
+	r := newReceiver(t, http.StatusNoContent)
+	d, store, w := startDispatcher(t, r, Options{MaxAttempts: 3})
+
+	d.PublishFrame(context.Background(), protocol.TypeChannelMessage, "general", map[string]string{"content": "hello"})
+	d.PublishFrame(context.Background(), protocol.TypeChannelMessage, "random", map[string]string{"content": "elsewhere"})
+	del := waitForDelivery(t, store, w.ID, StatusDelivered)
+
+	requests := r.received()
+	if len(requests) != 1 {
+		t.Fatalf("expected one request, got %d", len(requests))
+	}
+	req := requests[0]
+	if err := Verify(w.Secret, req.header.Get(SignatureHeader), req.body, time.Minute); err != nil {
+		t.Errorf("signature rejected: %v", err)
+	}
+	if got := req.header.Get(EventHeader); got != EventMessageCreated {
+		t.Errorf("event header is %q", got)
+	}
+	if got := req.header.Get(DeliveryHeader); got != del.ID {
+		t.Errorf("delivery header is %q, want %q", got, del.ID)
+	}
+	var event Event
+	if err := json.Unmarshal(req.body, &event); err != nil {
+		t.Fatal(err)
+	}
+	if event.Type != EventMessageCreated || event.ChannelID != "general" || event.ID == "" {
+		t.Errorf("unexpected event %+v", event)
+	}
+	if del.Attempts != 1 || del.ResponseStatus != http.StatusNoContent {
+		t.Errorf("unexpected delivery %+v", del)
+	}
+}
+
+func TestFailedDeliveriesAreRetriedWithBackoff(t *testing.T) {
+	r := newReceiver(t, http.StatusInternalServerError)
+	d, store, w := startDispatcher(t, r, Options{MaxAttempts: 5})
+
+	d.Publish(Event{Type: EventMessageCreated, ChannelID: "general"})
+	deadline := time.Now().Add(5 * time.Second)
+	for len(r.received()) < 3 {
+		if time.Now().After(deadline) {
+			t.Fatal("delivery not retried")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	r.setStatus(http.StatusOK)
+	del := waitForDelivery(t, store, w.ID, StatusDelivered)
+
+	requests := r.received()
+	if del.Attempts != len(requests) || del.Attempts < 4 {
+		t.Errorf("%d attempts recorded for %d requests", del.Attempts, len(requests))
+	}
+	for i := 1; i < len(requests); i++ {
+		want := min(testBackoff<<(i-1), 4*testBackoff)
+		if gap := requests[i].at.Sub(requests[i-1].at); gap < want {
+			t.Errorf("retry %d after %v, want at least %v", i, gap, want)
+		}
+		if requests[i].header.Get(DeliveryHeader) != del.ID {
+			t.Errorf("retry %d has another delivery ID", i)
+		}
+	}
+	if del.LastError != "" {
+		t.Errorf("last error %q kept after success", del.LastError)
+	}
+}
+
+func TestSlowReceiverDoesNotDropEvents(t *testing.T) {
+	release := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		<-release
+	}))
+	t.Cleanup(slow.Close)
+	t.Cleanup(func() { close(release) })
+	d, store, _ := startDispatcher(t, &receiver{Server: slow}, Options{Workers: 1, Timeout: time.Minute})
+
+	// More events than the buffer holds, while the only worker is stuck.
+	const events = eventBuffer + eventBuffer/2
+	deadline := time.Now().Add(5 * time.Second)
+	for i := range events {
+		for len(d.events) == cap(d.events) {
+			if time.Now().After(deadline) {
+				t.Fatalf("event loop stalled after %d events", i)
+			}
+			time.Sleep(time.Millisecond)
+		}
+		d.Publish(Event{Type: EventMessageCreated, ChannelID: "general"})
+	}
+	for {
+		pending, _ := store.PendingDeliveries(context.Background())
+		if len(pending) == events {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d of %d deliveries saved", len(pending), events)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestInternalDestinationsAreRefusedWhenDialing(t *testing.T) {
+	r := newReceiver(t, http.StatusOK)
+	store := NewMemoryStore()
+	// Stored as if the name had resolved to a public address when the
+	// webhook was registered.
+	w := &Webhook{ID: "hook", ChannelID: "general", OwnerID: "owner", URL: r.URL, Secret: "secret", Events: Events}
+	if err := store.CreateWebhook(context.Background(), w); err != nil {
+		t.Fatal(err)
+	}
+	d := NewDispatcher(store, slog.New(slog.NewTextHandler(io.Discard, nil)), Options{MaxAttempts: 1})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go d.Run(ctx)
+
+	d.Publish(Event{Type: EventMessageCreated, ChannelID: "general"})
+	dead := waitForDelivery(t, store, w.ID, StatusDead)
+	if dead.LastError != ErrDestinationNotAllowed.Error() {
+		t.Errorf("last error is %q", dead.LastError)
+	}
+	if n := len(r.received()); n != 0 {
+		t.Errorf("loopback receiver got %d requests", n)
+	}
+}
+
+func TestErrorClassHidesTransportErrors(t *testing.T) {
+	// Nothing listens on the port once the server is closed.
+	r := newReceiver(t, http.StatusOK)
+	r.Close()
+	d := NewDispatcher(NewMemoryStore(), slog.Default(), Options{Policy: Policy{Insecure: true}})
+	_, err := d.send(context.Background(), &Webhook{URL: r.URL, Secret: "secret"}, &Delivery{Payload: []byte("{}")})
+	if err == nil {
+		t.Fatal("delivery to a closed port succeeded")
+	}
+	if got := errorClass(err); got != "connection failed" {
+		t.Errorf("error class of %v is %q", err, got)
+	}
+	if got := errorClass(&statusError{code: http.StatusTeapot}); got != "unexpected status 418" {
+		t.Errorf("error class of a status is %q", got)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	d := NewDispatcher(NewMemoryStore(), slog.Default(), Options{Backoff: time.Second, MaxBackoff: 10 * time.Second})
+	for attempts, want := range map[int]time.Duration{
+		1:  time.Second,
+		2:  2 * time.Second,
+		4:  8 * time.Second,
+		5:  10 * time.Second,
+		64: 10 * time.Second,
+	} {
+		if got := d.backoff(attempts); got < want || got > want+want/10 {
+			t.Errorf("backoff after %d attempts is %v, want %v plus up to 10%%", attempts, got, want)
+		}
+	}
+}
+
+func TestDeadLetterAndRedelivery(t *testing.T) {
+	r := newReceiver(t, http.StatusBadGateway)
+	d, store, w := startDispatcher(t, r, Options{MaxAttempts: 2})
+
+	d.Publish(Event{Type: EventMessageCreated, ChannelID: "general"})
+	dead := waitForDelivery(t, store, w.ID, StatusDead)
+	if dead.Attempts != 2 || dead.ResponseStatus != http.StatusBadGateway || dead.LastError == "" {
+		t.Errorf("unexpected dead letter %+v", dead)
+	}
+	if letters, _ := store.Deliveries(context.Background(), w.ID, StatusDead, 10); len(letters) != 1 {
+		t.Errorf("expected one dead letter, got %d", len(letters))
+	}
+	time.Sleep(4 * testBackoff)
+	if n := len(r.received()); n != 2 {
+		t.Fatalf("dead delivery attempted %d times", n)
+	}
+
+	r.setStatus(http.StatusOK)
+	if _, err := d.Redeliver(context.Background(), w.ID, "unknown"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("redelivering an unknown delivery: %v", err)
+	}
+	if _, err := d.Redeliver(context.Background(), w.ID, dead.ID); err != nil {
+		t.Fatal(err)
+	}
+	del := waitForDelivery(t, store, w.ID, StatusDelivered)
+	if del.ID != dead.ID || del.Attempts != 1 {
+		t.Errorf("unexpected redelivery %+v", del)
+	}
+	requests := r.received()
+	if len(requests) != 3 || requests[2].header.Get(DeliveryHeader) != dead.ID {
+		t.Errorf("redelivery not sent with the same delivery ID")
+	}
+	if _, err := d.Redeliver(context.Background(), "other", dead.ID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("redelivering through another webhook: %v", err)
+	}
+}
@@ -0,0 +1,179 @@
+package webhooks
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"time"
+)
+
+// memoryDeliveries bounds the deliveries kept per webhook by MemoryStore;
+// the oldest finished ones are dropped first.
+const memoryDeliveries = 1000
+
+// MemoryStore keeps webhooks in process memory. Webhooks are per node and
+// pending deliveries are lost on restart.
+type MemoryStore struct {
+	owners     map[string]string // channel ID -> owner ID
+	webhooks   map[string]Webhook
+	deliveries map[string][]Delivery // webhook ID -> deliveries, oldest first
+	mu         sync.Mutex
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		owners:     make(map[string]string),
+		webhooks:   make(map[string]Webhook),
+		deliveries: make(map[string][]Delivery),
+	}
+}
+
+// SetChannelOwner implements Store.
+func (s *MemoryStore) SetChannelOwner(_ context.Context, channelID, ownerID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.owners[channelID] = ownerID
+	return nil
+}
+
+// ChannelOwner implements Store.
+func (s *MemoryStore) ChannelOwner(_ context.Context, channelID string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	owner, ok := s.owners[channelID]
+	if !ok {
+		return "", ErrNotFound
+	}
+	return owner, nil
+}
+
+// CreateWebhook implements Store.
+func (s *MemoryStore) CreateWebhook(_ context.Context, w *Webhook) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stored := *w
+	stored.Events = slices.Clone(w.Events)
+	s.webhooks[w.ID] = stored
+	return nil
+}
+
+// Webhook implements Store.
+func (s *MemoryStore) Webhook(_ context.Context, id string) (*Webhook, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	w, ok := s.webhooks[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &w, nil
+}
+
+// ChannelWebhooks implements Store.
+func (s *MemoryStore) ChannelWebhooks(_ context.Context, channelID string) ([]Webhook, error) {
+	return s.filter(func(w *Webhook) bool { return w.ChannelID == channelID }), nil
+}
+
+// OwnerWebhooks implements Store.
+func (s *MemoryStore) OwnerWebhooks(_ context.Context, ownerID string) ([]Webhook, error) {
+	return s.filter(func(w *Webhook) bool { return w.OwnerID == ownerID }), nil
+}
+
+func (s *MemoryStore) filter(match func(*Webhook) bool) []Webhook {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var webhooks []Webhook
+	for _, w := range s.webhooks {
+		if match(&w) {
+			webhooks = append(webhooks, w)
+		}
+	}
+	slices.SortFunc(webhooks, func(a, b Webhook) int { return a.CreatedAt.Compare(b.CreatedAt) })
+	return webhooks
+}
+
+// DeleteWebhook implements Store.
+func (s *MemoryStore) DeleteWebhook(_ context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.webhooks[id]; !ok {
+		return ErrNotFound
+	}
+	delete(s.webhooks, id)
+	delete(s.deliveries, id)
+	return nil
+}
+
+// SaveDelivery implements Store. Deliveries of deleted webhooks are
+// dropped.
+func (s *MemoryStore) SaveDelivery(_ context.Context, d *Delivery) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.webhooks[d.WebhookID]; !ok {
+		return nil
+	}
+	deliveries := s.deliveries[d.WebhookID]
+	if i := slices.IndexFunc(deliveries, func(e Delivery) bool { return e.ID == d.ID }); i >= 0 {
+		deliveries[i] = *d
+		return nil
+	}
+	deliveries = append(deliveries, *d)
+	s.deliveries[d.WebhookID] = prune(deliveries, time.Now())
+	return nil
+}
+
+// prune drops delivered deliveries past the retention, then the oldest
+// finished ones beyond memoryDeliveries.
+func prune(deliveries []Delivery, now time.Time) []Delivery {
+	deliveries = slices.DeleteFunc(deliveries, func(d Delivery) bool {
+		return d.Status == StatusDelivered && now.Sub(d.UpdatedAt) > deliveryRetention
+	})
+	for excess := len(deliveries) - memoryDeliveries; excess > 0; excess-- {
+		i := slices.IndexFunc(deliveries, func(d Delivery) bool { return d.Status != StatusPending })
+		if i < 0 {
+			break
+		}
+		deliveries = slices.Delete(deliveries, i, i+1)
+	}
+	return deliveries
+}
+
+// Delivery implements Store.
+func (s *MemoryStore) Delivery(_ context.Context, webhookID, id string) (*Delivery, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, d := range s.deliveries[webhookID] {
+		if d.ID == id {
+			return &d, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+// Deliveries implements Store.
+func (s *MemoryStore) Deliveries(_ context.Context, webhookID, status string, limit int) ([]Delivery, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	all := s.deliveries[webhookID]
+	var deliveries []Delivery
+	for i := len(all) - 1; i >= 0 && len(deliveries) < limit; i-- {
+		if status == "" || all[i].Status == status {
+			deliveries = append(deliveries, all[i])
+		}
+	}
+	return deliveries, nil
+}
+
+// PendingDeliveries implements Store.
+func (s *MemoryStore) PendingDeliveries(context.Context) ([]Delivery, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var pending []Delivery
+	for _, deliveries := range s.deliveries {
+		for _, d := range deliveries {
+			if d.Status == StatusPending {
+				pending = append(pending, d)
+			}
+		}
+	}
+	return pending, nil
+}
@@ -0,0 +1,137 @@
+package webhooks
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// ErrDestinationNotAllowed is returned for endpoints on addresses webhooks
+// are not sent to, see Policy.
+var ErrDestinationNotAllowed = errors.New("webhook destination is not allowed")
+
+// sharedAddressSpace is the carrier-grade NAT range of RFC 6598, internal
+// to many clouds.
+var sharedAddressSpace = netip.MustParsePrefix("100.64.0.0/10")
+
+// Policy restricts the endpoints webhooks are sent to. Channel owners choose
+// the URLs, so by default only https endpoints on public addresses are
+// accepted, and the address is checked again when connecting so that a name
+// resolving to an internal address later is refused too.
+type Policy struct {
+	// Insecure accepts plain http and loopback, private and link-local
+	// addresses, for development.
+	Insecure bool
+}
+
+// CheckURL reports whether webhooks may be sent to raw. Host names are
+// checked once they are resolved, when connecting.
+func (p Policy) CheckURL(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" || u.User != nil {
+		return errors.New("url must be an absolute http or https URL without credentials")
+	}
+	switch {
+	case u.Scheme == "https":
+	case u.Scheme == "http" && p.Insecure:
+	default:
+		return errors.New("url must be an https URL")
+	}
+	host := u.Hostname()
+	if strings.EqualFold(host, "localhost") || strings.HasSuffix(strings.ToLower(host), ".localhost") {
+		return p.checkAddr(netip.IPv6Loopback())
+	}
+	if addr, err := netip.ParseAddr(host); err == nil {
+		return p.checkAddr(addr)
+	}
+	return nil
+}
+
+func (p Policy) checkAddr(addr netip.Addr) error {
+	if p.Insecure {
+		return nil
+	}
+	addr = addr.Unmap()
+	if addr.IsLoopback() || addr.IsPrivate() || addr.IsLinkLocalUnicast() || addr.IsLinkLocalMulticast() ||
+		addr.IsInterfaceLocalMulticast() || addr.IsMulticast() || addr.IsUnspecified() ||
+		sharedAddressSpace.Contains(addr) {
+		return ErrDestinationNotAllowed
+	}
+	return nil
+}
+
+// control is the net.Dialer Control function checking the address actually
+// dialed, after name resolution.
+func (p Policy) control(_, address string, _ syscall.RawConn) error {
+	addrPort, err := netip.ParseAddrPort(address)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrDestinationNotAllowed, address)
+	}
+	return p.checkAddr(addrPort.Addr())
+}
+
+// Client returns an HTTP client that only connects to addresses allowed by
+// the policy. It ignores proxy settings, which would hide the address, and
+// doesn't follow redirects, which would skip the URL check.
+func (p Policy) Client() *http.Client {
+	dialer := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second, Control: p.control}
+	return &http.Client{
+		Transport: &http.Transport{
+			DialContext:         dialer.DialContext,
+			ForceAttemptHTTP2:   true,
+			MaxIdleConns:        100,
+			IdleConnTimeout:     90 * time.Second,
+			TLSHandshakeTimeout: 10 * time.Second,
+		},
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// errorClass describes a failed attempt in the delivery log without the
+// transport error itself, which would tell owners about the network the
+// server runs in.
+func errorClass(err error) string {
+	var (
+		netErr net.Error
+		tlsErr *tls.CertificateVerificationError
+		recErr tls.RecordHeaderError
+		dnsErr *net.DNSError
+		opErr  *net.OpError
+		status *statusError
+	)
+	switch {
+	case errors.As(err, &status):
+		return status.Error()
+	case errors.Is(err, ErrDestinationNotAllowed):
+		return ErrDestinationNotAllowed.Error()
+	case errors.Is(err, context.DeadlineExceeded) || errors.As(err, &netErr) && netErr.Timeout():
+		return "request timed out"
+	case errors.As(err, &dnsErr):
+		return "host not found"
+	case errors.As(err, &tlsErr) || errors.As(err, &recErr):
+		return "TLS handshake failed"
+	case errors.As(err, &opErr):
+		return "connection failed"
+	default:
+		return "request failed"
+	}
+}
+
+// statusError is the error of a response other than 2xx.
+type statusError struct {
+	code int
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("unexpected status %d", e.code)
+}
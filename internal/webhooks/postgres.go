@@ -0,0 +1,259 @@
+package webhooks
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+const webhookColumns = `id, channel_id, owner_id, url, secret, events, created_at`
+
+const deliveryColumns = `id, webhook_id, event, payload, status, attempts, response_status,
+	last_error, next_attempt_at, created_at, updated_at`
+
+// saveDeliveryQuery upserts a delivery, skipping deliveries of deleted
+// webhooks.
+const saveDeliveryQuery = `
+INSERT INTO webhook_deliveries (` + deliveryColumns + `)
+SELECT $1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11
+WHERE EXISTS (SELECT 1 FROM webhooks WHERE id = $2)
+ON CONFLICT (id) DO UPDATE SET
+	status = EXCLUDED.status,
+	attempts = EXCLUDED.attempts,
+	response_status = EXCLUDED.response_status,
+	last_error = EXCLUDED.last_error,
+	next_attempt_at = EXCLUDED.next_attempt_at,
+	updated_at = EXCLUDED.updated_at`
+
+// PostgresStore keeps webhooks in PostgreSQL, shared by every node.
+type PostgresStore struct {
+	DB *sql.DB
+
+	pruneMu sync.Mutex
+	pruned  time.Time
+}
+
+// NewPostgresStore creates the webhook tables if needed and returns the
+// store.
+func NewPostgresStore(db *sql.DB) (*PostgresStore, error) {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS channel_owners (
+		channel_id text PRIMARY KEY,
+		owner_id   text NOT NULL,
+		updated_at timestamptz NOT NULL
+	)`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create channel_owners table: %w", err)
+	}
+	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS webhooks (
+		id         text PRIMARY KEY,
+		channel_id text NOT NULL,
+		owner_id   text NOT NULL,
+		url        text NOT NULL,
+		secret     text NOT NULL,
+		events     text[] NOT NULL,
+		created_at timestamptz NOT NULL
+	)`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create webhooks table: %w", err)
+	}
+	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS webhook_deliveries (
+		id              text PRIMARY KEY,
+		webhook_id      text NOT NULL REFERENCES webhooks (id) ON DELETE CASCADE,
+		event           text NOT NULL,
+		payload         jsonb NOT NULL,
+		status          text NOT NULL,
+		attempts        integer NOT NULL,
+		response_status integer NOT NULL,
+		last_error      text NOT NULL,
+		next_attempt_at timestamptz NOT NULL,
+		created_at      timestamptz NOT NULL,
+		updated_at      timestamptz NOT NULL
+	)`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create webhook_deliveries table: %w", err)
+	}
+	for _, index := range []string{
+		`CREATE INDEX IF NOT EXISTS webhooks_channel_id ON webhooks (channel_id)`,
+		`CREATE INDEX IF NOT EXISTS webhooks_owner_id ON webhooks (owner_id)`,
+		`CREATE INDEX IF NOT EXISTS webhook_deliveries_log ON webhook_deliveries (webhook_id, created_at)`,
+		`CREATE INDEX IF NOT EXISTS webhook_deliveries_pending ON webhook_deliveries (status) WHERE status = 'pending'`,
+	} {
+		if _, err := db.Exec(index); err != nil {
+			return nil, fmt.Errorf("failed to index webhook tables: %w", err)
+		}
+	}
+	return &PostgresStore{DB: db}, nil
+}
+
+// SetChannelOwner implements Store.
+func (s *PostgresStore) SetChannelOwner(ctx context.Context, channelID, ownerID string) error {
+	_, err := s.DB.ExecContext(ctx, `INSERT INTO channel_owners (channel_id, owner_id, updated_at) VALUES ($1, $2, $3)
+		ON CONFLICT (channel_id) DO UPDATE SET owner_id = EXCLUDED.owner_id, updated_at = EXCLUDED.updated_at`,
+		channelID, ownerID, time.Now())
+	if err != nil {
+		return fmt.Errorf("failed to set channel owner: %w", err)
+	}
+	return nil
+}
+
+// ChannelOwner implements Store.
+func (s *PostgresStore) ChannelOwner(ctx context.Context, channelID string) (string, error) {
+	var owner string
+	err := s.DB.QueryRowContext(ctx, `SELECT owner_id FROM channel_owners WHERE channel_id = $1`, channelID).Scan(&owner)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", ErrNotFound
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to look up channel owner: %w", err)
+	}
+	return owner, nil
+}
+
+// CreateWebhook implements Store.
+func (s *PostgresStore) CreateWebhook(ctx context.Context, w *Webhook) error {
+	_, err := s.DB.ExecContext(ctx, `INSERT INTO webhooks (`+webhookColumns+`) VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+		w.ID, w.ChannelID, w.OwnerID, w.URL, w.Secret, pq.Array(w.Events), w.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to create webhook: %w", err)
+	}
+	return nil
+}
+
+// Webhook implements Store.
+func (s *PostgresStore) Webhook(ctx context.Context, id string) (*Webhook, error) {
+	webhooks, err := s.queryWebhooks(ctx, `WHERE id = $1`, id)
+	if err != nil {
+		return nil, err
+	}
+	if len(webhooks) == 0 {
+		return nil, ErrNotFound
+	}
+	return &webhooks[0], nil
+}
+
+// ChannelWebhooks implements Store.
+func (s *PostgresStore) ChannelWebhooks(ctx context.Context, channelID string) ([]Webhook, error) {
+	return s.queryWebhooks(ctx, `WHERE channel_id = $1 ORDER BY created_at`, channelID)
+}
+
+// OwnerWebhooks implements Store.
+func (s *PostgresStore) OwnerWebhooks(ctx context.Context, ownerID string) ([]Webhook, error) {
+	return s.queryWebhooks(ctx, `WHERE owner_id = $1 ORDER BY created_at`, ownerID)
+}
+
+func (s *PostgresStore) queryWebhooks(ctx context.Context, where string, args ...any) ([]Webhook, error) {
+	rows, err := s.DB.QueryContext(ctx, `SELECT `+webhookColumns+` FROM webhooks `+where, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query webhooks: %w", err)
+	}
+	defer rows.Close()
+	var webhooks []Webhook
+	for rows.Next() {
+		var w Webhook
+		err := rows.Scan(&w.ID, &w.ChannelID, &w.OwnerID, &w.URL, &w.Secret, pq.Array(&w.Events), &w.CreatedAt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read webhook: %w", err)
+		}
+		webhooks = append(webhooks, w)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read webhooks: %w", err)
+	}
+	return webhooks, nil
+}
+
+// DeleteWebhook implements Store.
+func (s *PostgresStore) DeleteWebhook(ctx context.Context, id string) error {
+	res, err := s.DB.ExecContext(ctx, `DELETE FROM webhooks WHERE id = $1`, id)
+	if err != nil {
+		return fmt.Errorf("failed to delete webhook: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// SaveDelivery implements Store. Delivered deliveries past the retention
+// are pruned at most once an hour.
+func (s *PostgresStore) SaveDelivery(ctx context.Context, d *Delivery) error {
+	if err := s.prune(ctx); err != nil {
+		return err
+	}
+	_, err := s.DB.ExecContext(ctx, saveDeliveryQuery,
+		d.ID, d.WebhookID, d.Event, []byte(d.Payload), d.Status, d.Attempts, d.ResponseStatus,
+		d.LastError, d.NextAttemptAt, d.CreatedAt, d.UpdatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to save webhook delivery: %w", err)
+	}
+	return nil
+}
+
+func (s *PostgresStore) prune(ctx context.Context) error {
+	s.pruneMu.Lock()
+	defer s.pruneMu.Unlock()
+	if time.Since(s.pruned) < time.Hour {
+		return nil
+	}
+	_, err := s.DB.ExecContext(ctx, `DELETE FROM webhook_deliveries WHERE status = $1 AND updated_at < $2`,
+		StatusDelivered, time.Now().Add(-deliveryRetention))
+	if err != nil {
+		return fmt.Errorf("failed to prune webhook deliveries: %w", err)
+	}
+	s.pruned = time.Now()
+	return nil
+}
+
+// Delivery implements Store.
+func (s *PostgresStore) Delivery(ctx context.Context, webhookID, id string) (*Delivery, error) {
+	deliveries, err := s.queryDeliveries(ctx, `WHERE webhook_id = $1 AND id = $2`, webhookID, id)
+	if err != nil {
+		return nil, err
+	}
+	if len(deliveries) == 0 {
+		return nil, ErrNotFound
+	}
+	return &deliveries[0], nil
+}
+
+// Deliveries implements Store.
+func (s *PostgresStore) Deliveries(ctx context.Context, webhookID, status string, limit int) ([]Delivery, error) {
+	return s.queryDeliveries(ctx, `WHERE webhook_id = $1 AND ($2 = '' OR status = $2)
+		ORDER BY created_at DESC LIMIT $3`, webhookID, status, limit)
+}
+
+// PendingDeliveries implements Store.
+func (s *PostgresStore) PendingDeliveries(ctx context.Context) ([]Delivery, error) {
+	return s.queryDeliveries(ctx, `WHERE status = $1`, StatusPending)
+}
+
+func (s *PostgresStore) queryDeliveries(ctx context.Context, where string, args ...any) ([]Delivery, error) {
+	rows, err := s.DB.QueryContext(ctx, `SELECT `+deliveryColumns+` FROM webhook_deliveries `+where, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query webhook deliveries: %w", err)
+	}
+	defer rows.Close()
+	var deliveries []Delivery
+	for rows.Next() {
+		var (
+			d       Delivery
+			payload []byte
+		)
+		err := rows.Scan(&d.ID, &d.WebhookID, &d.Event, &payload, &d.Status, &d.Attempts, &d.ResponseStatus,
+			&d.LastError, &d.NextAttemptAt, &d.CreatedAt, &d.UpdatedAt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read webhook delivery: %w", err)
+		}
+		d.Payload = payload
+		deliveries = append(deliveries, d)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read webhook deliveries: %w", err)
+	}
+	return deliveries, nil
+}
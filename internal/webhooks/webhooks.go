@@ -0,0 +1,202 @@
+// Package webhooks delivers channel events to HTTP endpoints registered for
+// the channel. Payloads are signed with the webhook's secret and delivered
+// asynchronously, at least once: failed attempts are retried with
+// exponential backoff, and deliveries that keep failing are kept as dead
+// letters until they are redelivered.
+package webhooks
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"servit-go/internal/protocol"
+)
+
+// Event types a webhook can subscribe to.
+const (
+	EventMessageCreated = "message.created"
+)
+
+// Events lists the supported event types. Messages can't be edited or
+// deleted yet, and channels have no member list, so there are no edit,
+// delete or member join events to send; they are to be added along with
+// those features.
+var Events = []string{EventMessageCreated}
+
+// frameEvents maps the frames broadcast to channels to event types.
+var frameEvents = map[string]string{
+	protocol.TypeChannelMessage: EventMessageCreated,
+}
+
+// FrameEvent returns the event type of a frame broadcast to a channel, if
+// webhooks are sent for it.
+func FrameEvent(frameType string) (string, bool) {
+	event, ok := frameEvents[frameType]
+	return event, ok
+}
+
+// Delivery statuses.
+const (
+	StatusPending   = "pending"
+	StatusDelivered = "delivered"
+	StatusDead      = "dead" // out of attempts, kept until redelivered
+)
+
+// Headers of delivery requests.
+const (
+	SignatureHeader = "X-Servit-Signature"
+	EventHeader     = "X-Servit-Event"
+	DeliveryHeader  = "X-Servit-Delivery"
+)
+
+// ErrNotFound is returned for unknown webhooks and deliveries, and for
+// channels without an owner.
+var ErrNotFound = errors.New("webhook or delivery not found")
+
+// Webhook is an endpoint sent the events of a channel.
+type Webhook struct {
+	ID        string    `json:"id"`
+	ChannelID string    `json:"channel_id"`
+	OwnerID   string    `json:"owner_id"`
+	URL       string    `json:"url"`
+	Secret    string    `json:"secret,omitempty"` // only shown when the webhook is created
+	Events    []string  `json:"events"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Subscribes reports whether the webhook is sent events of type event.
+func (w *Webhook) Subscribes(event string) bool {
+	return slices.Contains(w.Events, event)
+}
+
+// Validate checks a webhook about to be created against the policy and fills
+// in its defaults: every event type and a random secret.
+func (w *Webhook) Validate(policy Policy) error {
+	if w.ChannelID == "" {
+		return errors.New("channel_id is required")
+	}
+	if w.OwnerID == "" {
+		return errors.New("owner_id is required")
+	}
+	if err := policy.CheckURL(w.URL); err != nil {
+		return err
+	}
+	if len(w.Events) == 0 {
+		w.Events = slices.Clone(Events)
+	}
+	for _, event := range w.Events {
+		if !slices.Contains(Events, event) {
+			return fmt.Errorf("unknown event %q, expected one of %v", event, Events)
+		}
+	}
+	if w.Secret == "" {
+		b := make([]byte, 32)
+		if _, err := rand.Read(b); err != nil {
+			return fmt.Errorf("failed to generate a secret: %w", err)
+		}
+		w.Secret = hex.EncodeToString(b)
+	}
+	return nil
+}
+
+// Event is an event of a channel, sent as the body of deliveries.
+type Event struct {
+	ID         string    `json:"id"`
+	Type       string    `json:"type"`
+	ChannelID  string    `json:"channel_id"`
+	OccurredAt time.Time `json:"occurred_at"`
+	Data       any       `json:"data"`
+}
+
+// Delivery is the delivery of an event to a webhook, and its log.
+type Delivery struct {
+	ID             string          `json:"id"`
+	WebhookID      string          `json:"webhook_id"`
+	Event          string          `json:"event"`
+	Payload        json.RawMessage `json:"payload"`
+	Status         string          `json:"status"`
+	Attempts       int             `json:"attempts"`
+	ResponseStatus int             `json:"response_status,omitempty"` // of the last attempt
+	LastError      string          `json:"last_error,omitempty"`
+	NextAttemptAt  time.Time       `json:"next_attempt_at"`
+	CreatedAt      time.Time       `json:"created_at"`
+	UpdatedAt      time.Time       `json:"updated_at"`
+}
+
+// Store keeps webhooks and their deliveries, and the owners of channels.
+type Store interface {
+	// SetChannelOwner makes ownerID the owner of a channel, who may register
+	// webhooks for it.
+	SetChannelOwner(ctx context.Context, channelID, ownerID string) error
+	// ChannelOwner returns the owner of a channel, or ErrNotFound.
+	ChannelOwner(ctx context.Context, channelID string) (string, error)
+
+	CreateWebhook(ctx context.Context, w *Webhook) error
+	Webhook(ctx context.Context, id string) (*Webhook, error)
+	ChannelWebhooks(ctx context.Context, channelID string) ([]Webhook, error)
+	OwnerWebhooks(ctx context.Context, ownerID string) ([]Webhook, error)
+	// DeleteWebhook deletes a webhook and its deliveries.
+	DeleteWebhook(ctx context.Context, id string) error
+
+	// SaveDelivery creates or updates a delivery.
+	SaveDelivery(ctx context.Context, d *Delivery) error
+	Delivery(ctx context.Context, webhookID, id string) (*Delivery, error)
+	// Deliveries returns the latest deliveries of a webhook, newest first,
+	// optionally only those with status.
+	Deliveries(ctx context.Context, webhookID, status string, limit int) ([]Delivery, error)
+	// PendingDeliveries returns every delivery still to be attempted.
+	PendingDeliveries(ctx context.Context) ([]Delivery, error)
+}
+
+// deliveryRetention is how long delivered deliveries stay in the log.
+const deliveryRetention = 7 * 24 * time.Hour
+
+// Sign returns the signature header of a payload sent at t: the Unix time
+// and the hex HMAC-SHA256 of "<time>.<payload>" keyed by the secret.
+func Sign(secret string, t time.Time, payload []byte) string {
+	ts := strconv.FormatInt(t.Unix(), 10)
+	return "t=" + ts + ",v1=" + signature(secret, ts, payload)
+}
+
+// Verify checks a signature header made by Sign, rejecting signatures older
+// than tolerance to prevent replays. Receivers written in Go can use it.
+func Verify(secret, header string, payload []byte, tolerance time.Duration) error {
+	var ts, sig string
+	for _, part := range strings.Split(header, ",") {
+		key, value, _ := strings.Cut(part, "=")
+		switch key {
+		case "t":
+			ts = value
+		case "v1":
+			sig = value
+		}
+	}
+	unix, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil || sig == "" {
+		return errors.New("malformed signature header")
+	}
+	if age := time.Since(time.Unix(unix, 0)); age > tolerance || age < -tolerance {
+		return errors.New("signature timestamp is outside the tolerance")
+	}
+	if !hmac.Equal([]byte(sig), []byte(signature(secret, ts, payload))) {
+		return errors.New("signature mismatch")
+	}
+	return nil
+}
+
+func signature(secret, ts string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(ts + "."))
+	mac.Write(payload)
+	return hex.EncodeToString(mac.Sum(nil))
+}